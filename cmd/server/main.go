@@ -0,0 +1,412 @@
+// Command server runs the watermark HTTP service.
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+
+	"watermark/internal/config"
+	"watermark/internal/handler"
+	"watermark/internal/health"
+	"watermark/internal/jobs"
+	"watermark/internal/locale"
+	"watermark/internal/measure"
+	"watermark/internal/presets"
+	"watermark/internal/processor"
+	"watermark/internal/service"
+	"watermark/internal/specs"
+	"watermark/internal/storage"
+	"watermark/internal/warmer"
+	"watermark/pkg/auth"
+	"watermark/pkg/logger"
+	"watermark/pkg/middleware"
+	"watermark/pkg/ratelimit"
+	"watermark/pkg/signing"
+)
+
+// readinessTimeout bounds each readiness check.
+const readinessTimeout = 2 * time.Second
+
+func main() {
+	// A .env file is optional; real deployments set the environment directly.
+	_ = godotenv.Load()
+
+	cfg, err := config.Load()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "failed to load config:", err)
+		os.Exit(1)
+	}
+
+	log := logger.NewLogger(cfg.LogLevel)
+	defer log.Sync()
+
+	if err := run(cfg, log); err != nil {
+		log.Errorw("Server failed", "error", err)
+		os.Exit(1)
+	}
+}
+
+// app holds the long-lived components that need shutting down.
+type app struct {
+	handler http.Handler
+	health  *handler.HealthHandler
+	writer  *service.CacheWriter
+	pool    *jobs.WorkerPool
+	warmer  *warmer.Warmer
+}
+
+func run(cfg *config.Config, log *logger.Logger) error {
+	a, err := build(cfg, log)
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:         fmt.Sprintf(":%d", cfg.ServerPort),
+		Handler:      a.handler,
+		ReadTimeout:  cfg.ServerReadTimeout,
+		WriteTimeout: cfg.ServerWriteTimeout,
+		IdleTimeout:  cfg.ServerIdleTimeout,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Infow("Server listening", "addr", srv.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serveErr:
+		return fmt.Errorf("server stopped: %w", err)
+	case <-ctx.Done():
+	}
+
+	log.Infow("Shutting down", "timeout", cfg.ShutdownTimeout)
+	a.health.SetDraining()
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	// Stop accepting requests first, then drain the background work they queued.
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Errorw("HTTP server shutdown incomplete", "error", err)
+	}
+	if err := a.warmer.Close(shutdownCtx); err != nil {
+		log.Errorw("Cache warming shutdown incomplete", "error", err)
+	}
+	if err := a.pool.Close(shutdownCtx); err != nil {
+		log.Errorw("Job worker shutdown incomplete", "error", err)
+	}
+	if err := a.writer.Close(shutdownCtx); err != nil {
+		log.Errorw("Cache write queue not fully drained", "error", err)
+	}
+	log.Infow("Shutdown complete")
+	return nil
+}
+
+// build composes storage, caches, the service and the HTTP routes from cfg.
+func build(cfg *config.Config, log *logger.Logger) (*app, error) {
+	slog := newLogrus(cfg.LogLevel)
+	checks := []health.Check{health.FontCheck(cfg.FontPath)}
+	usesRedis := cfg.Cache.Provider == "redis" || cfg.OriginCache.Provider == "redis" ||
+		cfg.Jobs.Provider == "redis" || cfg.RateLimit.Provider == "redis" ||
+		cfg.RenderPresets.Provider == "redis"
+	if usesRedis {
+		checks = append(checks, health.RedisCheck(cfg.Cache.Redis))
+	}
+
+	// Original images: S3, then retries and a breaker, then negative and originals caches.
+	s3Storage, err := storage.NewS3Storage(cfg.Storage.S3, slog)
+	if err != nil {
+		return nil, err
+	}
+	checks = append(checks, health.Check{Name: "s3", Run: s3Storage.Ping})
+
+	var imageStorage storage.ImageStorage = storage.NewResilientStorage(s3Storage, cfg.Storage.Resilience, slog)
+	// Stored metadata skips the caches below, so a new or edited sidecar is
+	// seen as soon as the metadata TTL allows.
+	var metadata *storage.MetadataStore
+	if cfg.Metadata.Enabled() {
+		metadata = storage.NewMetadataStore(imageStorage, cfg.Metadata, slog)
+	}
+	if cfg.Cache.NegativeTTL > 0 {
+		imageStorage = storage.NewNegativeCachedStorage(imageStorage, cfg.Cache.NegativeTTL, cfg.Cache.NegativeMaxEntries, slog)
+	}
+	originCache, err := storage.NewOriginCache(cfg.OriginCache, cfg.Cache.Redis, slog)
+	if err != nil {
+		return nil, err
+	}
+	if originCache != nil {
+		imageStorage = storage.NewCachedStorage(imageStorage, originCache, cfg.OriginCache.MaxObjectBytes, cfg.Timeouts.OriginFetch, slog)
+		if cfg.OriginCache.Provider == "local" {
+			checks = append(checks, health.DirWritableCheck("origin_cache_dir", cfg.OriginCache.Local.Path))
+		}
+	}
+
+	// Rendered images.
+	var cache storage.ImageCache
+	switch cfg.Cache.Provider {
+	case "redis":
+		cache = storage.NewRedisCache(cfg.Cache.Redis, cfg.CacheTTL, cfg.Cache.Grace(), slog)
+	case "local":
+		cache, err = storage.NewLocalCache(cfg.Cache.Local.Path, cfg.CacheTTL, cfg.Cache.Grace(), slog)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, health.DirWritableCheck("cache_dir", cfg.Cache.Local.Path))
+	default:
+		return nil, fmt.Errorf("unknown cache provider: %s", cfg.Cache.Provider)
+	}
+	cache = storage.NewResilientCache(cache, cfg.Cache.Resilience, slog)
+
+	var output storage.OutputStorage
+	if cfg.Storage.Publish.Bucket != "" {
+		s3Output, err := storage.NewS3Output(cfg.Storage.S3, cfg.Storage.Publish, slog)
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, health.Check{Name: "publish_bucket", Run: s3Output.Ping})
+		output = s3Output
+	}
+
+	fontBytes, err := os.ReadFile(cfg.FontPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read font: %w", err)
+	}
+	watermarkColor, err := processor.ParseHexColor(cfg.WatermarkColor)
+	if err != nil {
+		return nil, fmt.Errorf("invalid WATERMARK_COLOR: %w", err)
+	}
+	proc, err := processor.NewWatermarkProcessor(fontBytes, cfg.FontSize, watermarkColor, cfg.ImageQuality, cfg.MaxImagePixels)
+	if err != nil {
+		return nil, err
+	}
+	locales, err := locale.Load(cfg.LocalesDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load locales: %w", err)
+	}
+	log.Infow("Loaded locales", "locales", locales.Tags())
+
+	// The style env vars make up the default preset; the presets file may override it.
+	margin, opacity := int(cfg.FontSize/2), 1.0
+	styles, err := presets.Load(cfg.PresetsFile, presets.Definition{
+		Font:      cfg.FontPath,
+		FontSize:  cfg.FontSize,
+		Color:     cfg.WatermarkColor,
+		Placement: "bottom",
+		Margin:    &margin,
+		Opacity:   &opacity,
+		Format:    processor.FormatJPEG,
+		Quality:   cfg.ImageQuality,
+
+		WeightUnit:    string(measure.Kilogram),
+		DimensionUnit: string(measure.Centimetre),
+		Locale:        cfg.DefaultLocale,
+
+		Text:              presets.DefaultText,
+		VolumetricDivisor: cfg.VolumetricDivisor,
+	}, locales)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load watermark presets: %w", err)
+	}
+	log.Infow("Loaded watermark presets", "presets", styles.Names())
+
+	writer := service.NewCacheWriter(cache, service.CacheWriterConfig{
+		QueueSize: cfg.Cache.WriteQueue.Size,
+		Workers:   cfg.Cache.WriteQueue.Workers,
+		Timeout:   cfg.Cache.WriteQueue.Timeout,
+		Policy:    cfg.Cache.WriteQueue.Policy,
+	}, slog)
+	svc := service.NewImageService(imageStorage, cache, output, writer, proc, styles, locales,
+		metadata, cfg.Metadata.AllowOverride, cfg.Render.OverlayPrefixes,
+		service.CachePolicy{
+			StaleWhileRevalidate: cfg.Cache.StaleWhileRevalidate,
+			StaleIfError:         cfg.Cache.StaleIfError,
+			RevalidateAfter:      cfg.Cache.RevalidateAfter,
+		},
+		service.Timeouts{
+			Request:     cfg.Timeouts.Request,
+			CacheLookup: cfg.Timeouts.CacheLookup,
+			OriginFetch: cfg.Timeouts.OriginFetch,
+			Render:      cfg.Timeouts.Render,
+		},
+		slog,
+	)
+
+	var queue jobs.Queue
+	switch cfg.Jobs.Provider {
+	case "memory":
+		queue = jobs.NewMemoryQueue(cfg.Jobs.QueueSize, cfg.Jobs.TTL, cfg.Jobs.MaxResultBytes)
+	case "redis":
+		// Jobs out with a worker for longer than they can run were abandoned.
+		queue = jobs.NewRedisQueue(cfg.Cache.Redis, cfg.Jobs.TTL, cfg.Jobs.Timeout+time.Minute)
+	default:
+		return nil, fmt.Errorf("unknown jobs provider: %s", cfg.Jobs.Provider)
+	}
+	var presets specs.Store = specs.NewMemoryStore()
+	if cfg.RenderPresets.Provider == "redis" {
+		presets = specs.NewRedisStore(cfg.Cache.Redis)
+	}
+
+	pool := jobs.NewWorkerPool(queue, svc, cfg.Jobs.Workers, cfg.Jobs.Timeout, slog)
+	warm := warmer.NewWarmer(svc, cfg.Warm.Concurrency, slog)
+
+	authn, err := auth.New(auth.Options{
+		APIKeys:          cfg.Auth.APIKeys,
+		APIKeysFile:      cfg.Auth.APIKeysFile,
+		AdminToken:       cfg.AdminToken,
+		JWTSecret:        cfg.Auth.JWTSecret,
+		JWTPublicKeyFile: cfg.Auth.JWTPublicKeyFile,
+		JWKSFile:         cfg.Auth.JWKSFile,
+		JWTIssuer:        cfg.Auth.JWTIssuer,
+		JWTAudience:      cfg.Auth.JWTAudience,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up authentication: %w", err)
+	}
+
+	limit, err := newRateLimit(cfg.RateLimit, log)
+	if err != nil {
+		return nil, err
+	}
+
+	var signed func(http.Handler) http.Handler
+	if len(cfg.Signing.Keys) > 0 {
+		signer, err := signing.NewSigner(cfg.Signing.KeyID, cfg.Signing.Keys)
+		if err != nil {
+			return nil, err
+		}
+		signed = middleware.SignedURLMiddleware(signer, log)
+	}
+
+	healthHandler := handler.NewHealthHandler(health.NewChecker(readinessTimeout, checks...), log)
+	imageHandler := handler.NewImageHandler(svc, handler.CacheControl{
+		MaxAge:               cfg.CacheTTL,
+		StaleWhileRevalidate: cfg.Cache.StaleWhileRevalidate,
+		StaleIfError:         cfg.Cache.StaleIfError,
+	}, log)
+	uploadHandler := handler.NewUploadHandler(svc, cfg.MaxUploadBytes, log)
+	jobHandler := handler.NewJobHandler(queue, svc, log)
+	batchHandler := handler.NewBatchHandler(svc, handler.BatchLimits{
+		MaxItems:    cfg.Batch.MaxItems,
+		MaxBytes:    cfg.Batch.MaxBytes,
+		Concurrency: cfg.Batch.Concurrency,
+		// Archives are streamed, so each entry gets the usual write timeout.
+		EntryTimeout: cfg.ServerWriteTimeout,
+	}, log)
+	renderHandler := handler.NewRenderHandler(svc, presets, log)
+	adminHandler := handler.NewAdminHandler(svc, warm, cfg.Warm.MaxItems, log)
+
+	// requireScope authenticates a route group. Admin routes always need credentials.
+	requireScope := func(scope string) func(http.Handler) http.Handler {
+		required := cfg.Auth.Required || scope == auth.ScopeAdmin
+		return middleware.AuthMiddleware(authn, scope, required, log)
+	}
+	// requireUnsigned authenticates a route group that signed URLs don't
+	// cover. With signing on, those always need credentials, or they would be
+	// a way around it.
+	requireUnsigned := func(scope string) func(http.Handler) http.Handler {
+		required := cfg.Auth.Required || signed != nil
+		return middleware.AuthMiddleware(authn, scope, required, log)
+	}
+
+	r := mux.NewRouter()
+	r.Use(middleware.RecoveryMiddleware(log), middleware.LoggingMiddleware(log), middleware.CORSMiddleware())
+
+	r.HandleFunc("/healthz", healthHandler.Healthz).Methods(http.MethodGet)
+	r.HandleFunc("/readyz", healthHandler.Readyz).Methods(http.MethodGet)
+	r.Handle("/metrics", promhttp.Handler()).Methods(http.MethodGet)
+
+	images := r.PathPrefix("/image").Subrouter()
+	if signed != nil {
+		// Signatures are checked first, as they are the cheapest way to turn a request away.
+		images.Use(signed)
+	}
+	images.Use(requireScope(auth.ScopeRead), limit)
+	images.HandleFunc("/{id:.+}", imageHandler.GetImage).Methods(http.MethodGet, http.MethodHead)
+
+	upload := r.PathPrefix("/watermark").Subrouter()
+	upload.Use(requireUnsigned(auth.ScopeRead), limit)
+	upload.HandleFunc("", uploadHandler.Watermark).Methods(http.MethodPost)
+
+	render := r.PathPrefix("/render").Subrouter()
+	render.Use(requireUnsigned(auth.ScopeRead), limit)
+	render.HandleFunc("", renderHandler.Render).Methods(http.MethodPost)
+	render.HandleFunc("/presets/{name}", renderHandler.GetPreset).Methods(http.MethodGet)
+
+	jobRoutes := r.PathPrefix("/jobs").Subrouter()
+	jobRoutes.Use(requireUnsigned(auth.ScopeRead), limit)
+	jobRoutes.HandleFunc("", jobHandler.CreateJob).Methods(http.MethodPost)
+	jobRoutes.HandleFunc("/{id}", jobHandler.GetJob).Methods(http.MethodGet)
+	jobRoutes.HandleFunc("/{id}/result", jobHandler.GetJobResult).Methods(http.MethodGet)
+
+	batch := r.PathPrefix("/batch").Subrouter()
+	batch.Use(requireUnsigned(auth.ScopeBatch), limit)
+	batch.HandleFunc("", batchHandler.CreateBatch).Methods(http.MethodPost)
+
+	admin := r.PathPrefix("/admin").Subrouter()
+	admin.Use(requireScope(auth.ScopeAdmin))
+	admin.HandleFunc("/warm", adminHandler.WarmCache).Methods(http.MethodPost)
+	admin.HandleFunc("/warm/{id}", adminHandler.GetWarmStatus).Methods(http.MethodGet)
+	admin.HandleFunc("/cache", adminHandler.PurgePrefix).Methods(http.MethodDelete)
+	admin.HandleFunc("/cache/{id:.+}", adminHandler.PurgeImage).Methods(http.MethodDelete)
+	admin.HandleFunc("/presets/{name}", renderHandler.PutPreset).Methods(http.MethodPut)
+	admin.HandleFunc("/presets/{name}", renderHandler.DeletePreset).Methods(http.MethodDelete)
+
+	return &app{
+		handler: r,
+		health:  healthHandler,
+		writer:  writer,
+		pool:    pool,
+		warmer:  warm,
+	}, nil
+}
+
+// newRateLimit builds the rate limiting middleware, or a pass-through one when it's disabled.
+func newRateLimit(cfg config.RateLimitConfig, log *logger.Logger) (func(http.Handler) http.Handler, error) {
+	var limiter ratelimit.Limiter
+	switch cfg.Provider {
+	case "none":
+		return func(next http.Handler) http.Handler { return next }, nil
+	case "memory":
+		limiter = ratelimit.NewMemoryLimiter()
+	case "redis":
+		redisLimiter, err := ratelimit.NewRedisLimiter(cfg.Redis)
+		if err != nil {
+			return nil, err
+		}
+		limiter = redisLimiter
+	}
+
+	trusted, err := middleware.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RATE_LIMIT_TRUSTED_PROXIES: %w", err)
+	}
+	return middleware.RateLimitMiddleware(limiter, middleware.RateLimitOptions{
+		Hits:           ratelimit.PerMinute(cfg.HitsPerMinute, cfg.HitsBurst),
+		Renders:        ratelimit.PerMinute(cfg.RendersPerMinute, cfg.RendersBurst),
+		TrustedProxies: trusted,
+	}, log), nil
+}
+
+// newLogrus creates the logger used by the storage and service layers.
+func newLogrus(level string) *logrus.Logger {
+	l := logrus.New()
+	l.SetFormatter(&logrus.JSONFormatter{})
+	if lvl, err := logrus.ParseLevel(level); err == nil {
+		l.SetLevel(lvl)
+	}
+	return l
+}
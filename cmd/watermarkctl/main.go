@@ -0,0 +1,40 @@
+// Command watermarkctl provides operational tooling for the watermark service.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+const usage = `Usage: watermarkctl <command> [flags]
+
+Commands:
+  warm    Pre-warm the cache for a list of images
+  sign    Print signed image URLs
+`
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "warm":
+		err = runWarm(os.Args[2:])
+	case "sign":
+		err = runSign(os.Args[2:])
+	case "-h", "--help", "help":
+		fmt.Print(usage)
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n%s", os.Args[1], usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "error:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"watermark/internal/warmer"
+)
+
+// runWarm submits a warm request to the server's admin API and optionally
+// waits for it to finish, printing progress.
+func runWarm(args []string) error {
+	fs := flag.NewFlagSet("warm", flag.ExitOnError)
+	server := fs.String("server", envOr("WATERMARK_SERVER", "http://localhost:8080"), "Base URL of the watermark service")
+	token := fs.String("token", os.Getenv("ADMIN_TOKEN"), "Admin API token")
+	file := fs.String("file", "", "File with image keys: one per line, CSV (image_id,weight,dimensions) or a .json warm spec; - for stdin")
+	weight := fs.String("weight", "", "Default weight for items that don't set one")
+	dimensions := fs.String("dimensions", "", "Default dimensions for items that don't set them")
+	preset := fs.String("preset", "", "Default watermark preset for items that don't set one")
+	lang := fs.String("lang", "", "Default overlay language for items that don't set one")
+	wait := fs.Bool("wait", true, "Wait for warming to finish and report progress")
+	interval := fs.Duration("interval", 2*time.Second, "Progress polling interval")
+	fs.Usage = func() {
+		fmt.Fprint(fs.Output(), "Usage: watermarkctl warm [flags] [image-key ...]\n\n")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	body, contentType, err := warmBody(*file, fs.Args())
+	if err != nil {
+		return err
+	}
+
+	q := url.Values{}
+	if *weight != "" {
+		q.Set("weight", *weight)
+	}
+	if *dimensions != "" {
+		q.Set("dimensions", *dimensions)
+	}
+	if *preset != "" {
+		q.Set("preset", *preset)
+	}
+	if *lang != "" {
+		q.Set("lang", *lang)
+	}
+	endpoint := strings.TrimSuffix(*server, "/") + "/admin/warm"
+	if len(q) > 0 {
+		endpoint += "?" + q.Encode()
+	}
+
+	var status warmer.Status
+	if err := adminRequest(http.MethodPost, endpoint, *token, contentType, body, &status); err != nil {
+		return err
+	}
+	fmt.Printf("Warm task %s started for %d images\n", status.ID, status.Total)
+	if !*wait {
+		return nil
+	}
+
+	statusURL := strings.TrimSuffix(*server, "/") + "/admin/warm/" + url.PathEscape(status.ID)
+	for status.FinishedAt == nil {
+		time.Sleep(*interval)
+		if err := adminRequest(http.MethodGet, statusURL, *token, "", nil, &status); err != nil {
+			return err
+		}
+		fmt.Printf("%d/%d done (%d rendered, %d already cached, %d failed)\n",
+			status.Completed, status.Total, status.Rendered, status.AlreadyCached, status.Failed)
+	}
+
+	for _, f := range status.Failures {
+		fmt.Printf("FAILED %s: %s\n", f.ImageID, f.Error)
+	}
+	if status.Status != warmer.StatusDone {
+		return fmt.Errorf("warm task %s", status.Status)
+	}
+	if status.Failed > 0 {
+		return fmt.Errorf("%d of %d images failed", status.Failed, status.Total)
+	}
+	return nil
+}
+
+// warmBody builds the request body from a file or from image keys given as arguments.
+func warmBody(file string, keys []string) (io.Reader, string, error) {
+	if file == "" {
+		if len(keys) == 0 {
+			return nil, "", errors.New("no images given: pass image keys as arguments or use -file")
+		}
+		return strings.NewReader(strings.Join(keys, "\n")), "text/plain", nil
+	}
+
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	switch {
+	case strings.EqualFold(filepath.Ext(file), ".json"):
+		return bytes.NewReader(data), "application/json", nil
+	case strings.EqualFold(filepath.Ext(file), ".csv"):
+		return bytes.NewReader(data), "text/csv", nil
+	default:
+		return bytes.NewReader(data), "text/plain", nil
+	}
+}
+
+// adminRequest calls the admin API and decodes a JSON response into out.
+func adminRequest(method, endpoint, token, contentType string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequest(method, endpoint, body)
+	if err != nil {
+		return err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("%s %s: %s: %s", method, endpoint, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func envOr(key, fallback string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	return fallback
+}
@@ -4,9 +4,12 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
+
+	"watermark/pkg/signing"
 )
 
 // --- Top Level Config ---
@@ -16,21 +19,81 @@ type Config struct {
 	ServerReadTimeout  time.Duration
 	ServerWriteTimeout time.Duration
 	ServerIdleTimeout  time.Duration
+	ShutdownTimeout    time.Duration
+	Timeouts           TimeoutConfig
 	Storage            StorageConfig
 	Cache              CacheConfig
 	CacheTTL           time.Duration
+	OriginCache        OriginCacheConfig
+	Jobs               JobsConfig
+	Batch              BatchConfig
+	Warm               WarmConfig
+	Render             RenderConfig
+	RenderPresets      RenderPresetsConfig
+	Metadata           MetadataConfig
+	AdminToken         string
+	Auth               AuthConfig
+	Signing            SigningConfig
+	RateLimit          RateLimitConfig
 	FontPath           string
 	FontSize           float64
 	WatermarkColor     string
+	PresetsFile        string // YAML or JSON file of named watermark presets
+	VolumetricDivisor  string // e.g. 5000 (cm³/kg) or 139in (in³/lb)
+	LocalesDir         string // Extra or overriding overlay label catalogs
+	DefaultLocale      string
 	ImageQuality       int
+	MaxImagePixels     int
+	MaxUploadBytes     int64
 	LogLevel           string
 }
 
+// TimeoutConfig holds the per-request deadline budget and the per-stage
+// timeouts carved from it.
+type TimeoutConfig struct {
+	Request     time.Duration
+	CacheLookup time.Duration
+	OriginFetch time.Duration
+	Render      time.Duration
+}
+
+// AuthConfig configures API key and JWT authentication.
+type AuthConfig struct {
+	Required         bool   // Whether read and batch routes reject anonymous requests
+	APIKeys          string // name:sha256hex:scopes entries separated by semicolons
+	APIKeysFile      string // File of API key entries, one per line
+	JWTSecret        string // HS256 secret
+	JWTPublicKeyFile string // PEM RSA (RS256) or Ed25519 (EdDSA) public key
+	JWKSFile         string // Local JSON Web Key Set
+	JWTIssuer        string
+	JWTAudience      string
+}
+
+// RateLimitConfig configures per-client token buckets. Limits are per minute;
+// a zero rate disables that budget.
+type RateLimitConfig struct {
+	Provider         string // "none", "memory" or "redis"
+	Redis            RedisConfig
+	HitsPerMinute    int
+	HitsBurst        int
+	RendersPerMinute int
+	RendersBurst     int
+	TrustedProxies   string // Comma-separated CIDRs whose X-Forwarded-For is trusted
+}
+
+// SigningConfig holds the keys for signed image URLs. With no keys, URLs aren't checked.
+type SigningConfig struct {
+	Keys  map[string][]byte // Secrets by key ID; all of them are accepted
+	KeyID string            // Key used to sign new URLs; may be empty with a single key
+}
+
 // --- Storage Configuration ---
 
 type StorageConfig struct {
-	Provider string
-	S3       S3Config
+	Provider   string
+	S3         S3Config
+	Resilience ResilienceConfig
+	Publish    PublishConfig
 }
 
 // S3Config supports AWS S3 and S3-compatible services like Cloudflare R2.
@@ -41,20 +104,131 @@ type S3Config struct {
 	Prefix          string
 	AccessKeyID     string
 	SecretAccessKey string
+	MaxObjectBytes  int64 // Larger source images are rejected; 0 disables the check
+}
+
+// PublishConfig controls "publish" mode, where renders are written to a
+// destination bucket and clients are redirected to them. Empty Bucket disables it.
+type PublishConfig struct {
+	Bucket        string
+	Prefix        string
+	PublicBaseURL string // Serve published objects from here instead of presigned URLs
+	PresignTTL    time.Duration
+}
+
+// --- Resilience Configuration ---
+
+// ResilienceConfig holds the retry policy and circuit breaker settings for a backend.
+type ResilienceConfig struct {
+	Retry   RetryConfig
+	Breaker BreakerConfig
+}
+
+// RetryConfig controls exponential backoff with jitter.
+type RetryConfig struct {
+	MaxAttempts int // Including the first call
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+}
+
+// BreakerConfig controls when a circuit breaker opens and how long it stays open.
+type BreakerConfig struct {
+	FailureThreshold int // Consecutive failures before opening
+	OpenTimeout      time.Duration
 }
 
 // --- Cache Configuration ---
 
 type CacheConfig struct {
-	Provider string
-	Redis    RedisConfig
-	Local    LocalCacheConfig
+	Provider             string
+	Redis                RedisConfig
+	Local                LocalCacheConfig
+	StaleWhileRevalidate time.Duration // Serve expired renders while refreshing them in the background
+	StaleIfError         time.Duration // Serve expired renders when the origin fails
+	RevalidateAfter      time.Duration // How long renders are trusted before checking the origin for changes; 0 disables
+	NegativeTTL          time.Duration // How long missing origin objects are remembered; 0 disables
+	NegativeMaxEntries   int
+	WriteQueue           WriteQueueConfig
+	Resilience           ResilienceConfig
+}
+
+// WriteQueueConfig controls the background queue that writes renders to the cache.
+type WriteQueueConfig struct {
+	Size    int
+	Workers int
+	Timeout time.Duration // Per-write timeout
+	Policy  string        // "drop-oldest" or "block" when the queue is full
 }
 
 type LocalCacheConfig struct {
 	Path string
 }
 
+// OriginCacheConfig controls the optional cache tier for original source images.
+// It sits in front of the storage backend, so renders of the same source with
+// different watermark texts only fetch the original once.
+type OriginCacheConfig struct {
+	Provider       string // "none", "memory", "local" or "redis"
+	TTL            time.Duration
+	MaxBytes       int64 // Total budget for the memory provider
+	MaxObjectBytes int64 // Originals larger than this are never cached
+	Local          LocalCacheConfig
+}
+
+// --- Jobs Configuration ---
+
+// JobsConfig controls asynchronous render jobs.
+type JobsConfig struct {
+	Provider  string // "memory" or "redis"
+	Workers   int
+	QueueSize int // Pending job limit for the memory provider
+	Timeout   time.Duration
+	TTL       time.Duration // How long job records are kept after their last update
+	// MaxResultBytes bounds the job output the memory provider keeps.
+	MaxResultBytes int64
+}
+
+// BatchConfig bounds POST /batch requests.
+type BatchConfig struct {
+	MaxItems    int
+	MaxBytes    int64
+	Concurrency int
+}
+
+// WarmConfig controls cache pre-warming.
+type WarmConfig struct {
+	MaxItems    int
+	Concurrency int
+}
+
+// RenderConfig controls what render specs may draw.
+type RenderConfig struct {
+	// OverlayPrefixes are the storage key prefixes image layers may load
+	// overlays from. With none, image layers are refused.
+	OverlayPrefixes []string
+}
+
+// RenderPresetsConfig controls where named render specs are kept.
+type RenderPresetsConfig struct {
+	Provider string // "memory" or "redis"
+}
+
+// MetadataConfig controls where overlay data stored with images is read from.
+type MetadataConfig struct {
+	Sidecar        bool // A JSON object next to the image, e.g. 123.json for 123.jpg
+	ObjectMetadata bool // The image's S3 user metadata (x-amz-meta-*)
+	// AllowOverride lets request parameters replace stored values instead of
+	// only filling in what is missing.
+	AllowOverride bool
+	TTL           time.Duration
+	MaxEntries    int
+}
+
+// Enabled reports whether any metadata source is configured.
+func (c MetadataConfig) Enabled() bool {
+	return c.Sidecar || c.ObjectMetadata
+}
+
 type RedisConfig struct {
 	Addr     string
 	Password string
@@ -69,6 +243,11 @@ func Load() (*Config, error) {
 		return nil, err
 	}
 
+	signingKeys, err := signing.ParseKeys(getEnv("URL_SIGNING_KEYS", ""))
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL_SIGNING_KEYS: %w", err)
+	}
+
 	storageProvider := getEnv("STORAGE_PROVIDER", "s3")
 	cacheProvider := getEnv("CACHE_PROVIDER", "redis")
 
@@ -77,6 +256,13 @@ func Load() (*Config, error) {
 		ServerReadTimeout:  getEnvAsDuration("SERVER_READ_TIMEOUT", 10*time.Second),
 		ServerWriteTimeout: getEnvAsDuration("SERVER_WRITE_TIMEOUT", 30*time.Second),
 		ServerIdleTimeout:  getEnvAsDuration("SERVER_IDLE_TIMEOUT", 60*time.Second),
+		ShutdownTimeout:    getEnvAsDuration("SHUTDOWN_TIMEOUT", 30*time.Second),
+		Timeouts: TimeoutConfig{
+			Request:     getEnvAsDuration("REQUEST_TIMEOUT", 25*time.Second),
+			CacheLookup: getEnvAsDuration("CACHE_LOOKUP_TIMEOUT", 500*time.Millisecond),
+			OriginFetch: getEnvAsDuration("ORIGIN_FETCH_TIMEOUT", 10*time.Second),
+			Render:      getEnvAsDuration("RENDER_TIMEOUT", 15*time.Second),
+		},
 		Storage: StorageConfig{
 			Provider: storageProvider,
 			S3: S3Config{
@@ -86,7 +272,15 @@ func Load() (*Config, error) {
 				Prefix:          getEnv("S3_PREFIX", "qc-images/"),
 				AccessKeyID:     getEnv("S3_ACCESS_KEY_ID", ""),
 				SecretAccessKey: getEnv("S3_SECRET_ACCESS_KEY", ""),
+				MaxObjectBytes:  getEnvAsInt64("MAX_IMAGE_BYTES", 100<<20),
 			},
+			Publish: PublishConfig{
+				Bucket:        getEnv("PUBLISH_BUCKET", ""),
+				Prefix:        getEnv("PUBLISH_PREFIX", "watermarked/"),
+				PublicBaseURL: getEnv("PUBLISH_PUBLIC_BASE_URL", ""),
+				PresignTTL:    getEnvAsDuration("PUBLISH_PRESIGN_TTL", time.Hour),
+			},
+			Resilience: loadResilienceConfig("STORAGE", 3, 100*time.Millisecond, 2*time.Second, 5, 30*time.Second),
 		},
 		Cache: CacheConfig{
 			Provider: cacheProvider,
@@ -94,19 +288,146 @@ func Load() (*Config, error) {
 			Local: LocalCacheConfig{
 				Path: getEnv("LOCAL_CACHE_PATH", "./cache"),
 			},
+			StaleWhileRevalidate: getEnvAsDuration("CACHE_STALE_WHILE_REVALIDATE", time.Minute),
+			StaleIfError:         getEnvAsDuration("CACHE_STALE_IF_ERROR", 24*time.Hour),
+			RevalidateAfter:      getEnvAsDuration("CACHE_REVALIDATE_AFTER", 10*time.Minute),
+			NegativeTTL:          getEnvAsDuration("NEGATIVE_CACHE_TTL", 30*time.Second),
+			NegativeMaxEntries:   getEnvAsInt("NEGATIVE_CACHE_MAX_ENTRIES", 10000),
+			WriteQueue: WriteQueueConfig{
+				Size:    getEnvAsInt("CACHE_WRITE_QUEUE_SIZE", 1000),
+				Workers: getEnvAsInt("CACHE_WRITE_WORKERS", 4),
+				Timeout: getEnvAsDuration("CACHE_WRITE_TIMEOUT", 5*time.Second),
+				Policy:  getEnv("CACHE_WRITE_POLICY", "drop-oldest"),
+			},
+			Resilience: loadResilienceConfig("CACHE", 2, 20*time.Millisecond, 200*time.Millisecond, 5, 10*time.Second),
+		},
+		CacheTTL: getEnvAsDuration("CACHE_TTL", 7*24*time.Hour),
+		OriginCache: OriginCacheConfig{
+			Provider:       getEnv("ORIGIN_CACHE_PROVIDER", "none"),
+			TTL:            getEnvAsDuration("ORIGIN_CACHE_TTL", time.Hour),
+			MaxBytes:       getEnvAsInt64("ORIGIN_CACHE_MAX_BYTES", 256<<20),
+			MaxObjectBytes: getEnvAsInt64("ORIGIN_CACHE_MAX_OBJECT_BYTES", 32<<20),
+			Local: LocalCacheConfig{
+				Path: getEnv("ORIGIN_CACHE_PATH", "./cache/originals"),
+			},
 		},
-		CacheTTL:       getEnvAsDuration("CACHE_TTL", 7*24*time.Hour),
-		FontPath:       getEnv("FONT_PATH", "./fonts/Arial.ttf"),
-		FontSize:       getEnvAsFloat("FONT_SIZE", 24.0),
-		WatermarkColor: getEnv("WATERMARK_COLOR", "#FFFFFF"),
-		ImageQuality:   getEnvAsInt("IMAGE_QUALITY", 90),
-		LogLevel:       getEnv("LOG_LEVEL", "info"),
+		Metadata: MetadataConfig{
+			AllowOverride: getEnvAsBool("METADATA_ALLOW_OVERRIDE", false),
+			TTL:           getEnvAsDuration("METADATA_TTL", 30*time.Second),
+			MaxEntries:    getEnvAsInt("METADATA_MAX_ENTRIES", 10000),
+		},
+		Jobs: JobsConfig{
+			Provider:  getEnv("JOBS_PROVIDER", "memory"),
+			Workers:   getEnvAsInt("JOBS_WORKERS", 2),
+			QueueSize: getEnvAsInt("JOBS_QUEUE_SIZE", 100),
+			Timeout:   getEnvAsDuration("JOBS_TIMEOUT", 5*time.Minute),
+			TTL:       getEnvAsDuration("JOBS_TTL", 24*time.Hour),
+
+			MaxResultBytes: getEnvAsInt64("JOBS_MAX_RESULT_BYTES", 256<<20),
+		},
+		Batch: BatchConfig{
+			MaxItems:    getEnvAsInt("BATCH_MAX_ITEMS", 100),
+			MaxBytes:    getEnvAsInt64("BATCH_MAX_BYTES", 512<<20),
+			Concurrency: getEnvAsInt("BATCH_CONCURRENCY", 4),
+		},
+		Warm: WarmConfig{
+			MaxItems:    getEnvAsInt("WARM_MAX_ITEMS", 10000),
+			Concurrency: getEnvAsInt("WARM_CONCURRENCY", 4),
+		},
+		RenderPresets: RenderPresetsConfig{
+			Provider: getEnv("RENDER_PRESETS_PROVIDER", "memory"),
+		},
+		Auth: AuthConfig{
+			Required:         getEnvAsBool("AUTH_REQUIRED", false),
+			APIKeys:          getEnv("AUTH_API_KEYS", ""),
+			APIKeysFile:      getEnv("AUTH_API_KEYS_FILE", ""),
+			JWTSecret:        getEnv("AUTH_JWT_SECRET", ""),
+			JWTPublicKeyFile: getEnv("AUTH_JWT_PUBLIC_KEY_FILE", ""),
+			JWKSFile:         getEnv("AUTH_JWKS_FILE", ""),
+			JWTIssuer:        getEnv("AUTH_JWT_ISSUER", ""),
+			JWTAudience:      getEnv("AUTH_JWT_AUDIENCE", ""),
+		},
+		Signing: SigningConfig{
+			Keys:  signingKeys,
+			KeyID: getEnv("URL_SIGNING_KEY_ID", ""),
+		},
+		RateLimit: RateLimitConfig{
+			Provider:         getEnv("RATE_LIMIT_PROVIDER", "none"),
+			Redis:            *redisConfig,
+			HitsPerMinute:    getEnvAsInt("RATE_LIMIT_HITS_PER_MINUTE", 600),
+			HitsBurst:        getEnvAsInt("RATE_LIMIT_HITS_BURST", 100),
+			RendersPerMinute: getEnvAsInt("RATE_LIMIT_RENDERS_PER_MINUTE", 60),
+			RendersBurst:     getEnvAsInt("RATE_LIMIT_RENDERS_BURST", 10),
+			TrustedProxies:   getEnv("RATE_LIMIT_TRUSTED_PROXIES", ""),
+		},
+		AdminToken:        getEnv("ADMIN_TOKEN", ""),
+		FontPath:          getEnv("FONT_PATH", "./fonts/Arial.ttf"),
+		FontSize:          getEnvAsFloat("FONT_SIZE", 24.0),
+		WatermarkColor:    getEnv("WATERMARK_COLOR", "#FFFFFF"),
+		PresetsFile:       getEnv("WATERMARK_PRESETS_FILE", ""),
+		VolumetricDivisor: getEnv("VOLUMETRIC_DIVISOR", "5000"),
+		LocalesDir:        getEnv("LOCALES_DIR", ""),
+		DefaultLocale:     getEnv("DEFAULT_LOCALE", "en"),
+		ImageQuality:      getEnvAsInt("IMAGE_QUALITY", 90),
+		MaxImagePixels:    getEnvAsInt("MAX_IMAGE_PIXELS", 100_000_000),
+		MaxUploadBytes:    getEnvAsInt64("MAX_UPLOAD_BYTES", 20<<20),
+		LogLevel:          getEnv("LOG_LEVEL", "info"),
 	}
 
 	if cfg.Storage.S3.Bucket == "" {
 		return nil, fmt.Errorf("S3_BUCKET environment variable is required")
 	}
 
+	if cfg.Cache.WriteQueue.Size < 1 {
+		return nil, fmt.Errorf("invalid CACHE_WRITE_QUEUE_SIZE %d: must be at least 1", cfg.Cache.WriteQueue.Size)
+	}
+	switch cfg.Cache.WriteQueue.Policy {
+	case "drop-oldest", "block":
+	default:
+		return nil, fmt.Errorf("invalid CACHE_WRITE_POLICY %q: must be drop-oldest or block", cfg.Cache.WriteQueue.Policy)
+	}
+
+	switch cfg.RateLimit.Provider {
+	case "none", "memory", "redis":
+	default:
+		return nil, fmt.Errorf("invalid RATE_LIMIT_PROVIDER %q: must be none, memory or redis", cfg.RateLimit.Provider)
+	}
+
+	for _, prefix := range strings.Split(getEnv("RENDER_OVERLAY_PREFIXES", "overlays/"), ",") {
+		prefix = strings.TrimSpace(prefix)
+		switch {
+		case prefix == "":
+		case strings.Contains(prefix, ".."):
+			return nil, fmt.Errorf("invalid RENDER_OVERLAY_PREFIXES entry %q: must not contain ..", prefix)
+		default:
+			cfg.Render.OverlayPrefixes = append(cfg.Render.OverlayPrefixes, prefix)
+		}
+	}
+
+	for _, source := range strings.Split(getEnv("METADATA_SOURCES", ""), ",") {
+		switch strings.TrimSpace(source) {
+		case "":
+		case "sidecar":
+			cfg.Metadata.Sidecar = true
+		case "object":
+			cfg.Metadata.ObjectMetadata = true
+		default:
+			return nil, fmt.Errorf("invalid METADATA_SOURCES entry %q: must be sidecar or object", source)
+		}
+	}
+
+	switch cfg.RenderPresets.Provider {
+	case "memory", "redis":
+	default:
+		return nil, fmt.Errorf("invalid RENDER_PRESETS_PROVIDER %q: must be memory or redis", cfg.RenderPresets.Provider)
+	}
+
+	if len(cfg.Signing.Keys) > 0 {
+		if _, err := signing.NewSigner(cfg.Signing.KeyID, cfg.Signing.Keys); err != nil {
+			return nil, fmt.Errorf("invalid URL_SIGNING_KEY_ID: %w", err)
+		}
+	}
+
 	return cfg, nil
 }
 
@@ -131,6 +452,29 @@ func loadRedisConfig() (*RedisConfig, error) {
 	}, nil
 }
 
+// Grace is how long expired cache entries are retained so they can be served stale.
+func (c CacheConfig) Grace() time.Duration {
+	if c.StaleIfError > c.StaleWhileRevalidate {
+		return c.StaleIfError
+	}
+	return c.StaleWhileRevalidate
+}
+
+// loadResilienceConfig reads <PREFIX>_RETRY_* and <PREFIX>_BREAKER_* variables.
+func loadResilienceConfig(prefix string, attempts int, baseDelay, maxDelay time.Duration, threshold int, openTimeout time.Duration) ResilienceConfig {
+	return ResilienceConfig{
+		Retry: RetryConfig{
+			MaxAttempts: getEnvAsInt(prefix+"_RETRY_MAX_ATTEMPTS", attempts),
+			BaseDelay:   getEnvAsDuration(prefix+"_RETRY_BASE_DELAY", baseDelay),
+			MaxDelay:    getEnvAsDuration(prefix+"_RETRY_MAX_DELAY", maxDelay),
+		},
+		Breaker: BreakerConfig{
+			FailureThreshold: getEnvAsInt(prefix+"_BREAKER_FAILURE_THRESHOLD", threshold),
+			OpenTimeout:      getEnvAsDuration(prefix+"_BREAKER_OPEN_TIMEOUT", openTimeout),
+		},
+	}
+}
+
 // --- Env Helper Functions ---
 
 func getEnv(key, fallback string) string {
@@ -149,6 +493,15 @@ func getEnvAsInt(key string, fallback int) int {
 	return fallback
 }
 
+func getEnvAsInt64(key string, fallback int64) int64 {
+	if value, ok := os.LookupEnv(key); ok {
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			return i
+		}
+	}
+	return fallback
+}
+
 func getEnvAsFloat(key string, fallback float64) float64 {
 	if value, ok := os.LookupEnv(key); ok {
 		if f, err := strconv.ParseFloat(value, 64); err == nil {
@@ -158,6 +511,15 @@ func getEnvAsFloat(key string, fallback float64) float64 {
 	return fallback
 }
 
+func getEnvAsBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
+
 func getEnvAsDuration(key string, fallback time.Duration) time.Duration {
 	if value, ok := os.LookupEnv(key); ok {
 		if d, err := time.ParseDuration(value); err == nil {
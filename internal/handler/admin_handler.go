@@ -0,0 +1,134 @@
+package handler
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"watermark/internal/service"
+	"watermark/internal/warmer"
+	"watermark/pkg/logger"
+
+	"github.com/gorilla/mux"
+)
+
+// maxWarmBodyBytes bounds the size of a POST /admin/warm body.
+const maxWarmBodyBytes = 10 << 20
+
+type AdminHandler struct {
+	service      *service.ImageService
+	warmer       *warmer.Warmer
+	maxWarmItems int
+	logger       *logger.Logger
+}
+
+func NewAdminHandler(service *service.ImageService, warmer *warmer.Warmer, maxWarmItems int, logger *logger.Logger) *AdminHandler {
+	return &AdminHandler{
+		service:      service,
+		warmer:       warmer,
+		maxWarmItems: maxWarmItems,
+		logger:       logger,
+	}
+}
+
+// WarmCache handles POST /admin/warm. The body is either a JSON warm spec or,
+// with a text/plain or text/csv content type, a list of image keys; the
+// weight and dimensions query parameters then provide the defaults.
+func (h *AdminHandler) WarmCache(w http.ResponseWriter, r *http.Request) {
+	body := http.MaxBytesReader(w, r.Body, maxWarmBodyBytes)
+
+	var spec warmer.Spec
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	switch mediaType {
+	case "text/plain", "text/csv":
+		var defaults service.RenderParams
+		defaults, err = service.ParseRenderParams(r.URL.Query())
+		if err == nil {
+			spec, err = warmer.ParseList(body, defaults)
+		}
+	default:
+		spec, err = warmer.ParseJSON(body)
+	}
+	if err != nil {
+		respondInvalidInput(w, "", err)
+		return
+	}
+
+	if len(spec.Items) == 0 {
+		respondError(w, http.StatusBadRequest, CodeInvalidInput, "No images to warm")
+		return
+	}
+	if len(spec.Items) > h.maxWarmItems {
+		respondError(w, http.StatusRequestEntityTooLarge, CodeTooLarge,
+			fmt.Sprintf("Warm request has %d items, the limit is %d", len(spec.Items), h.maxWarmItems))
+		return
+	}
+
+	reqs, err := spec.Requests(h.service.UsesMetadata())
+	for i := 0; err == nil && i < len(reqs); i++ {
+		if reqs[i].Locale, err = h.service.Locale(reqs[i].Locale, ""); err != nil {
+			err = fmt.Errorf("item %d: %w", i+1, err)
+		}
+	}
+	if err != nil {
+		respondError(w, http.StatusBadRequest, CodeInvalidInput, err.Error())
+		return
+	}
+
+	status, err := h.warmer.Start(reqs)
+	if err != nil {
+		h.logger.Errorw("Failed to start cache warming", "error", err)
+		respondError(w, http.StatusInternalServerError, CodeInternal, "Failed to start cache warming")
+		return
+	}
+
+	h.logger.Infow("Cache warming requested", "taskID", status.ID, "items", status.Total)
+	w.Header().Set("Location", "/admin/warm/"+url.PathEscape(status.ID))
+	respondJSON(w, http.StatusAccepted, status)
+}
+
+// GetWarmStatus handles GET /admin/warm/{id}, reporting progress and failures.
+func (h *AdminHandler) GetWarmStatus(w http.ResponseWriter, r *http.Request) {
+	status, ok := h.warmer.Get(mux.Vars(r)["id"])
+	if !ok {
+		respondError(w, http.StatusNotFound, CodeNotFound, "Warm task not found")
+		return
+	}
+	respondJSON(w, http.StatusOK, status)
+}
+
+type PurgeResponse struct {
+	Purged int `json:"purged"`
+}
+
+// PurgeImage handles DELETE /admin/cache/{id}, removing every cached variant of one image.
+func (h *AdminHandler) PurgeImage(w http.ResponseWriter, r *http.Request) {
+	imageID := mux.Vars(r)["id"]
+	purged, err := h.service.PurgeImage(r.Context(), imageID)
+	h.respondPurge(w, "imageID", imageID, purged, err)
+}
+
+// PurgePrefix handles DELETE /admin/cache?prefix=..., removing every cached
+// variant of all images whose ID starts with prefix.
+func (h *AdminHandler) PurgePrefix(w http.ResponseWriter, r *http.Request) {
+	prefix := r.URL.Query().Get("prefix")
+	if prefix == "" {
+		// An empty prefix would purge everything; that should never happen by accident.
+		respondError(w, http.StatusBadRequest, CodeInvalidInput, "Missing prefix parameter")
+		return
+	}
+	purged, err := h.service.PurgePrefix(r.Context(), prefix)
+	h.respondPurge(w, "prefix", prefix, purged, err)
+}
+
+func (h *AdminHandler) respondPurge(w http.ResponseWriter, field, value string, purged int, err error) {
+	if err != nil {
+		h.logger.Errorw("Failed to purge cache", field, value, "purged", purged, "error", err)
+		status, code, _ := mapError(err)
+		respondError(w, status, code, "Failed to purge cache")
+		return
+	}
+	h.logger.Infow("Purged cache", field, value, "purged", purged)
+	respondJSON(w, http.StatusOK, PurgeResponse{Purged: purged})
+}
@@ -0,0 +1,249 @@
+package handler
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+	"watermark/internal/service"
+	"watermark/pkg/logger"
+)
+
+// maxBatchSpecBytes bounds the size of a POST /batch body.
+const maxBatchSpecBytes = 1 << 20
+
+// BatchLimits bounds the work a single batch request may cause.
+type BatchLimits struct {
+	MaxItems    int
+	MaxBytes    int64 // Total size of rendered images in one archive
+	Concurrency int
+	// EntryTimeout is how long each archive entry may take to render and
+	// write. The server's write deadline is pushed back by this much before
+	// every entry, so it bounds a stalled client rather than the whole batch.
+	EntryTimeout time.Duration
+}
+
+type BatchHandler struct {
+	service *service.ImageService
+	limits  BatchLimits
+	logger  *logger.Logger
+}
+
+func NewBatchHandler(service *service.ImageService, limits BatchLimits, logger *logger.Logger) *BatchHandler {
+	if limits.Concurrency < 1 {
+		limits.Concurrency = 1
+	}
+	return &BatchHandler{
+		service: service,
+		limits:  limits,
+		logger:  logger,
+	}
+}
+
+type BatchItem struct {
+	ImageID string `json:"image_id"`
+	service.RenderParams
+}
+
+// BatchRequest is the body of POST /batch. Items use the defaults for
+// anything they don't set.
+type BatchRequest struct {
+	Defaults service.RenderParams `json:"defaults"`
+	Items    []BatchItem          `json:"items"`
+}
+
+type batchResult struct {
+	name string
+	ext  string
+	data []byte
+	err  error
+}
+
+// CreateBatch handles POST /batch. It renders every item with bounded
+// parallelism and streams the results back as a ZIP archive. Failed items
+// appear in the archive as errors/<name>.json instead of aborting the batch.
+// Once the archive reaches MaxBytes, nothing more is rendered and the
+// remaining items are reported as too large.
+func (h *BatchHandler) CreateBatch(w http.ResponseWriter, r *http.Request) {
+	var batch BatchRequest
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBatchSpecBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&batch); err != nil {
+		respondInvalidInput(w, "Invalid batch request: ", err)
+		return
+	}
+	if len(batch.Items) == 0 {
+		respondError(w, http.StatusBadRequest, CodeInvalidInput, "Batch has no items")
+		return
+	}
+	if len(batch.Items) > h.limits.MaxItems {
+		respondError(w, http.StatusRequestEntityTooLarge, CodeTooLarge,
+			fmt.Sprintf("Batch has %d items, the limit is %d", len(batch.Items), h.limits.MaxItems))
+		return
+	}
+
+	reqs := make([]service.ProcessRequest, len(batch.Items))
+	for i, item := range batch.Items {
+		req, err := item.WithDefaults(batch.Defaults).Request(item.ImageID, h.service.UsesMetadata())
+		if err == nil {
+			req.Locale, err = h.service.Locale(req.Locale, "")
+		}
+		if err != nil {
+			respondError(w, http.StatusBadRequest, CodeInvalidInput, fmt.Sprintf("Item %d: %s", i, err))
+			return
+		}
+		reqs[i] = req
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="batch.zip"`)
+	w.WriteHeader(http.StatusOK)
+
+	ctx, cancel := context.WithCancelCause(r.Context())
+	defer cancel(nil)
+
+	results := make(chan batchResult, h.limits.Concurrency)
+	go h.render(ctx, reqs, results)
+
+	rc := http.NewResponseController(w)
+	h.extendWriteDeadline(rc)
+
+	zw := zip.NewWriter(w)
+	var total int64
+	var failed int
+	var limitErr error
+	for res := range results {
+		h.extendWriteDeadline(rc)
+		if limitErr == nil && res.err == nil && total+int64(len(res.data)) > h.limits.MaxBytes {
+			limitErr = fmt.Errorf("%w: batch byte limit of %d reached", service.ErrTooLarge, h.limits.MaxBytes)
+			// Nothing rendered from here on can go in the archive.
+			cancel(limitErr)
+		}
+		if limitErr != nil && (res.err == nil || errors.Is(res.err, context.Canceled)) {
+			res.err = limitErr
+		}
+
+		var err error
+		if res.err != nil {
+			failed++
+			err = writeBatchError(zw, res)
+		} else {
+			total += int64(len(res.data))
+			err = writeBatchEntry(zw, res.name+res.ext, res.data)
+		}
+		if err != nil {
+			// The client is gone; stop rendering but keep draining so workers can exit.
+			h.logger.Warnw("Failed to write batch archive", "error", err)
+			cancel(err)
+		}
+	}
+
+	h.extendWriteDeadline(rc)
+	if err := zw.Close(); err != nil {
+		h.logger.Warnw("Failed to finish batch archive", "error", err)
+		return
+	}
+	h.logger.Infow("Batch completed", "items", len(reqs), "failed", failed, "bytes", total)
+}
+
+// extendWriteDeadline gives the response another EntryTimeout to be written.
+func (h *BatchHandler) extendWriteDeadline(rc *http.ResponseController) {
+	if h.limits.EntryTimeout <= 0 {
+		return
+	}
+	err := rc.SetWriteDeadline(time.Now().Add(h.limits.EntryTimeout))
+	if err != nil && !errors.Is(err, http.ErrNotSupported) {
+		h.logger.Warnw("Failed to extend batch write deadline", "error", err)
+	}
+}
+
+// render processes reqs on a bounded number of goroutines and closes results
+// when done. Items not started by the time ctx is canceled fail with its cause.
+func (h *BatchHandler) render(ctx context.Context, reqs []service.ProcessRequest, results chan<- batchResult) {
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, h.limits.Concurrency)
+	for i, req := range reqs {
+		res := batchResult{name: batchEntryName(i, req.ImageID)}
+		// Checked first because select picks at random when a slot is free too.
+		if res.err = context.Cause(ctx); res.err == nil {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				res.err = context.Cause(ctx)
+			}
+		}
+		if res.err != nil {
+			results <- res
+			continue
+		}
+
+		wg.Add(1)
+		go func(req service.ProcessRequest, res batchResult) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			result, err := h.service.ProcessImage(ctx, req)
+			if err != nil {
+				res.err = err
+			} else {
+				res.data = result.Data
+				res.ext = extension(result.ContentType)
+			}
+			results <- res
+		}(req, res)
+	}
+	wg.Wait()
+	close(results)
+}
+
+// batchEntryName makes a flat, unique archive name for an image ID.
+func batchEntryName(index int, imageID string) string {
+	name := path.Base("/" + strings.ReplaceAll(imageID, "\\", "/"))
+	name = strings.TrimSuffix(name, path.Ext(name))
+	return fmt.Sprintf("%03d-%s", index+1, name)
+}
+
+// extension returns the file extension for a rendered image's content type.
+func extension(contentType string) string {
+	if contentType == "image/png" {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+func writeBatchEntry(zw *zip.Writer, name string, data []byte) error {
+	// JPEGs and PNGs are already compressed, so store them as-is.
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     name,
+		Method:   zip.Store,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	return err
+}
+
+func writeBatchError(zw *zip.Writer, res batchResult) error {
+	status, code, message := mapError(res.err)
+	f, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     "errors/" + res.name + ".json",
+		Method:   zip.Deflate,
+		Modified: time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	return json.NewEncoder(f).Encode(ErrorResponse{
+		Error:   statusText(status),
+		Code:    code,
+		Message: message,
+	})
+}
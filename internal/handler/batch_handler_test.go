@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.uber.org/zap"
+
+	"watermark/internal/storage"
+	"watermark/pkg/logger"
+)
+
+// countingStorage counts the originals fetched from it.
+type countingStorage struct {
+	versionedStorage
+	gets atomic.Int32
+}
+
+func (s *countingStorage) Get(ctx context.Context, key string) ([]byte, *storage.ObjectInfo, error) {
+	s.gets.Add(1)
+	return s.versionedStorage.Get(ctx, key)
+}
+
+func TestCreateBatchStopsAtByteLimit(t *testing.T) {
+	origin := &countingStorage{versionedStorage: versionedStorage{data: testJPEG(t), info: storage.ObjectInfo{ETag: `"origin-v1"`}}}
+	svc, _ := newTestImageService(t, origin)
+	// Every render is larger than the limit, so the first one trips it.
+	h := NewBatchHandler(svc, BatchLimits{MaxItems: 50, MaxBytes: 1, Concurrency: 1}, &logger.Logger{SugaredLogger: zap.NewNop().Sugar()})
+
+	const items = 20
+	ids := make([]string, items)
+	for i := range ids {
+		ids[i] = fmt.Sprintf(`{"image_id": "%d.jpg"}`, i)
+	}
+	body := `{"defaults": {"weight": "1", "dimensions": "1x1x1"}, "items": [` + strings.Join(ids, ",") + `]}`
+	w := httptest.NewRecorder()
+	h.CreateBatch(w, httptest.NewRequest(http.MethodPost, "/batch", strings.NewReader(body)))
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /batch = %d, want 200", w.Code)
+	}
+	if gets := origin.gets.Load(); gets >= items {
+		t.Errorf("fetched %d originals, want rendering to stop at the byte limit", gets)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(zr.File) != items {
+		t.Fatalf("archive has %d entries, want %d", len(zr.File), items)
+	}
+	for _, f := range zr.File {
+		if !strings.HasPrefix(f.Name, "errors/") {
+			t.Errorf("archive entry %s, want only errors", f.Name)
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var resp ErrorResponse
+		err = json.NewDecoder(rc).Decode(&resp)
+		rc.Close()
+		if err != nil || resp.Code != CodeTooLarge {
+			t.Errorf("%s = %+v (%v), want code %s", f.Name, resp, err, CodeTooLarge)
+		}
+	}
+}
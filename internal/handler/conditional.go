@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"time"
+	"watermark/internal/service"
+)
+
+// isConditional reports whether r carries validators that could produce a 304.
+func isConditional(r *http.Request) bool {
+	return r.Header.Get("If-None-Match") != "" || r.Header.Get("If-Modified-Since") != ""
+}
+
+// setValidators advertises the render's ETag and Last-Modified.
+func setValidators(w http.ResponseWriter, version *service.ImageVersion) {
+	w.Header().Set("ETag", version.ETag)
+	if !version.LastModified.IsZero() {
+		w.Header().Set("Last-Modified", version.LastModified.UTC().Format(http.TimeFormat))
+	}
+}
+
+// notModified evaluates If-None-Match and If-Modified-Since against version as
+// described in RFC 9110 section 13.2.2. If-Modified-Since is ignored when
+// If-None-Match is present.
+func notModified(r *http.Request, version *service.ImageVersion) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etagMatches(inm, version.ETag)
+	}
+
+	ims := r.Header.Get("If-Modified-Since")
+	if ims == "" || version.LastModified.IsZero() {
+		return false
+	}
+	t, err := http.ParseTime(ims)
+	if err != nil {
+		return false
+	}
+	// HTTP dates have second precision.
+	return !version.LastModified.Truncate(time.Second).After(t)
+}
+
+// etagMatches reports whether any entity tag in the If-None-Match list matches
+// etag, using the weak comparison the header calls for.
+func etagMatches(header, etag string) bool {
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,269 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+	"golang.org/x/image/font/gofont/goregular"
+
+	"watermark/internal/locale"
+	"watermark/internal/measure"
+	"watermark/internal/presets"
+	"watermark/internal/processor"
+	"watermark/internal/service"
+	"watermark/internal/storage"
+	"watermark/pkg/logger"
+)
+
+func TestEtagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		want   bool
+	}{
+		{header: `"abc"`, want: true},
+		{header: `W/"abc"`, want: true},
+		{header: `"xyz", "abc"`, want: true},
+		{header: `*`, want: true},
+		{header: `"xyz"`, want: false},
+		{header: `abc`, want: false},
+	}
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, `"abc"`); got != tt.want {
+			t.Errorf("etagMatches(%s) = %v, want %v", tt.header, got, tt.want)
+		}
+	}
+}
+
+func TestNotModified(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 500, time.UTC)
+	version := &service.ImageVersion{ETag: `"abc"`, LastModified: modified}
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   bool
+	}{
+		{name: "no validators", header: http.Header{}, want: false},
+		{name: "matching ETag", header: http.Header{"If-None-Match": {`"abc"`}}, want: true},
+		{name: "unmodified since", header: http.Header{"If-Modified-Since": {modified.Format(http.TimeFormat)}}, want: true},
+		{name: "modified since", header: http.Header{"If-Modified-Since": {modified.Add(-time.Hour).Format(http.TimeFormat)}}, want: false},
+		{name: "unparsable date", header: http.Header{"If-Modified-Since": {"yesterday"}}, want: false},
+		{
+			name: "If-None-Match wins over If-Modified-Since",
+			header: http.Header{
+				"If-None-Match":     {`"xyz"`},
+				"If-Modified-Since": {modified.Format(http.TimeFormat)},
+			},
+			want: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/image/a.jpg", nil)
+			r.Header = tt.header
+			if got := notModified(r, version); got != tt.want {
+				t.Errorf("notModified() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+// versionedStorage serves one original image with a fixed version.
+type versionedStorage struct {
+	data []byte
+	info storage.ObjectInfo
+}
+
+func (s *versionedStorage) Get(ctx context.Context, key string) ([]byte, *storage.ObjectInfo, error) {
+	info := s.info
+	return s.data, &info, nil
+}
+
+func (s *versionedStorage) Stat(ctx context.Context, key, ifNoneMatch string) (*storage.ObjectInfo, error) {
+	if ifNoneMatch == s.info.ETag {
+		return nil, storage.ErrNotModified
+	}
+	info := s.info
+	return &info, nil
+}
+
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 48)), nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// newTestImageService renders the default preset from origin into an
+// in-memory cache. The returned function waits for pending cache writes.
+func newTestImageService(t *testing.T, origin storage.ImageStorage) (*service.ImageService, func()) {
+	t.Helper()
+	logrusLogger := logrus.New()
+	logrusLogger.SetOutput(io.Discard)
+
+	proc, err := processor.NewWatermarkProcessor(goregular.TTF, 12, color.White, 80, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cache := storage.NewMemoryCache(1<<20, time.Hour, time.Hour, logrusLogger)
+	writer := service.NewCacheWriter(cache, service.CacheWriterConfig{QueueSize: 8, Workers: 1}, logrusLogger)
+	svc := service.NewImageService(origin, cache, nil, writer, proc, testPresets(t), testLocales(t), nil, false, nil, service.CachePolicy{}, service.Timeouts{}, logrusLogger)
+	return svc, func() { writer.Close(context.Background()) }
+}
+
+// newTestImageRouter serves GetImage from newTestImageService.
+func newTestImageRouter(t *testing.T, origin storage.ImageStorage) (http.Handler, func()) {
+	t.Helper()
+	svc, flush := newTestImageService(t, origin)
+	h := NewImageHandler(svc, CacheControl{MaxAge: time.Hour}, &logger.Logger{SugaredLogger: zap.NewNop().Sugar()})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/image/{id}", h.GetImage).Methods(http.MethodGet, http.MethodHead)
+	return r, flush
+}
+
+func TestGetImageConditional(t *testing.T) {
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	origin := &versionedStorage{data: testJPEG(t), info: storage.ObjectInfo{ETag: `"origin-v1"`, LastModified: modified}}
+	router, flush := newTestImageRouter(t, origin)
+	const target = "/image/a.jpg?weight=1&dimensions=1x1x1"
+
+	serve := func(method string, header http.Header) *httptest.ResponseRecorder {
+		t.Helper()
+		r := httptest.NewRequest(method, target, nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		return w
+	}
+
+	first := serve(http.MethodGet, nil)
+	if first.Code != http.StatusOK || first.Body.Len() == 0 {
+		t.Fatalf("GET = %d with %d bytes, want 200 with the image", first.Code, first.Body.Len())
+	}
+	etag := first.Header().Get("ETag")
+	if etag == "" || etag == origin.info.ETag {
+		t.Fatalf("ETag = %q, want one derived from the render", etag)
+	}
+	lastModified := first.Header().Get("Last-Modified")
+	if rendered, err := http.ParseTime(lastModified); err != nil || time.Since(rendered) > time.Minute {
+		t.Errorf("Last-Modified = %q, want when the render was made", lastModified)
+	}
+	flush()
+
+	t.Run("If-None-Match", func(t *testing.T) {
+		w := serve(http.MethodGet, http.Header{"If-None-Match": {etag}})
+		if w.Code != http.StatusNotModified || w.Body.Len() != 0 {
+			t.Errorf("GET = %d with %d bytes, want an empty 304", w.Code, w.Body.Len())
+		}
+		if w.Header().Get("ETag") != etag {
+			t.Errorf("304 ETag = %q, want %q", w.Header().Get("ETag"), etag)
+		}
+	})
+
+	t.Run("stale If-None-Match", func(t *testing.T) {
+		w := serve(http.MethodGet, http.Header{"If-None-Match": {`"something-else"`}})
+		if w.Code != http.StatusOK || w.Body.Len() == 0 {
+			t.Errorf("GET = %d with %d bytes, want 200 with the image", w.Code, w.Body.Len())
+		}
+	})
+
+	t.Run("If-Modified-Since", func(t *testing.T) {
+		w := serve(http.MethodGet, http.Header{"If-Modified-Since": {lastModified}})
+		if w.Code != http.StatusNotModified {
+			t.Errorf("GET = %d, want 304", w.Code)
+		}
+	})
+
+	t.Run("If-Modified-Since before the render", func(t *testing.T) {
+		// The origin object is older than the render: a copy from before a
+		// preset, locale or text change must not be confirmed.
+		w := serve(http.MethodGet, http.Header{"If-Modified-Since": {modified.Format(http.TimeFormat)}})
+		if w.Code != http.StatusOK || w.Body.Len() == 0 {
+			t.Errorf("GET = %d with %d bytes, want 200 with the image", w.Code, w.Body.Len())
+		}
+	})
+
+	t.Run("HEAD", func(t *testing.T) {
+		w := serve(http.MethodHead, nil)
+		if w.Code != http.StatusOK || w.Body.Len() != 0 {
+			t.Errorf("HEAD = %d with %d bytes, want an empty 200", w.Code, w.Body.Len())
+		}
+		if w.Header().Get("ETag") != etag || w.Header().Get("Content-Type") != "image/jpeg" {
+			t.Errorf("HEAD headers = %v", w.Header())
+		}
+	})
+}
+
+func TestHeadImageBeforeCached(t *testing.T) {
+	origin := &versionedStorage{data: testJPEG(t), info: storage.ObjectInfo{ETag: `"origin-v1"`}}
+	router, _ := newTestImageRouter(t, origin)
+
+	r := httptest.NewRequest(http.MethodHead, "/image/a.jpg?weight=1&dimensions=1x1x1", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.Len() != 0 {
+		t.Errorf("HEAD = %d with %d bytes, want an empty 200", w.Code, w.Body.Len())
+	}
+	if w.Header().Get("Content-Length") == "" || w.Header().Get("ETag") == "" {
+		t.Errorf("HEAD headers = %v, want Content-Length and ETag", w.Header())
+	}
+}
+
+// testPresets returns a registry holding only the default preset.
+func testPresets(t *testing.T) *presets.Registry {
+	t.Helper()
+	fontPath := filepath.Join(t.TempDir(), "font.ttf")
+	if err := os.WriteFile(fontPath, goregular.TTF, 0600); err != nil {
+		t.Fatal(err)
+	}
+	margin, opacity := 10, 1.0
+	registry, err := presets.Load("", presets.Definition{
+		Font:      fontPath,
+		FontSize:  12,
+		Color:     "#FFFFFF",
+		Placement: "bottom",
+		Margin:    &margin,
+		Opacity:   &opacity,
+		Format:    processor.FormatJPEG,
+		Quality:   80,
+
+		WeightUnit:    string(measure.Kilogram),
+		DimensionUnit: string(measure.Centimetre),
+		Locale:        "en",
+
+		Text:              presets.DefaultText,
+		VolumetricDivisor: "5000",
+	}, testLocales(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+// testLocales returns the built-in locales.
+func testLocales(t *testing.T) *locale.Catalog {
+	t.Helper()
+	locales, err := locale.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return locales
+}
@@ -0,0 +1,68 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"watermark/internal/service"
+)
+
+// StatusClientClosedRequest is the de facto status for requests abandoned by the client.
+const StatusClientClosedRequest = 499
+
+// Machine-readable error codes returned in ErrorResponse.Code.
+const (
+	CodeNotFound          = "not_found"
+	CodeInvalidInput      = "invalid_input"
+	CodeInvalidSpec       = "invalid_spec"
+	CodeInvalidMetadata   = "invalid_metadata"
+	CodeUnsupportedFormat = "unsupported_format"
+	CodeTooLarge          = "too_large"
+	CodeUpstream          = "upstream_error"
+	CodeTimeout           = "timeout"
+	CodeCanceled          = "canceled"
+	CodeRateLimited       = "rate_limited"
+	CodeInternal          = "internal_error"
+	CodeQueueFull         = "queue_full"
+	CodeJobNotReady       = "job_not_ready"
+	CodeJobFailed         = "job_failed"
+)
+
+type errorMapping struct {
+	kind    error
+	status  int
+	code    string
+	message string
+}
+
+var errorMappings = []errorMapping{
+	{service.ErrNotFound, http.StatusNotFound, CodeNotFound, "Image not found"},
+	{service.ErrInvalidInput, http.StatusBadRequest, CodeInvalidInput, "Invalid image data"},
+	{service.ErrInvalidSpec, http.StatusBadRequest, CodeInvalidSpec, "Invalid render spec"},
+	{service.ErrIncomplete, http.StatusBadRequest, CodeInvalidInput, "Missing weight or dimensions"},
+	{service.ErrInvalidMetadata, http.StatusUnprocessableEntity, CodeInvalidMetadata, "Invalid image metadata"},
+	{service.ErrUnknownPreset, http.StatusBadRequest, CodeInvalidInput, "Unknown preset"},
+	{service.ErrUnknownLocale, http.StatusBadRequest, CodeInvalidInput, "Unsupported language"},
+	{service.ErrUnsupportedFormat, http.StatusUnsupportedMediaType, CodeUnsupportedFormat, "Unsupported image format"},
+	{service.ErrTooLarge, http.StatusRequestEntityTooLarge, CodeTooLarge, "Image too large"},
+	{service.ErrUpstream, http.StatusBadGateway, CodeUpstream, "Failed to fetch image from storage"},
+	{service.ErrTimeout, http.StatusGatewayTimeout, CodeTimeout, "Timed out processing image"},
+	{service.ErrCanceled, StatusClientClosedRequest, CodeCanceled, "Request canceled"},
+	{service.ErrRateLimited, http.StatusTooManyRequests, CodeRateLimited, "Render rate limit exceeded"},
+}
+
+// mapError translates a service error into an HTTP status, error code and client-facing message.
+func mapError(err error) (int, string, string) {
+	for _, m := range errorMappings {
+		if errors.Is(err, m.kind) {
+			return m.status, m.code, m.message
+		}
+	}
+	return http.StatusInternalServerError, CodeInternal, "Failed to process image"
+}
+
+func statusText(code int) string {
+	if code == StatusClientClosedRequest {
+		return "Client Closed Request"
+	}
+	return http.StatusText(code)
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"net/http"
+	"sync/atomic"
+	"watermark/internal/health"
+	"watermark/pkg/logger"
+)
+
+// HealthHandler serves the liveness and readiness endpoints.
+type HealthHandler struct {
+	checker  *health.Checker
+	draining atomic.Bool
+	logger   *logger.Logger
+}
+
+func NewHealthHandler(checker *health.Checker, logger *logger.Logger) *HealthHandler {
+	return &HealthHandler{
+		checker: checker,
+		logger:  logger,
+	}
+}
+
+// SetDraining makes readiness fail, so load balancers stop sending traffic during shutdown.
+func (h *HealthHandler) SetDraining() {
+	h.draining.Store(true)
+}
+
+// Healthz reports that the process is up. It checks no dependencies.
+func (h *HealthHandler) Healthz(w http.ResponseWriter, r *http.Request) {
+	respondJSON(w, http.StatusOK, map[string]string{"status": health.StatusOK})
+}
+
+// Readyz runs the dependency checks and reports each one's status.
+func (h *HealthHandler) Readyz(w http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		respondJSON(w, http.StatusServiceUnavailable, map[string]string{"status": "draining"})
+		return
+	}
+
+	report := h.checker.Run(r.Context())
+	if report.Status != health.StatusOK {
+		for name, result := range report.Checks {
+			if result.Status != health.StatusOK {
+				h.logger.Warnw("Readiness check failed", "check", name, "error", result.Error)
+			}
+		}
+		respondJSON(w, http.StatusServiceUnavailable, report)
+		return
+	}
+	respondJSON(w, http.StatusOK, report)
+}
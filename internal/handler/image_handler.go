@@ -2,73 +2,266 @@ package handler
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"net/url"
 	"strconv"
+	"time"
+	"watermark/internal/measure"
 	"watermark/internal/service"
 	"watermark/pkg/logger"
+	"watermark/pkg/ratelimit"
 
 	"github.com/gorilla/mux"
 )
 
 type ImageHandler struct {
-	service *service.ImageService
-	logger  *logger.Logger
+	service      *service.ImageService
+	cacheControl CacheControl
+	logger       *logger.Logger
 }
 
-func NewImageHandler(service *service.ImageService, logger *logger.Logger) *ImageHandler {
+func NewImageHandler(service *service.ImageService, cacheControl CacheControl, logger *logger.Logger) *ImageHandler {
 	return &ImageHandler{
-		service: service,
-		logger:  logger,
+		service:      service,
+		cacheControl: cacheControl,
+		logger:       logger,
 	}
 }
 
+// CacheControl describes the caching directives advertised to clients and CDNs.
+type CacheControl struct {
+	MaxAge               time.Duration
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+}
+
+// header builds the Cache-Control value. Stale responses get max-age=0 so
+// downstream caches come back for the refreshed render.
+func (c CacheControl) header(stale bool) string {
+	maxAge := c.MaxAge
+	if stale {
+		maxAge = 0
+	}
+	value := fmt.Sprintf("public, max-age=%d", int(maxAge.Seconds()))
+	if c.StaleWhileRevalidate > 0 {
+		value += fmt.Sprintf(", stale-while-revalidate=%d", int(c.StaleWhileRevalidate.Seconds()))
+	}
+	if c.StaleIfError > 0 {
+		value += fmt.Sprintf(", stale-if-error=%d", int(c.StaleIfError.Seconds()))
+	}
+	return value
+}
+
 type ErrorResponse struct {
 	Error   string `json:"error"`
+	Code    string `json:"code"`
 	Message string `json:"message"`
+	// Field names the rejected request field, when known.
+	Field string `json:"field,omitempty"`
 }
 
 func (h *ImageHandler) GetImage(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	imageID := vars["id"]
 
-	weight, err := strconv.ParseFloat(r.URL.Query().Get("weight"), 64)
+	req, err := parseRenderParams(imageID, r.URL.Query(), h.service.UsesMetadata())
 	if err != nil {
-		h.respondError(w, http.StatusBadRequest, "Invalid weight parameter")
+		respondInvalidInput(w, "", err)
+		return
+	}
+	if req.Locale, err = requestLocale(w, r, h.service, r.URL.Query().Get("lang")); err != nil {
+		respondServiceError(w, h.logger, imageID, err)
 		return
 	}
 
-	dimensions := r.URL.Query().Get("dimensions")
-	if dimensions == "" {
-		h.respondError(w, http.StatusBadRequest, "Missing dimensions parameter")
+	if metadata, _ := strconv.ParseBool(r.URL.Query().Get("metadata")); metadata {
+		h.respondMetadata(w, r, req)
 		return
 	}
 
-	imageData, err := h.service.ProcessImage(r.Context(), service.ProcessRequest{
-		ImageID:    imageID,
-		Weight:     weight,
-		Dimensions: dimensions,
-	})
+	if h.service.Publishing() {
+		url, err := h.service.PublishImage(r.Context(), req)
+		if err != nil {
+			respondServiceError(w, h.logger, imageID, err)
+			return
+		}
+		// The redirect is cheap to produce, so don't let caches pin a presigned URL past its expiry.
+		w.Header().Set("Cache-Control", "no-cache")
+		http.Redirect(w, r, url, http.StatusFound)
+		return
+	}
+
+	// Validators and HEAD can often be answered from the render's version alone,
+	// without loading the image from the cache.
+	if r.Method == http.MethodHead || isConditional(r) {
+		if version := h.service.Version(r.Context(), req); version != nil {
+			setValidators(w, version)
+			w.Header().Set("Cache-Control", h.cacheControl.header(false))
+			if notModified(r, version) {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			if r.Method == http.MethodHead && version.ContentLength > 0 {
+				w.Header().Set("Content-Type", version.ContentType)
+				w.Header().Set("Content-Length", strconv.FormatInt(version.ContentLength, 10))
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+	}
+
+	result, err := h.service.ProcessImage(r.Context(), req)
 	if err != nil {
-		h.logger.Error("Failed to process image",
-			"imageID", imageID,
-			"error", err,
-		)
-		h.respondError(w, http.StatusInternalServerError, "Failed to process image")
+		respondServiceError(w, h.logger, imageID, err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "image/jpeg")
-	w.Header().Set("Content-Length", strconv.Itoa(len(imageData)))
-	w.Header().Set("Cache-Control", "public, max-age=604800")
+	w.Header().Set("Cache-Control", h.cacheControl.header(result.CacheStatus == service.CacheStale))
+	w.Header().Set("X-Cache", result.CacheStatus)
+	if result.Version != nil {
+		setValidators(w, result.Version)
+		if notModified(r, result.Version) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", result.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(result.Data)))
 	w.WriteHeader(http.StatusOK)
-	w.Write(imageData)
+	if r.Method != http.MethodHead {
+		w.Write(result.Data)
+	}
 }
 
-func (h *ImageHandler) respondError(w http.ResponseWriter, code int, message string) {
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(ErrorResponse{
-		Error:   http.StatusText(code),
+// MetadataResponse describes the values behind an image's overlay. Weights
+// are in kilograms and dimensions in centimetres; Vars holds the same values
+// formatted as they appear in the overlay.
+type MetadataResponse struct {
+	ImageID           string              `json:"image_id"`
+	Preset            string              `json:"preset"`
+	Locale            string              `json:"locale"`
+	Weight            *measure.Weight     `json:"weight,omitempty"`
+	Dimensions        *measure.Dimensions `json:"dimensions,omitempty"`
+	VolumetricWeight  *measure.Weight     `json:"volumetric_weight,omitempty"`
+	ChargeableWeight  *measure.Weight     `json:"chargeable_weight,omitempty"`
+	VolumetricDivisor string              `json:"volumetric_divisor"`
+	Vars              map[string]string   `json:"vars"`
+}
+
+// respondMetadata answers ?metadata=true with the overlay's values instead of
+// the image. Nothing is fetched or rendered.
+func (h *ImageHandler) respondMetadata(w http.ResponseWriter, r *http.Request, req service.ProcessRequest) {
+	m, err := h.service.Measure(r.Context(), req)
+	if err != nil {
+		respondServiceError(w, h.logger, req.ImageID, err)
+		return
+	}
+	resp := MetadataResponse{
+		ImageID:           req.ImageID,
+		Preset:            m.Preset,
+		Locale:            m.Locale,
+		VolumetricDivisor: m.Divisor.String(),
+		Vars:              m.Vars,
+	}
+	if m.Weight > 0 {
+		resp.Weight = &m.Weight
+	}
+	if !m.Dimensions.IsZero() {
+		resp.Dimensions = &m.Dimensions
+	}
+	if m.ChargeableWeight > 0 {
+		resp.VolumetricWeight, resp.ChargeableWeight = &m.VolumetricWeight, &m.ChargeableWeight
+	}
+	w.Header().Set("Cache-Control", h.cacheControl.header(false))
+	respondJSON(w, http.StatusOK, resp)
+}
+
+// parseRenderParams reads the render parameters shared by the image endpoints
+// for imageID: those of service.ParseRenderParams, plus display units and a
+// date. With stored, weight and dimensions may be left out for the image's
+// stored metadata to provide. The locale is chosen separately, see requestLocale.
+func parseRenderParams(imageID string, values url.Values, stored bool) (service.ProcessRequest, error) {
+	params, err := service.ParseRenderParams(values)
+	if err != nil {
+		return service.ProcessRequest{}, err
+	}
+	req, err := params.Request(imageID, stored)
+	if err != nil {
+		return service.ProcessRequest{}, err
+	}
+	if v := values.Get("weight_unit"); v != "" {
+		if req.WeightUnit, err = measure.ParseWeightUnit(v); err != nil {
+			return service.ProcessRequest{}, &measure.ParseError{Field: "weight_unit", Input: v, Reason: "want kg, g, lb or oz"}
+		}
+	}
+	if v := values.Get("dimension_unit"); v != "" {
+		if req.LengthUnit, err = measure.ParseLengthUnit(v); err != nil {
+			return service.ProcessRequest{}, &measure.ParseError{Field: "dimension_unit", Input: v, Reason: "want mm, cm, m or in"}
+		}
+	}
+	if v := values.Get("date"); v != "" {
+		if req.Date, err = time.Parse(time.DateOnly, v); err != nil {
+			return service.ProcessRequest{}, &measure.ParseError{Field: "date", Input: v, Reason: "want YYYY-MM-DD"}
+		}
+	}
+	return req, nil
+}
+
+// requestLocale picks a request's locale from lang, or else from its
+// Accept-Language header, in which case the response varies on the header.
+func requestLocale(w http.ResponseWriter, r *http.Request, svc *service.ImageService, lang string) (string, error) {
+	if lang == "" {
+		w.Header().Add("Vary", "Accept-Language")
+	}
+	return svc.Locale(lang, r.Header.Get("Accept-Language"))
+}
+
+// respondServiceError logs a failed service call and writes the mapped error response.
+func respondServiceError(w http.ResponseWriter, log *logger.Logger, imageID string, err error) {
+	status, code, message := mapError(err)
+	var limitErr *ratelimit.LimitError
+	if errors.As(err, &limitErr) {
+		ratelimit.SetHeaders(w.Header(), limitErr.Result)
+	}
+	switch {
+	case errors.Is(err, service.ErrCanceled):
+		log.Infow("Client canceled request", "imageID", imageID, "error", err)
+	case status >= http.StatusInternalServerError:
+		log.Errorw("Failed to process image", "imageID", imageID, "code", code, "error", err)
+	default:
+		log.Warnw("Rejected image request", "imageID", imageID, "code", code, "error", err)
+	}
+	respondError(w, status, code, message)
+}
+
+// respondInvalidInput writes a 400 for a rejected request value. Errors from
+// parsing weights and dimensions also name the offending field.
+func respondInvalidInput(w http.ResponseWriter, prefix string, err error) {
+	resp := ErrorResponse{
+		Error:   statusText(http.StatusBadRequest),
+		Code:    CodeInvalidInput,
+		Message: prefix + err.Error(),
+	}
+	var parseErr *measure.ParseError
+	if errors.As(err, &parseErr) {
+		resp.Field = parseErr.Field
+	}
+	respondJSON(w, http.StatusBadRequest, resp)
+}
+
+func respondError(w http.ResponseWriter, status int, code, message string) {
+	respondJSON(w, status, ErrorResponse{
+		Error:   statusText(status),
+		Code:    code,
 		Message: message,
 	})
 }
+
+func respondJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,151 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"watermark/internal/jobs"
+	"watermark/internal/service"
+	"watermark/pkg/logger"
+
+	"github.com/gorilla/mux"
+)
+
+// maxJobSpecBytes bounds the size of a POST /jobs body.
+const maxJobSpecBytes = 64 << 10
+
+type JobHandler struct {
+	queue   jobs.Queue
+	service *service.ImageService
+	logger  *logger.Logger
+}
+
+func NewJobHandler(queue jobs.Queue, service *service.ImageService, logger *logger.Logger) *JobHandler {
+	return &JobHandler{
+		queue:   queue,
+		service: service,
+		logger:  logger,
+	}
+}
+
+type JobResponse struct {
+	*jobs.Job
+	StatusURL string `json:"status_url"`
+	ResultURL string `json:"result_url,omitempty"`
+}
+
+// CreateJob handles POST /jobs. It accepts a render spec and returns the queued job.
+func (h *JobHandler) CreateJob(w http.ResponseWriter, r *http.Request) {
+	var spec jobs.Spec
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJobSpecBytes))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		respondInvalidInput(w, "Invalid job spec: ", err)
+		return
+	}
+	if _, err := spec.ProcessRequest(h.service.UsesMetadata()); err != nil {
+		respondInvalidInput(w, "Invalid job spec: ", err)
+		return
+	}
+	lang, err := h.service.Locale(spec.Lang, "")
+	if err != nil {
+		respondError(w, http.StatusBadRequest, CodeInvalidInput, err.Error())
+		return
+	}
+	spec.Lang = lang
+	// Workers render outside the request, so the client is charged now.
+	if err := h.service.ChargeRender(r.Context()); err != nil {
+		respondServiceError(w, h.logger, spec.ImageID, err)
+		return
+	}
+
+	job, err := jobs.NewJob(spec)
+	if err != nil {
+		h.logger.Errorw("Failed to create job", "error", err)
+		respondError(w, http.StatusInternalServerError, CodeInternal, "Failed to create job")
+		return
+	}
+	if err := h.queue.Enqueue(r.Context(), job); err != nil {
+		if errors.Is(err, jobs.ErrQueueFull) {
+			respondError(w, http.StatusServiceUnavailable, CodeQueueFull, "Job queue is full, try again later")
+			return
+		}
+		h.logger.Errorw("Failed to enqueue job", "error", err)
+		respondError(w, http.StatusBadGateway, CodeUpstream, "Failed to enqueue job")
+		return
+	}
+
+	resp := h.jobResponse(job)
+	w.Header().Set("Location", resp.StatusURL)
+	respondJSON(w, http.StatusAccepted, resp)
+}
+
+// GetJob handles GET /jobs/{id}, reporting status and progress.
+func (h *JobHandler) GetJob(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	respondJSON(w, http.StatusOK, h.jobResponse(job))
+}
+
+// GetJobResult handles GET /jobs/{id}/result, serving the output kept with the job.
+func (h *JobHandler) GetJobResult(w http.ResponseWriter, r *http.Request) {
+	job, ok := h.lookup(w, r)
+	if !ok {
+		return
+	}
+	switch job.Status {
+	case jobs.StatusSucceeded:
+	case jobs.StatusFailed:
+		respondError(w, http.StatusConflict, CodeJobFailed, "Job failed: "+job.Error)
+		return
+	default:
+		respondError(w, http.StatusConflict, CodeJobNotReady, "Job is still "+job.Status)
+		return
+	}
+
+	result, err := h.queue.Result(r.Context(), job.ID)
+	if errors.Is(err, jobs.ErrNotFound) {
+		respondError(w, http.StatusNotFound, CodeNotFound, "Job result not found")
+		return
+	}
+	if err != nil {
+		h.logger.Errorw("Failed to load job result", "jobID", job.ID, "error", err)
+		respondError(w, http.StatusBadGateway, CodeUpstream, "Failed to load job result")
+		return
+	}
+
+	w.Header().Set("Content-Type", result.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(result.Data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(result.Data)
+}
+
+func (h *JobHandler) lookup(w http.ResponseWriter, r *http.Request) (*jobs.Job, bool) {
+	id := mux.Vars(r)["id"]
+	job, err := h.queue.Get(r.Context(), id)
+	if errors.Is(err, jobs.ErrNotFound) {
+		respondError(w, http.StatusNotFound, CodeNotFound, "Job not found")
+		return nil, false
+	}
+	if err != nil {
+		h.logger.Errorw("Failed to load job", "jobID", id, "error", err)
+		respondError(w, http.StatusBadGateway, CodeUpstream, "Failed to load job")
+		return nil, false
+	}
+	return job, true
+}
+
+func (h *JobHandler) jobResponse(job *jobs.Job) JobResponse {
+	resp := JobResponse{
+		Job:       job,
+		StatusURL: "/jobs/" + url.PathEscape(job.ID),
+	}
+	if job.Status == jobs.StatusSucceeded {
+		resp.ResultURL = resp.StatusURL + "/result"
+	}
+	return resp
+}
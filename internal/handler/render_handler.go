@@ -0,0 +1,223 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+	"watermark/internal/measure"
+	"watermark/internal/processor"
+	"watermark/internal/service"
+	"watermark/internal/specs"
+	"watermark/pkg/logger"
+
+	"github.com/gorilla/mux"
+)
+
+// maxRenderSpecBytes bounds the size of POST /render and preset bodies.
+const maxRenderSpecBytes = 256 << 10
+
+// RenderHandler serves POST /render, which draws a multi-layer render spec
+// over a stored image, and the named presets it can refer to.
+type RenderHandler struct {
+	service *service.ImageService
+	presets specs.Store
+	logger  *logger.Logger
+}
+
+func NewRenderHandler(service *service.ImageService, presets specs.Store, logger *logger.Logger) *RenderHandler {
+	return &RenderHandler{
+		service: service,
+		presets: presets,
+		logger:  logger,
+	}
+}
+
+// RenderBody is the body of POST /render. Exactly one of Spec and Preset is set.
+// Weight and Dimensions, when given, are available to text layers as
+// {{.weight}} and {{.dimensions}} alongside Vars, formatted with the default
+// watermark preset's units and locale. With both, {{.volumetric_weight}} and
+// {{.chargeable_weight}} are set too. Lang, or else the Accept-Language header,
+// chooses the locale of the {{.label_*}} variables and of Date, shown as {{.date}}.
+// The image's stored metadata, when enabled, fills in these values and Vars.
+type RenderBody struct {
+	ImageID       string                `json:"image_id"`
+	Spec          *processor.RenderSpec `json:"spec,omitempty"`
+	Preset        string                `json:"preset,omitempty"`
+	Weight        *measure.Weight       `json:"weight,omitempty"`
+	Dimensions    *measure.Dimensions   `json:"dimensions,omitempty"`
+	WeightUnit    string                `json:"weight_unit,omitempty"`
+	DimensionUnit string                `json:"dimension_unit,omitempty"`
+	Lang          string                `json:"lang,omitempty"`
+	Date          string                `json:"date,omitempty"` // YYYY-MM-DD
+	Vars          map[string]string     `json:"vars,omitempty"`
+}
+
+// measurements returns the body's weight and dimensions as a request for
+// service.ImageService.Measure.
+func (b RenderBody) measurements() (service.ProcessRequest, error) {
+	var req service.ProcessRequest
+	if b.Weight != nil {
+		req.Weight = *b.Weight
+	}
+	if b.Dimensions != nil {
+		req.Dimensions = *b.Dimensions
+	}
+	var err error
+	if b.WeightUnit != "" {
+		if req.WeightUnit, err = measure.ParseWeightUnit(b.WeightUnit); err != nil {
+			return req, &measure.ParseError{Field: "weight_unit", Input: b.WeightUnit, Reason: "want kg, g, lb or oz"}
+		}
+	}
+	if b.DimensionUnit != "" {
+		if req.LengthUnit, err = measure.ParseLengthUnit(b.DimensionUnit); err != nil {
+			return req, &measure.ParseError{Field: "dimension_unit", Input: b.DimensionUnit, Reason: "want mm, cm, m or in"}
+		}
+	}
+	if b.Date != "" {
+		if req.Date, err = time.Parse(time.DateOnly, b.Date); err != nil {
+			return req, &measure.ParseError{Field: "date", Input: b.Date, Reason: "want YYYY-MM-DD"}
+		}
+	}
+	return req, nil
+}
+
+// Render handles POST /render.
+func (h *RenderHandler) Render(w http.ResponseWriter, r *http.Request) {
+	var body RenderBody
+	if err := decodeJSON(w, r, &body); err != nil {
+		respondInvalidInput(w, "Invalid render request: ", err)
+		return
+	}
+	measureReq, err := body.measurements()
+	if err != nil {
+		respondInvalidInput(w, "", err)
+		return
+	}
+	if body.ImageID == "" {
+		respondError(w, http.StatusBadRequest, CodeInvalidInput, "Missing image_id")
+		return
+	}
+
+	var spec processor.RenderSpec
+	switch {
+	case body.Spec != nil && body.Preset != "":
+		respondError(w, http.StatusBadRequest, CodeInvalidInput, "Set either spec or preset, not both")
+		return
+	case body.Spec != nil:
+		spec = *body.Spec
+	case body.Preset != "":
+		preset, err := h.presets.Get(r.Context(), body.Preset)
+		if err != nil {
+			h.respondPresetError(w, body.Preset, err)
+			return
+		}
+		spec = *preset
+	default:
+		respondError(w, http.StatusBadRequest, CodeInvalidInput, "Missing spec or preset")
+		return
+	}
+	if err := spec.Validate(); err != nil {
+		respondError(w, http.StatusBadRequest, CodeInvalidSpec, err.Error())
+		return
+	}
+
+	measureReq.ImageID, measureReq.Fields = body.ImageID, body.Vars
+	if measureReq.Locale, err = requestLocale(w, r, h.service, body.Lang); err != nil {
+		respondServiceError(w, h.logger, body.ImageID, err)
+		return
+	}
+	m, err := h.service.Measure(r.Context(), measureReq)
+	if err != nil {
+		respondServiceError(w, h.logger, body.ImageID, err)
+		return
+	}
+
+	result, err := h.service.ProcessSpec(r.Context(), service.RenderRequest{
+		ImageID: body.ImageID,
+		Spec:    spec,
+		Vars:    m.Vars,
+		Locale:  m.Locale,
+	})
+	if err != nil {
+		respondServiceError(w, h.logger, body.ImageID, err)
+		return
+	}
+
+	w.Header().Set("X-Cache", result.CacheStatus)
+	if result.Version != nil {
+		setValidators(w, result.Version)
+	}
+	w.Header().Set("Content-Type", result.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(result.Data)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(result.Data)
+}
+
+// GetPreset handles GET /render/presets/{name}.
+func (h *RenderHandler) GetPreset(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	spec, err := h.presets.Get(r.Context(), name)
+	if err != nil {
+		h.respondPresetError(w, name, err)
+		return
+	}
+	respondJSON(w, http.StatusOK, spec)
+}
+
+// PutPreset handles PUT /admin/presets/{name}, creating or replacing a preset.
+func (h *RenderHandler) PutPreset(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if err := specs.CheckName(name); err != nil {
+		respondError(w, http.StatusBadRequest, CodeInvalidInput, err.Error())
+		return
+	}
+
+	var spec processor.RenderSpec
+	if err := decodeJSON(w, r, &spec); err != nil {
+		respondError(w, http.StatusBadRequest, CodeInvalidSpec, "Invalid render spec: "+err.Error())
+		return
+	}
+	if err := spec.Validate(); err != nil {
+		respondError(w, http.StatusBadRequest, CodeInvalidSpec, err.Error())
+		return
+	}
+
+	if err := h.presets.Put(r.Context(), name, spec); err != nil {
+		h.respondPresetError(w, name, err)
+		return
+	}
+	h.logger.Infow("Saved render preset", "preset", name, "layers", len(spec.Layers))
+	respondJSON(w, http.StatusOK, spec)
+}
+
+// DeletePreset handles DELETE /admin/presets/{name}.
+func (h *RenderHandler) DeletePreset(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+	if err := h.presets.Delete(r.Context(), name); err != nil {
+		h.respondPresetError(w, name, err)
+		return
+	}
+	h.logger.Infow("Deleted render preset", "preset", name)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (h *RenderHandler) respondPresetError(w http.ResponseWriter, name string, err error) {
+	switch {
+	case errors.Is(err, specs.ErrNotFound):
+		respondError(w, http.StatusNotFound, CodeNotFound, "Render preset not found: "+name)
+	case errors.Is(err, specs.ErrInvalidName):
+		respondError(w, http.StatusBadRequest, CodeInvalidInput, err.Error())
+	default:
+		h.logger.Errorw("Render preset store failed", "preset", name, "error", err)
+		respondError(w, http.StatusBadGateway, CodeUpstream, "Failed to access render presets")
+	}
+}
+
+// decodeJSON decodes a bounded JSON body into v, rejecting unknown fields.
+func decodeJSON(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxRenderSpecBytes))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
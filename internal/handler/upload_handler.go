@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"strconv"
+	"watermark/internal/service"
+	"watermark/pkg/logger"
+)
+
+const (
+	// uploadFormField is the multipart field carrying the image.
+	uploadFormField = "image"
+	// maxFormValueBytes bounds each non-file multipart field.
+	maxFormValueBytes = 1 << 10
+	// multipartOverhead allows for boundaries and headers around the image.
+	multipartOverhead = 64 << 10
+)
+
+// uploadTypes are the sniffed content types the processor can decode.
+var uploadTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+}
+
+// UploadHandler serves POST /watermark, which watermarks an image sent in the
+// request body instead of one read from storage.
+type UploadHandler struct {
+	service  *service.ImageService
+	maxBytes int64
+	logger   *logger.Logger
+}
+
+func NewUploadHandler(service *service.ImageService, maxBytes int64, logger *logger.Logger) *UploadHandler {
+	return &UploadHandler{
+		service:  service,
+		maxBytes: maxBytes,
+		logger:   logger,
+	}
+}
+
+// Watermark accepts either a raw image body or multipart/form-data with the
+// image in the "image" field. Render parameters come from the query string
+// or, for multipart requests, from form fields.
+func (h *UploadHandler) Watermark(w http.ResponseWriter, r *http.Request) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.maxBytes+multipartOverhead)
+
+	params := r.URL.Query()
+	var data []byte
+	var err error
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "multipart/form-data" {
+		data, err = h.readMultipart(r, params)
+	} else {
+		data, err = readLimited(r.Body, h.maxBytes)
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	switch {
+	case errors.Is(err, service.ErrTooLarge), errors.As(err, &maxBytesErr):
+		respondError(w, http.StatusRequestEntityTooLarge, CodeTooLarge,
+			fmt.Sprintf("Image exceeds the upload limit of %d bytes", h.maxBytes))
+		return
+	case err != nil:
+		respondError(w, http.StatusBadRequest, CodeInvalidInput, "Invalid upload: "+err.Error())
+		return
+	case len(data) == 0:
+		respondError(w, http.StatusBadRequest, CodeInvalidInput, "No image in request body")
+		return
+	}
+
+	if sniffed := http.DetectContentType(data); !uploadTypes[sniffed] {
+		respondError(w, http.StatusUnsupportedMediaType, CodeUnsupportedFormat,
+			fmt.Sprintf("Unsupported image type %s", sniffed))
+		return
+	}
+
+	req, err := parseRenderParams("upload", params, false)
+	if err != nil {
+		respondInvalidInput(w, "", err)
+		return
+	}
+	if req.Locale, err = requestLocale(w, r, h.service, params.Get("lang")); err != nil {
+		respondServiceError(w, h.logger, req.ImageID, err)
+		return
+	}
+	result, err := h.service.ProcessUpload(r.Context(), data, req)
+	if err != nil {
+		respondServiceError(w, h.logger, req.ImageID, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", result.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(result.Data)))
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write(result.Data)
+}
+
+// readMultipart returns the image part of a multipart request, adding its
+// other fields to params. Query parameters take precedence over form fields.
+func (h *UploadHandler) readMultipart(r *http.Request, params url.Values) ([]byte, error) {
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			return data, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		name := part.FormName()
+		switch {
+		case name == uploadFormField:
+			if data != nil {
+				return nil, errors.New("more than one image field")
+			}
+			if data, err = readLimited(part, h.maxBytes); err != nil {
+				return nil, err
+			}
+		case name != "" && params.Get(name) == "":
+			value, err := readLimited(part, maxFormValueBytes)
+			if err != nil {
+				return nil, fmt.Errorf("form field %s: %w", name, err)
+			}
+			params.Set(name, string(value))
+		}
+		part.Close()
+	}
+}
+
+// readLimited reads r, failing with service.ErrTooLarge past limit bytes.
+func readLimited(r io.Reader, limit int64) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, service.ErrTooLarge
+	}
+	return data, nil
+}
@@ -0,0 +1,127 @@
+// Package health runs the dependency checks behind the readiness endpoint.
+package health
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/golang/freetype"
+	"github.com/redis/go-redis/v9"
+
+	"watermark/internal/config"
+)
+
+// Check statuses reported in Result and Report.
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+// Check is a named dependency check.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) error
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the outcome of all checks. Status is ok only if every check passed.
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks"`
+}
+
+// Checker runs a fixed set of checks.
+type Checker struct {
+	checks  []Check
+	timeout time.Duration
+}
+
+// NewChecker creates a Checker that gives each check up to timeout.
+func NewChecker(timeout time.Duration, checks ...Check) *Checker {
+	return &Checker{checks: checks, timeout: timeout}
+}
+
+// Run runs every check concurrently and reports the results.
+func (c *Checker) Run(ctx context.Context) Report {
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(c.checks))}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range c.checks {
+		wg.Add(1)
+		go func(check Check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, c.timeout)
+			defer cancel()
+
+			start := time.Now()
+			err := check.Run(checkCtx)
+			result := Result{Status: StatusOK, Duration: time.Since(start).Round(time.Millisecond).String()}
+			if err != nil {
+				result.Status = StatusFail
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Checks[check.Name] = result
+			if err != nil {
+				report.Status = StatusFail
+			}
+		}(check)
+	}
+	wg.Wait()
+	return report
+}
+
+// RedisCheck pings Redis.
+func RedisCheck(cfg config.RedisConfig) Check {
+	client := redis.NewClient(&redis.Options{
+		Addr:     cfg.Addr,
+		Password: cfg.Password,
+		DB:       cfg.DB,
+	})
+	return Check{Name: "redis", Run: func(ctx context.Context) error {
+		return client.Ping(ctx).Err()
+	}}
+}
+
+// FontCheck makes sure the watermark font can still be read and parsed.
+func FontCheck(path string) Check {
+	return Check{Name: "font", Run: func(ctx context.Context) error {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if _, err := freetype.ParseFont(data); err != nil {
+			return fmt.Errorf("failed to parse font %s: %w", path, err)
+		}
+		return nil
+	}}
+}
+
+// DirWritableCheck makes sure files can be created in dir.
+func DirWritableCheck(name, dir string) Check {
+	return Check{Name: name, Run: func(ctx context.Context) error {
+		f, err := os.CreateTemp(dir, ".readyz-*")
+		if err != nil {
+			return err
+		}
+		_, werr := f.Write([]byte("ok"))
+		cerr := f.Close()
+		os.Remove(f.Name())
+		if werr != nil {
+			return werr
+		}
+		return cerr
+	}}
+}
@@ -0,0 +1,104 @@
+package jobs
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"watermark/internal/service"
+)
+
+// Job statuses.
+const (
+	StatusQueued    = "queued"
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+var (
+	// ErrNotFound is returned when a job ID is unknown or has expired.
+	ErrNotFound = errors.New("job not found")
+	// ErrQueueFull is returned when a queue cannot accept more jobs.
+	ErrQueueFull = errors.New("job queue full")
+	// ErrResultTooLarge is returned when a job's output can't be kept.
+	ErrResultTooLarge = errors.New("job output too large to keep")
+)
+
+// Spec describes the render a job performs. Weight is a number of kilograms
+// or a string with a unit; see measure.ParseWeight.
+type Spec struct {
+	ImageID string `json:"image_id"`
+	service.RenderParams
+}
+
+// ProcessRequest validates the spec and converts it into a service request.
+// With stored, weight and dimensions may be left out for the image's stored
+// metadata to provide.
+func (s Spec) ProcessRequest(stored bool) (service.ProcessRequest, error) {
+	return s.Request(s.ImageID, stored)
+}
+
+// Job is an asynchronous render and its current state.
+type Job struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	Progress  int       `json:"progress"` // Percent complete
+	Spec      Spec      `json:"spec"`
+	Error     string    `json:"error,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// NewJob creates a queued job for spec with a random ID.
+func NewJob(spec Spec) (*Job, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	return &Job{
+		ID:        hex.EncodeToString(id),
+		Status:    StatusQueued,
+		Spec:      spec,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, nil
+}
+
+// Result is the output of a succeeded job.
+type Result struct {
+	Data        []byte
+	ContentType string
+}
+
+// Done reports whether the job has finished, successfully or not.
+func (j *Job) Done() bool {
+	return j.Status == StatusSucceeded || j.Status == StatusFailed
+}
+
+// Queue stores jobs and hands them out to workers.
+type Queue interface {
+	// Enqueue saves a new job and makes it available to workers.
+	Enqueue(ctx context.Context, job *Job) error
+	// Dequeue blocks until a job is available or ctx is done.
+	Dequeue(ctx context.Context) (*Job, error)
+	// Get returns the current state of a job, or ErrNotFound.
+	Get(ctx context.Context, id string) (*Job, error)
+	// Update saves a job's state.
+	Update(ctx context.Context, job *Job) error
+	// SaveResult stores a job's output, which is kept no longer than the job
+	// record itself. It returns ErrResultTooLarge if the output can't be kept.
+	SaveResult(ctx context.Context, id string, result *Result) error
+	// Result returns a job's output, or ErrNotFound.
+	Result(ctx context.Context, id string) (*Result, error)
+}
+
+// Sweeper is implemented by queues that need upkeep on a timer, such as
+// forgetting expired jobs or failing jobs whose worker went away. The worker
+// pool calls Sweep periodically.
+type Sweeper interface {
+	Sweep(ctx context.Context) error
+}
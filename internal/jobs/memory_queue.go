@@ -0,0 +1,165 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// MemoryQueue implements Queue in process. Jobs are lost on restart and are
+// only visible to the instance that accepted them.
+type MemoryQueue struct {
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	results map[string]*Result
+	pending chan string
+	ttl     time.Duration
+	// resultBytes is the size of all kept output, at most maxResultBytes.
+	resultBytes    int64
+	maxResultBytes int64
+}
+
+// NewMemoryQueue creates a queue holding at most size pending jobs.
+// Finished jobs are forgotten ttl after their last update. Job output is kept
+// up to maxResultBytes in total; the output of the jobs that finished first
+// is dropped to make room.
+func NewMemoryQueue(size int, ttl time.Duration, maxResultBytes int64) *MemoryQueue {
+	return &MemoryQueue{
+		jobs:           make(map[string]*Job),
+		results:        make(map[string]*Result),
+		pending:        make(chan string, size),
+		ttl:            ttl,
+		maxResultBytes: maxResultBytes,
+	}
+}
+
+// Enqueue saves a new job and makes it available to workers.
+func (q *MemoryQueue) Enqueue(ctx context.Context, job *Job) error {
+	q.mu.Lock()
+	q.jobs[job.ID] = copyJob(job)
+	q.mu.Unlock()
+
+	select {
+	case q.pending <- job.ID:
+		return nil
+	default:
+		q.mu.Lock()
+		delete(q.jobs, job.ID)
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+}
+
+// Dequeue blocks until a job is available or ctx is done.
+func (q *MemoryQueue) Dequeue(ctx context.Context) (*Job, error) {
+	for {
+		select {
+		case id := <-q.pending:
+			job, err := q.Get(ctx, id)
+			if errors.Is(err, ErrNotFound) {
+				continue
+			}
+			return job, err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Get returns the current state of a job.
+func (q *MemoryQueue) Get(ctx context.Context, id string) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyJob(job), nil
+}
+
+// Update saves a job's state.
+func (q *MemoryQueue) Update(ctx context.Context, job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.jobs[job.ID] = copyJob(job)
+	return nil
+}
+
+// SaveResult stores a job's output until the job is forgotten or the output
+// is dropped to make room for newer output.
+func (q *MemoryQueue) SaveResult(ctx context.Context, id string, result *Result) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.jobs[id]; !ok {
+		return ErrNotFound
+	}
+	size := int64(len(result.Data))
+	if size > q.maxResultBytes {
+		return ErrResultTooLarge
+	}
+	q.dropResultLocked(id)
+	for q.resultBytes+size > q.maxResultBytes {
+		q.dropResultLocked(q.oldestResultLocked())
+	}
+	q.results[id] = result
+	q.resultBytes += size
+	return nil
+}
+
+// Result returns a job's output.
+func (q *MemoryQueue) Result(ctx context.Context, id string) (*Result, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	result, ok := q.results[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return result, nil
+}
+
+// Sweep forgets finished jobs older than the TTL, and their output.
+func (q *MemoryQueue) Sweep(ctx context.Context) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	cutoff := time.Now().Add(-q.ttl)
+	for id, job := range q.jobs {
+		if job.Done() && job.UpdatedAt.Before(cutoff) {
+			delete(q.jobs, id)
+			q.dropResultLocked(id)
+		}
+	}
+	return nil
+}
+
+// oldestResultLocked returns the ID of the job with output that was updated
+// longest ago. q.mu must be held.
+func (q *MemoryQueue) oldestResultLocked() string {
+	var oldest string
+	var oldestAt time.Time
+	for id := range q.results {
+		at := q.jobs[id].UpdatedAt
+		if oldest == "" || at.Before(oldestAt) {
+			oldest, oldestAt = id, at
+		}
+	}
+	return oldest
+}
+
+// dropResultLocked forgets a job's output, if any. q.mu must be held.
+func (q *MemoryQueue) dropResultLocked(id string) {
+	if result, ok := q.results[id]; ok {
+		q.resultBytes -= int64(len(result.Data))
+		delete(q.results, id)
+	}
+}
+
+func copyJob(job *Job) *Job {
+	c := *job
+	return &c
+}
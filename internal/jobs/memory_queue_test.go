@@ -0,0 +1,97 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// finishedJob enqueues a job, dequeues it and marks it succeeded at updatedAt.
+func finishedJob(t *testing.T, q Queue, updatedAt time.Time) *Job {
+	t.Helper()
+	ctx := context.Background()
+	job, err := NewJob(Spec{ImageID: "a.jpg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := q.Enqueue(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	if job, err = q.Dequeue(ctx); err != nil {
+		t.Fatal(err)
+	}
+	job.Status = StatusSucceeded
+	job.UpdatedAt = updatedAt
+	if err := q.Update(ctx, job); err != nil {
+		t.Fatal(err)
+	}
+	return job
+}
+
+func TestMemoryQueueResultBudget(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10, time.Hour, 10)
+	now := time.Now()
+
+	first := finishedJob(t, q, now.Add(-2*time.Minute))
+	second := finishedJob(t, q, now.Add(-time.Minute))
+	third := finishedJob(t, q, now)
+	for _, job := range []*Job{first, second} {
+		if err := q.SaveResult(ctx, job.ID, &Result{Data: make([]byte, 4)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Keeping the third result means dropping the oldest one.
+	if err := q.SaveResult(ctx, third.ID, &Result{Data: make([]byte, 4)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Result(ctx, first.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("oldest result: %v, want it dropped", err)
+	}
+	for _, job := range []*Job{second, third} {
+		if _, err := q.Result(ctx, job.ID); err != nil {
+			t.Errorf("newer result: %v, want it kept", err)
+		}
+	}
+	if q.resultBytes != 8 {
+		t.Errorf("resultBytes = %d, want 8", q.resultBytes)
+	}
+
+	// Output larger than the whole budget is refused without dropping anything.
+	if err := q.SaveResult(ctx, first.ID, &Result{Data: make([]byte, 11)}); !errors.Is(err, ErrResultTooLarge) {
+		t.Errorf("SaveResult() over budget = %v, want ErrResultTooLarge", err)
+	}
+	if _, err := q.Result(ctx, second.ID); err != nil {
+		t.Errorf("a refused result dropped another: %v", err)
+	}
+}
+
+func TestMemoryQueueSweep(t *testing.T) {
+	ctx := context.Background()
+	q := NewMemoryQueue(10, time.Hour, 1<<20)
+
+	expired := finishedJob(t, q, time.Now().Add(-2*time.Hour))
+	recent := finishedJob(t, q, time.Now())
+	q.SaveResult(ctx, expired.ID, &Result{Data: []byte("old")})
+	running, _ := NewJob(Spec{ImageID: "b.jpg"})
+	running.Status = StatusRunning
+	running.UpdatedAt = time.Now().Add(-2 * time.Hour)
+	q.Update(ctx, running)
+
+	if err := q.Sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := q.Get(ctx, expired.ID); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expired job: %v, want it forgotten", err)
+	}
+	if q.resultBytes != 0 {
+		t.Errorf("resultBytes = %d after sweeping the only result, want 0", q.resultBytes)
+	}
+	for _, job := range []*Job{recent, running} {
+		if _, err := q.Get(ctx, job.ID); err != nil {
+			t.Errorf("%s job: %v, want it kept", job.Status, err)
+		}
+	}
+}
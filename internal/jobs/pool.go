@@ -0,0 +1,190 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"watermark/internal/service"
+)
+
+var (
+	jobsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "render_jobs_total",
+		Help: "The total number of render jobs finished, by status.",
+	}, []string{"status"})
+	jobDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "render_job_duration_seconds",
+		Help:    "Duration of render jobs.",
+		Buckets: prometheus.ExponentialBuckets(0.5, 2, 10),
+	})
+)
+
+// sweepInterval is how often the pool has its queue do upkeep, see Sweeper.
+const sweepInterval = time.Minute
+
+// stageProgress maps service stages to the percentage reported once they start.
+var stageProgress = map[string]int{
+	service.StageCacheLookup: 10,
+	service.StageOriginFetch: 30,
+	service.StageRender:      60,
+}
+
+// Renderer performs the render for a job.
+type Renderer interface {
+	ProcessImage(ctx context.Context, req service.ProcessRequest) (*service.ProcessResult, error)
+}
+
+// WorkerPool runs jobs from a Queue on a fixed number of workers.
+type WorkerPool struct {
+	queue    Queue
+	renderer Renderer
+	timeout  time.Duration
+	log      *logrus.Entry
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// NewWorkerPool creates a WorkerPool and starts its workers.
+// Each job gets timeout to finish, independent of any HTTP request.
+func NewWorkerPool(queue Queue, renderer Renderer, workers int, timeout time.Duration, logger *logrus.Logger) *WorkerPool {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &WorkerPool{
+		queue:    queue,
+		renderer: renderer,
+		timeout:  timeout,
+		log:      logger.WithField("component", "WorkerPool"),
+		cancel:   cancel,
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+	p.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go p.work(ctx)
+	}
+	if sweeper, ok := queue.(Sweeper); ok {
+		p.wg.Add(1)
+		go p.sweep(ctx, sweeper)
+	}
+	return p
+}
+
+// Close stops taking new jobs and waits for running jobs to finish,
+// or for ctx to be done, whichever comes first.
+func (p *WorkerPool) Close(ctx context.Context) error {
+	p.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		p.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *WorkerPool) work(ctx context.Context) {
+	defer p.wg.Done()
+	for {
+		job, err := p.queue.Dequeue(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			p.log.WithError(err).Error("Failed to dequeue job")
+			// Back off so a broken queue backend doesn't spin.
+			select {
+			case <-time.After(time.Second):
+			case <-ctx.Done():
+				return
+			}
+			continue
+		}
+		p.run(job)
+	}
+}
+
+func (p *WorkerPool) sweep(ctx context.Context, sweeper Sweeper) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := sweeper.Sweep(ctx); err != nil && ctx.Err() == nil {
+				p.log.WithError(err).Error("Failed to sweep job queue")
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// run executes a job. Running jobs are not interrupted by Close; they have their own deadline.
+func (p *WorkerPool) run(job *Job) {
+	log := p.log.WithField("job_id", job.ID).WithField("image_id", job.Spec.ImageID)
+	ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+	defer cancel()
+
+	startTime := time.Now()
+	job.Status = StatusRunning
+	p.save(ctx, job, log)
+
+	ctx = service.WithProgress(ctx, func(stage string) {
+		if progress, ok := stageProgress[stage]; ok && progress > job.Progress {
+			job.Progress = progress
+			p.save(ctx, job, log)
+		}
+	})
+
+	// A job isn't bound by an HTTP response, so the service's per-request and
+	// per-stage timeouts give way to the job's own.
+	ctx = service.WithTimeouts(ctx, service.Timeouts{Request: p.timeout})
+	// The spec was validated when the job was submitted.
+	req, err := job.Spec.ProcessRequest(true)
+	var result *service.ProcessResult
+	if err == nil {
+		result, err = p.renderer.ProcessImage(ctx, req)
+	}
+	if err == nil {
+		// The render cache is best effort, so the output is kept with the job.
+		err = p.queue.SaveResult(context.Background(), job.ID, &Result{Data: result.Data, ContentType: result.ContentType})
+	}
+	if err != nil {
+		job.Status = StatusFailed
+		job.Error = err.Error()
+		if errors.Is(err, service.ErrTimeout) {
+			job.Error = "job timed out"
+		}
+		log.WithError(err).Warn("Render job failed")
+	} else {
+		job.Status = StatusSucceeded
+		job.Progress = 100
+		log.Info("Render job succeeded")
+	}
+
+	jobsProcessed.WithLabelValues(job.Status).Inc()
+	jobDuration.Observe(time.Since(startTime).Seconds())
+	// The job's own deadline may have passed; the final state must still be saved.
+	p.save(context.Background(), job, log)
+}
+
+func (p *WorkerPool) save(ctx context.Context, job *Job, log *logrus.Entry) {
+	job.UpdatedAt = time.Now().UTC()
+	if err := p.queue.Update(ctx, job); err != nil {
+		log.WithError(err).Error("Failed to save job state")
+	}
+}
@@ -0,0 +1,173 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis answers go-redis commands from memory by intercepting them in a
+// hook, so RedisQueue can be tested without a server. It knows just the
+// commands this package sends, and ignores expiries.
+type fakeRedis struct {
+	mu      sync.Mutex
+	strings map[string]string
+	lists   map[string][]string
+	hashes  map[string]map[string]string
+}
+
+func newFakeRedisClient() (*redis.Client, *fakeRedis) {
+	f := &fakeRedis{
+		strings: make(map[string]string),
+		lists:   make(map[string][]string),
+		hashes:  make(map[string]map[string]string),
+	}
+	client := redis.NewClient(&redis.Options{Addr: "fake:6379"})
+	client.AddHook(f)
+	return client, f
+}
+
+func (f *fakeRedis) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, fmt.Errorf("fake redis doesn't dial")
+	}
+}
+
+func (f *fakeRedis) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.process(cmd)
+		return cmd.Err()
+	}
+}
+
+func (f *fakeRedis) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		var firstErr error
+		for _, cmd := range cmds {
+			f.process(cmd)
+			if err := cmd.Err(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+}
+
+// list returns a copy of the list at key.
+func (f *fakeRedis) list(key string) []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]string(nil), f.lists[key]...)
+}
+
+func (f *fakeRedis) hash(key string) map[string]string {
+	h := f.hashes[key]
+	if h == nil {
+		h = make(map[string]string)
+		f.hashes[key] = h
+	}
+	return h
+}
+
+func (f *fakeRedis) process(cmd redis.Cmder) {
+	args := make([]string, len(cmd.Args()))
+	for i, arg := range cmd.Args() {
+		switch v := arg.(type) {
+		case []byte:
+			args[i] = string(v)
+		default:
+			args[i] = fmt.Sprint(v)
+		}
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "multi", "exec":
+		// Commands inside a transaction are applied as they come.
+	case "set":
+		f.strings[args[1]] = args[2]
+		cmd.(*redis.StatusCmd).SetVal("OK")
+	case "get":
+		if v, ok := f.strings[args[1]]; ok {
+			cmd.(*redis.StringCmd).SetVal(v)
+		} else {
+			cmd.SetErr(redis.Nil)
+		}
+	case "expire":
+		_, ok := f.strings[args[1]]
+		if _, isHash := f.hashes[args[1]]; isHash {
+			ok = true
+		}
+		cmd.(*redis.BoolCmd).SetVal(ok)
+	case "rpush":
+		f.lists[args[1]] = append(f.lists[args[1]], args[2:]...)
+		cmd.(*redis.IntCmd).SetVal(int64(len(f.lists[args[1]])))
+	case "blmove":
+		// Only LEFT to RIGHT, and an empty source doesn't block.
+		src := f.lists[args[1]]
+		if len(src) == 0 {
+			cmd.SetErr(redis.Nil)
+			return
+		}
+		f.lists[args[1]] = src[1:]
+		f.lists[args[2]] = append(f.lists[args[2]], src[0])
+		cmd.(*redis.StringCmd).SetVal(src[0])
+	case "lrem":
+		// Only a count of 0, which removes every occurrence.
+		var kept []string
+		removed := 0
+		for _, v := range f.lists[args[1]] {
+			if v == args[3] {
+				removed++
+			} else {
+				kept = append(kept, v)
+			}
+		}
+		f.lists[args[1]] = kept
+		cmd.(*redis.IntCmd).SetVal(int64(removed))
+	case "lrange":
+		// Only the whole list.
+		cmd.(*redis.StringSliceCmd).SetVal(append([]string{}, f.lists[args[1]]...))
+	case "hset":
+		h := f.hash(args[1])
+		added := 0
+		for i := 2; i+1 < len(args); i += 2 {
+			if _, ok := h[args[i]]; !ok {
+				added++
+			}
+			h[args[i]] = args[i+1]
+		}
+		cmd.(*redis.IntCmd).SetVal(int64(added))
+	case "hsetnx":
+		h := f.hash(args[1])
+		_, exists := h[args[2]]
+		if !exists {
+			h[args[2]] = args[3]
+		}
+		cmd.(*redis.BoolCmd).SetVal(!exists)
+	case "hgetall":
+		fields := make(map[string]string)
+		for k, v := range f.hashes[args[1]] {
+			fields[k] = v
+		}
+		cmd.(*redis.MapStringStringCmd).SetVal(fields)
+	case "hdel":
+		deleted := 0
+		for _, field := range args[2:] {
+			if _, ok := f.hashes[args[1]][field]; ok {
+				delete(f.hashes[args[1]], field)
+				deleted++
+			}
+		}
+		cmd.(*redis.IntCmd).SetVal(int64(deleted))
+	default:
+		cmd.SetErr(fmt.Errorf("fake redis: unsupported command %q", args[0]))
+	}
+}
@@ -0,0 +1,231 @@
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+
+	"watermark/internal/config"
+)
+
+const (
+	redisQueueKey = "jobs:pending"
+	// redisProcessingKey lists the jobs handed to workers and not yet finished.
+	redisProcessingKey = "jobs:processing"
+	// redisClaimsKey maps each job in redisProcessingKey to when it was handed
+	// out, in Unix milliseconds.
+	redisClaimsKey    = "jobs:claims"
+	redisJobKeyPrefix = "job:"
+	// redisResultKeyPrefix holds a job's output as a hash of data and content_type.
+	redisResultKeyPrefix = "job-result:"
+	// redisPollTimeout bounds each blocking pop so Dequeue notices ctx cancellation.
+	redisPollTimeout = 5 * time.Second
+)
+
+// RedisQueue implements Queue on Redis, so any instance can accept, run or report on a job.
+//
+// Dequeued jobs move to a processing list until they finish. Jobs are
+// delivered at most once: if a worker stops without finishing a job, Sweep
+// marks the job failed once it has been out for longer than the visibility
+// timeout, rather than running it again.
+type RedisQueue struct {
+	client     *redis.Client
+	ttl        time.Duration
+	visibility time.Duration
+}
+
+// NewRedisQueue creates a Redis-backed queue. Job records expire ttl after
+// their last update. visibility must be longer than any job can run.
+func NewRedisQueue(cfg config.RedisConfig, ttl, visibility time.Duration) *RedisQueue {
+	return &RedisQueue{
+		client: redis.NewClient(&redis.Options{
+			Addr:     cfg.Addr,
+			Password: cfg.Password,
+			DB:       cfg.DB,
+		}),
+		ttl:        ttl,
+		visibility: visibility,
+	}
+}
+
+// Enqueue saves a new job and makes it available to workers.
+func (q *RedisQueue) Enqueue(ctx context.Context, job *Job) error {
+	if err := q.Update(ctx, job); err != nil {
+		return err
+	}
+	if err := q.client.RPush(ctx, redisQueueKey, job.ID).Err(); err != nil {
+		return fmt.Errorf("redis RPUSH failed for job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+// Dequeue blocks until a job is available or ctx is done. The job stays on
+// the processing list until an Update marks it finished.
+func (q *RedisQueue) Dequeue(ctx context.Context) (*Job, error) {
+	for {
+		id, err := q.client.BLMove(ctx, redisQueueKey, redisProcessingKey, "LEFT", "RIGHT", redisPollTimeout).Result()
+		if errors.Is(err, redis.Nil) {
+			continue // Poll timed out with nothing queued
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("redis BLMOVE failed: %w", err)
+		}
+		// If this instance stops before the claim is recorded, Sweep records it.
+		if err := q.client.HSet(ctx, redisClaimsKey, id, time.Now().UnixMilli()).Err(); err != nil {
+			return nil, fmt.Errorf("redis HSET failed for job %s: %w", id, err)
+		}
+
+		job, err := q.Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			// Expired while queued.
+			if err := q.release(ctx, id); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		return job, err
+	}
+}
+
+// Get returns the current state of a job.
+func (q *RedisQueue) Get(ctx context.Context, id string) (*Job, error) {
+	data, err := q.client.Get(ctx, redisJobKeyPrefix+id).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis GET failed for job %s: %w", id, err)
+	}
+
+	var job Job
+	if err := json.Unmarshal(data, &job); err != nil {
+		return nil, fmt.Errorf("corrupt job record %s: %w", id, err)
+	}
+	return &job, nil
+}
+
+// Update saves a job's state. The job's output, if any, gets the same expiry,
+// so it can't expire while the job still reports success. A finished job
+// leaves the processing list.
+func (q *RedisQueue) Update(ctx context.Context, job *Job) error {
+	data, err := json.Marshal(job)
+	if err != nil {
+		return err
+	}
+	_, err = q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, redisJobKeyPrefix+job.ID, data, q.ttl)
+		pipe.Expire(ctx, redisResultKeyPrefix+job.ID, q.ttl)
+		if job.Done() {
+			pipe.LRem(ctx, redisProcessingKey, 0, job.ID)
+			pipe.HDel(ctx, redisClaimsKey, job.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis SET failed for job %s: %w", job.ID, err)
+	}
+	return nil
+}
+
+// SaveResult stores a job's output with the job's expiry.
+func (q *RedisQueue) SaveResult(ctx context.Context, id string, result *Result) error {
+	key := redisResultKeyPrefix + id
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, key, "data", result.Data, "content_type", result.ContentType)
+		pipe.Expire(ctx, key, q.ttl)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis HSET failed for job result %s: %w", id, err)
+	}
+	return nil
+}
+
+// Result returns a job's output.
+func (q *RedisQueue) Result(ctx context.Context, id string) (*Result, error) {
+	fields, err := q.client.HGetAll(ctx, redisResultKeyPrefix+id).Result()
+	if err != nil {
+		return nil, fmt.Errorf("redis HGETALL failed for job result %s: %w", id, err)
+	}
+	data, ok := fields["data"]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &Result{Data: []byte(data), ContentType: fields["content_type"]}, nil
+}
+
+// Sweep fails the jobs that have been out with a worker for longer than the
+// visibility timeout, since their worker must have stopped.
+func (q *RedisQueue) Sweep(ctx context.Context) error {
+	ids, err := q.client.LRange(ctx, redisProcessingKey, 0, -1).Result()
+	if err != nil {
+		return fmt.Errorf("redis LRANGE failed: %w", err)
+	}
+	claims, err := q.client.HGetAll(ctx, redisClaimsKey).Result()
+	if err != nil {
+		return fmt.Errorf("redis HGETALL failed: %w", err)
+	}
+
+	now := time.Now()
+	for _, id := range ids {
+		claimed, ok := claims[id]
+		if !ok {
+			// The worker stopped between taking the job and claiming it;
+			// start its clock now.
+			if err := q.client.HSetNX(ctx, redisClaimsKey, id, now.UnixMilli()).Err(); err != nil {
+				return fmt.Errorf("redis HSETNX failed for job %s: %w", id, err)
+			}
+			continue
+		}
+		ms, _ := strconv.ParseInt(claimed, 10, 64)
+		if now.Sub(time.UnixMilli(ms)) < q.visibility {
+			continue
+		}
+
+		job, err := q.Get(ctx, id)
+		if errors.Is(err, ErrNotFound) {
+			if err := q.release(ctx, id); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !job.Done() {
+			job.Status = StatusFailed
+			job.Error = "job abandoned: its worker stopped before it finished"
+			job.UpdatedAt = now.UTC()
+			jobsProcessed.WithLabelValues(job.Status).Inc()
+		}
+		if err := q.Update(ctx, job); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// release takes a job off the processing list.
+func (q *RedisQueue) release(ctx context.Context, id string) error {
+	_, err := q.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LRem(ctx, redisProcessingKey, 0, id)
+		pipe.HDel(ctx, redisClaimsKey, id)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("redis LREM failed for job %s: %w", id, err)
+	}
+	return nil
+}
+
+func (q *RedisQueue) Close() error {
+	return q.client.Close()
+}
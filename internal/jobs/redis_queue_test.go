@@ -0,0 +1,94 @@
+package jobs
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestRedisQueueAbandonedJobs(t *testing.T) {
+	ctx := context.Background()
+	client, fake := newFakeRedisClient()
+	q := &RedisQueue{client: client, ttl: time.Hour, visibility: time.Minute}
+
+	enqueue := func() *Job {
+		t.Helper()
+		job, err := NewJob(Spec{ImageID: "a.jpg"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := q.Enqueue(ctx, job); err != nil {
+			t.Fatal(err)
+		}
+		return job
+	}
+	// claimedAgo backdates a job's claim, as if its worker took it then.
+	claimedAgo := func(id string, d time.Duration) {
+		fake.mu.Lock()
+		defer fake.mu.Unlock()
+		fake.hashes[redisClaimsKey][id] = strconv.FormatInt(time.Now().Add(-d).UnixMilli(), 10)
+	}
+
+	finished := enqueue()
+	abandoned := enqueue()
+	busy := enqueue()
+	for i := 0; i < 3; i++ {
+		job, err := q.Dequeue(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job.Status = StatusRunning
+		q.Update(ctx, job)
+	}
+	if got := fake.list(redisProcessingKey); len(got) != 3 {
+		t.Fatalf("processing list = %v, want the three dequeued jobs", got)
+	}
+
+	finished.Status = StatusSucceeded
+	if err := q.Update(ctx, finished); err != nil {
+		t.Fatal(err)
+	}
+	claimedAgo(abandoned.ID, 2*time.Minute)
+	claimedAgo(busy.ID, 30*time.Second)
+
+	if err := q.Sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := fake.list(redisProcessingKey); len(got) != 1 || got[0] != busy.ID {
+		t.Errorf("processing list = %v, want only the job still within its visibility timeout", got)
+	}
+	if job, _ := q.Get(ctx, abandoned.ID); job.Status != StatusFailed || job.Error == "" {
+		t.Errorf("abandoned job = %+v, want it failed", job)
+	}
+	if job, _ := q.Get(ctx, busy.ID); job.Status != StatusRunning {
+		t.Errorf("busy job status = %s, want running", job.Status)
+	}
+	if job, _ := q.Get(ctx, finished.ID); job.Status != StatusSucceeded {
+		t.Errorf("finished job status = %s, want succeeded", job.Status)
+	}
+}
+
+func TestRedisQueueUnclaimedJobs(t *testing.T) {
+	ctx := context.Background()
+	client, fake := newFakeRedisClient()
+	q := &RedisQueue{client: client, ttl: time.Hour, visibility: time.Minute}
+
+	// A worker that stopped right after taking the job never claimed it.
+	job, _ := NewJob(Spec{ImageID: "a.jpg"})
+	q.Update(ctx, job)
+	client.RPush(ctx, redisProcessingKey, job.ID)
+
+	if err := q.Sweep(ctx); err != nil {
+		t.Fatal(err)
+	}
+	fake.mu.Lock()
+	_, claimed := fake.hashes[redisClaimsKey][job.ID]
+	fake.mu.Unlock()
+	if !claimed {
+		t.Fatal("Sweep didn't start the clock on an unclaimed job")
+	}
+	if got, _ := q.Get(ctx, job.ID); got.Status != StatusQueued {
+		t.Errorf("job status = %s right after it was found, want queued", got.Status)
+	}
+}
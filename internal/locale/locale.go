@@ -0,0 +1,257 @@
+// Package locale holds the message catalogs for overlay labels, along with
+// each locale's number and date formatting. Catalogs for the languages in
+// measure's number format table are built in; more locales can be added, and
+// the built-in ones overridden, with files in a directory.
+package locale
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"watermark/internal/measure"
+)
+
+// Fallback is the locale used for anything no other locale provides.
+const Fallback = "en"
+
+// defaultDatePattern is used by locales that don't set one.
+const defaultDatePattern = "YYYY-MM-DD"
+
+//go:embed catalogs/*.yaml
+var builtin embed.FS
+
+// tagPattern matches the locale tags catalog files may be named after, like de or de-ch.
+var tagPattern = regexp.MustCompile(`^[a-z]{2,3}(-[a-z0-9]{2,8})?$`)
+
+// datePatterns translates date pattern tokens into Go layouts. Longer tokens
+// come first so they win over their prefixes.
+var datePatterns = strings.NewReplacer("YYYY", "2006", "YY", "06", "MM", "01", "DD", "02", "M", "1", "D", "2")
+
+// file is the layout of a catalog file.
+type file struct {
+	// Labels are the overlay's words, such as "weight" → "Gewicht".
+	Labels map[string]string `yaml:"labels" json:"labels"`
+	Number *struct {
+		Decimal string `yaml:"decimal" json:"decimal"`
+		Group   string `yaml:"group" json:"group"`
+	} `yaml:"number" json:"number"`
+	// Date is a pattern like DD.MM.YYYY, where D and M are unpadded.
+	Date string `yaml:"date" json:"date"`
+}
+
+// Locale is a resolved catalog.
+type Locale struct {
+	Tag    string
+	Labels map[string]string
+	Number measure.NumberFormat
+	// DateLayout is a time.Format layout.
+	DateLayout string
+	// Hash identifies the resolved contents, so editing a catalog file
+	// changes the cache keys of renders in its locale.
+	Hash string
+}
+
+// FormatDate formats t, e.g. "18.10.2026".
+func (l *Locale) FormatDate(t time.Time) string {
+	return t.Format(l.DateLayout)
+}
+
+// Vars returns the template variables the locale provides: each label as
+// label_<name>, and date formatted, or empty if date is zero.
+func (l *Locale) Vars(date time.Time) map[string]string {
+	vars := make(map[string]string, len(l.Labels)+1)
+	for name, label := range l.Labels {
+		vars["label_"+name] = label
+	}
+	vars["date"] = ""
+	if !date.IsZero() {
+		vars["date"] = l.FormatDate(date)
+	}
+	return vars
+}
+
+// Catalog holds every configured locale.
+type Catalog struct {
+	locales map[string]*Locale
+}
+
+// Load builds the catalog from the built-in locales and the files in dir,
+// which may be YAML (.yaml, .yml) or JSON (.json) and are named after their
+// locale, like de.yaml or pt-br.json. A file for a built-in locale is merged
+// over it. A regional variant like de-ch inherits what it doesn't define from
+// its language, and labels missing from both come from English. An empty
+// dir only loads the built-in locales.
+func Load(dir string) (*Catalog, error) {
+	files := map[string]file{}
+	entries, err := fs.ReadDir(builtin, "catalogs")
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		data, err := fs.ReadFile(builtin, "catalogs/"+e.Name())
+		if err != nil {
+			return nil, err
+		}
+		if err := addFile(files, e.Name(), data); err != nil {
+			return nil, err
+		}
+	}
+
+	if dir != "" {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read locales dir: %w", err)
+		}
+		for _, e := range entries {
+			switch strings.ToLower(filepath.Ext(e.Name())) {
+			case ".yaml", ".yml", ".json":
+			default:
+				continue
+			}
+			data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read locale file: %w", err)
+			}
+			if err := addFile(files, e.Name(), data); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	c := &Catalog{locales: make(map[string]*Locale, len(files))}
+	for tag := range files {
+		// A regional variant inherits from its language, and every locale
+		// takes labels and dates from English. Number formats aren't taken from
+		// English, since the built-in table knows more locales than the catalogs.
+		chain := []string{Fallback}
+		if lang, _, ok := strings.Cut(tag, "-"); ok && lang != Fallback {
+			chain = append(chain, lang)
+		}
+		chain = append(chain, tag)
+
+		l := &Locale{
+			Tag:    tag,
+			Labels: map[string]string{},
+			Number: measure.NumberFormatFor(tag),
+		}
+		date := defaultDatePattern
+		for _, t := range chain {
+			f, ok := files[t]
+			if !ok {
+				continue
+			}
+			for name, label := range f.Labels {
+				l.Labels[name] = label
+			}
+			if f.Number != nil && (t != Fallback || tag == Fallback) {
+				l.Number = measure.NumberFormat{Decimal: f.Number.Decimal, Group: f.Number.Group}
+			}
+			if f.Date != "" {
+				date = f.Date
+			}
+		}
+		l.DateLayout = datePatterns.Replace(date)
+		// Map keys are marshaled in sorted order, so equal locales hash equally.
+		encoded, err := json.Marshal(l)
+		if err != nil {
+			return nil, err
+		}
+		hash := sha256.Sum256(encoded)
+		l.Hash = hex.EncodeToString(hash[:8])
+		c.locales[tag] = l
+	}
+	return c, nil
+}
+
+// addFile parses a catalog file and merges it into files under its locale tag.
+func addFile(files map[string]file, name string, data []byte) error {
+	ext := filepath.Ext(name)
+	tag := Normalize(strings.TrimSuffix(name, ext))
+	if !tagPattern.MatchString(tag) {
+		return fmt.Errorf("locale file %s: %q is not a locale tag", name, tag)
+	}
+
+	var f file
+	if strings.EqualFold(ext, ".json") {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&f); err != nil {
+			return fmt.Errorf("invalid locale file %s: %w", name, err)
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&f); err != nil {
+			return fmt.Errorf("invalid locale file %s: %w", name, err)
+		}
+	}
+	if f.Number != nil && f.Number.Decimal == "" {
+		return fmt.Errorf("invalid locale file %s: number needs a decimal separator", name)
+	}
+
+	merged := files[tag]
+	if merged.Labels == nil {
+		merged.Labels = map[string]string{}
+	}
+	for k, v := range f.Labels {
+		merged.Labels[k] = v
+	}
+	if f.Number != nil {
+		merged.Number = f.Number
+	}
+	if f.Date != "" {
+		merged.Date = f.Date
+	}
+	files[tag] = merged
+	return nil
+}
+
+// Normalize lowercases a locale tag and turns underscores into dashes, so
+// "de_CH" and "de-CH" are both "de-ch".
+func Normalize(tag string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+}
+
+// Get returns the locale for tag, or for its language when there is no
+// regional variant. ok is false if neither is in the catalog.
+func (c *Catalog) Get(tag string) (l *Locale, ok bool) {
+	tag = Normalize(tag)
+	if l, ok := c.locales[tag]; ok {
+		return l, true
+	}
+	lang, _, _ := strings.Cut(tag, "-")
+	l, ok = c.locales[lang]
+	return l, ok
+}
+
+// Lookup returns the locale for tag like Get, or English if the catalog has
+// neither.
+func (c *Catalog) Lookup(tag string) *Locale {
+	if l, ok := c.Get(tag); ok {
+		return l
+	}
+	return c.locales[Fallback]
+}
+
+// Tags returns the tag of every locale, sorted.
+func (c *Catalog) Tags() []string {
+	tags := make([]string, 0, len(c.locales))
+	for tag := range c.locales {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
@@ -0,0 +1,91 @@
+package measure
+
+import (
+	"math"
+	"strconv"
+	"strings"
+)
+
+// NumberFormat holds a locale's separators.
+type NumberFormat struct {
+	Decimal string
+	Group   string
+}
+
+// numberFormats are keyed by language, or language-region for regional variants.
+var numberFormats = map[string]NumberFormat{
+	"en":    {Decimal: ".", Group: ","},
+	"de":    {Decimal: ",", Group: "."},
+	"de-ch": {Decimal: ".", Group: "'"},
+	"fr":    {Decimal: ",", Group: " "},
+	"es":    {Decimal: ",", Group: "."},
+	"it":    {Decimal: ",", Group: "."},
+	"nl":    {Decimal: ",", Group: "."},
+	"pt":    {Decimal: ",", Group: "."},
+	"pl":    {Decimal: ",", Group: " "},
+	"cs":    {Decimal: ",", Group: " "},
+	"sv":    {Decimal: ",", Group: " "},
+	"da":    {Decimal: ",", Group: "."},
+	"ja":    {Decimal: ".", Group: ","},
+	"zh":    {Decimal: ".", Group: ","},
+}
+
+// NumberFormatFor returns the separators for a locale such as "de" or
+// "de-CH", falling back to the language and then to English.
+func NumberFormatFor(locale string) NumberFormat {
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+	if f, ok := numberFormats[locale]; ok {
+		return f
+	}
+	lang, _, _ := strings.Cut(locale, "-")
+	if f, ok := numberFormats[lang]; ok {
+		return f
+	}
+	return numberFormats["en"]
+}
+
+// Number formats v with at most decimals digits after the separator,
+// dropping trailing zeros, and groups thousands.
+func (f NumberFormat) Number(v float64, decimals int) string {
+	s := strconv.FormatFloat(math.Abs(v), 'f', decimals, 64)
+	whole, frac, _ := strings.Cut(s, ".")
+	frac = strings.TrimRight(frac, "0")
+
+	var b strings.Builder
+	if v < 0 && (strings.Trim(whole, "0") != "" || frac != "") {
+		b.WriteByte('-')
+	}
+	for i, r := range whole {
+		if i > 0 && (len(whole)-i)%3 == 0 {
+			b.WriteString(f.Group)
+		}
+		b.WriteRune(r)
+	}
+	if frac != "" {
+		b.WriteString(f.Decimal)
+		b.WriteString(frac)
+	}
+	return b.String()
+}
+
+// Display chooses the units and number format values are shown in.
+type Display struct {
+	WeightUnit WeightUnit
+	LengthUnit LengthUnit
+	Number     NumberFormat
+}
+
+// Weight formats w, e.g. "12,5 kg".
+func (d Display) Weight(w Weight) string {
+	return d.Number.Number(w.In(d.WeightUnit), 2) + " " + string(d.WeightUnit)
+}
+
+// Dimensions formats dims, e.g. "30 x 20 x 15 cm".
+func (d Display) Dimensions(dims Dimensions) string {
+	values := dims.In(d.LengthUnit)
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = d.Number.Number(v, 1)
+	}
+	return strings.Join(parts, " x ") + " " + string(d.LengthUnit)
+}
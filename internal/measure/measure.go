@@ -0,0 +1,107 @@
+// Package measure parses, validates and formats shipment weights and dimensions.
+// Values are kept in canonical units, kilograms and centimetres, and converted
+// to the requested unit only when formatted.
+package measure
+
+import (
+	"fmt"
+	"strings"
+)
+
+// WeightUnit is a unit weights can be written and displayed in.
+type WeightUnit string
+
+// Weight units.
+const (
+	Kilogram WeightUnit = "kg"
+	Gram     WeightUnit = "g"
+	Pound    WeightUnit = "lb"
+	Ounce    WeightUnit = "oz"
+)
+
+// kilograms per unit.
+var weightUnits = map[WeightUnit]float64{
+	Kilogram: 1,
+	Gram:     0.001,
+	Pound:    0.45359237,
+	Ounce:    0.028349523125,
+}
+
+// weightAliases maps accepted spellings to units.
+var weightAliases = map[string]WeightUnit{
+	"kg": Kilogram, "kgs": Kilogram, "kilogram": Kilogram, "kilograms": Kilogram,
+	"g": Gram, "gram": Gram, "grams": Gram,
+	"lb": Pound, "lbs": Pound, "pound": Pound, "pounds": Pound,
+	"oz": Ounce, "ounce": Ounce, "ounces": Ounce,
+}
+
+// LengthUnit is a unit dimensions can be written and displayed in.
+type LengthUnit string
+
+// Length units.
+const (
+	Millimetre LengthUnit = "mm"
+	Centimetre LengthUnit = "cm"
+	Metre      LengthUnit = "m"
+	Inch       LengthUnit = "in"
+)
+
+// centimetres per unit.
+var lengthUnits = map[LengthUnit]float64{
+	Millimetre: 0.1,
+	Centimetre: 1,
+	Metre:      100,
+	Inch:       2.54,
+}
+
+var lengthAliases = map[string]LengthUnit{
+	"mm": Millimetre,
+	"cm": Centimetre,
+	"m":  Metre,
+	"in": Inch, "inch": Inch, "inches": Inch, `"`: Inch,
+}
+
+// Limits of accepted values, in canonical units.
+const (
+	MaxWeight    = 100_000 // kg
+	MaxDimension = 10_000  // cm
+)
+
+// ParseWeightUnit returns the unit for a name like "kg" or "lbs".
+func ParseWeightUnit(s string) (WeightUnit, error) {
+	if u, ok := weightAliases[strings.ToLower(strings.TrimSpace(s))]; ok {
+		return u, nil
+	}
+	return "", fmt.Errorf("unknown weight unit %q (want kg, g, lb or oz)", s)
+}
+
+// ParseLengthUnit returns the unit for a name like "cm" or "in".
+func ParseLengthUnit(s string) (LengthUnit, error) {
+	if u, ok := lengthAliases[strings.ToLower(strings.TrimSpace(s))]; ok {
+		return u, nil
+	}
+	return "", fmt.Errorf("unknown length unit %q (want mm, cm, m or in)", s)
+}
+
+// Weight is a weight in kilograms.
+type Weight float64
+
+// In returns w converted to unit.
+func (w Weight) In(unit WeightUnit) float64 {
+	return float64(w) / weightUnits[unit]
+}
+
+// Dimensions are a box's length, width and height in centimetres, in the
+// order they were given.
+type Dimensions [3]float64
+
+// IsZero reports whether d was never set.
+func (d Dimensions) IsZero() bool {
+	return d == Dimensions{}
+}
+
+// In returns d converted to unit.
+func (d Dimensions) In(unit LengthUnit) [3]float64 {
+	f := lengthUnits[unit]
+	return [3]float64{d[0] / f, d[1] / f, d[2] / f}
+}
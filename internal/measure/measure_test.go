@@ -0,0 +1,151 @@
+package measure
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestParseWeight(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Weight
+	}{
+		{in: "12.5", want: 12.5},
+		{in: "12.5kg", want: 12.5},
+		{in: "500 g", want: 0.5},
+		{in: "2 LBS", want: 0.90718474},
+		{in: "16oz", want: 0.45359237},
+		{in: "2,5 kg", want: 2.5},
+		{in: " 3 kilograms ", want: 3},
+	}
+	for _, tt := range tests {
+		got, err := ParseWeight(tt.in, Kilogram)
+		if err != nil {
+			t.Errorf("ParseWeight(%q) = %v", tt.in, err)
+			continue
+		}
+		if math.Abs(float64(got-tt.want)) > 1e-9 {
+			t.Errorf("ParseWeight(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+
+	if got, err := ParseWeight("8", Pound); err != nil || math.Abs(got.In(Pound)-8) > 1e-9 {
+		t.Errorf("ParseWeight(8) in pounds = %v, %v; want 8 lb", got, err)
+	}
+
+	for _, in := range []string{"", "kg", "0", "-1kg", "1,234", "12 stone", "100001kg", "1.2.3"} {
+		_, err := ParseWeight(in, Kilogram)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || parseErr.Field != "weight" {
+			t.Errorf("ParseWeight(%q) = %v, want a weight ParseError", in, err)
+		}
+	}
+}
+
+func TestParseDimensions(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Dimensions
+	}{
+		{in: "30x20x15", want: Dimensions{30, 20, 15}},
+		{in: "30x20x15cm", want: Dimensions{30, 20, 15}},
+		{in: "12 x 8 x 6 in", want: Dimensions{30.48, 20.32, 15.24}},
+		{in: "1m × 50cm × 200mm", want: Dimensions{100, 50, 20}},
+		{in: "300x200x150mm", want: Dimensions{30, 20, 15}},
+		{in: "30*20*15", want: Dimensions{30, 20, 15}},
+		{in: `10cm x 4 x 2"`, want: Dimensions{10, 10.16, 5.08}},
+	}
+	for _, tt := range tests {
+		got, err := ParseDimensions(tt.in, Centimetre)
+		if err != nil {
+			t.Errorf("ParseDimensions(%q) = %v", tt.in, err)
+			continue
+		}
+		for i := range got {
+			if math.Abs(got[i]-tt.want[i]) > 1e-9 {
+				t.Errorf("ParseDimensions(%q) = %v, want %v", tt.in, got, tt.want)
+				break
+			}
+		}
+	}
+
+	for _, in := range []string{"", "30x20", "30x20x", "30x20x15x10", "30x0x15", "30x20x15ft", "20000x1x1"} {
+		_, err := ParseDimensions(in, Centimetre)
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || parseErr.Field != "dimensions" {
+			t.Errorf("ParseDimensions(%q) = %v, want a dimensions ParseError", in, err)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	var v struct {
+		Weight     Weight     `json:"weight"`
+		Dimensions Dimensions `json:"dimensions"`
+	}
+	if err := json.Unmarshal([]byte(`{"weight": "3 lb", "dimensions": "12x8x6in"}`), &v); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"weight":1.360777,"dimensions":"30.48x20.32x15.24cm"}`; string(data) != want {
+		t.Errorf("Marshal = %s, want %s", data, want)
+	}
+
+	if err := json.Unmarshal([]byte(`{"weight": 2.5}`), &v); err != nil || v.Weight != 2.5 {
+		t.Errorf("a bare number: weight %v, %v; want 2.5 kg", v.Weight, err)
+	}
+	if err := json.Unmarshal([]byte(`{"dimensions": 30}`), &v); err == nil {
+		t.Error("numeric dimensions: want an error")
+	}
+}
+
+func TestDisplay(t *testing.T) {
+	tests := []struct {
+		name    string
+		display Display
+		weight  string
+		dims    string
+	}{
+		{
+			name:    "metric English",
+			display: Display{WeightUnit: Kilogram, LengthUnit: Centimetre, Number: NumberFormatFor("en-US")},
+			weight:  "1,234.5 kg",
+			dims:    "30 x 20.5 x 15 cm",
+		},
+		{
+			name:    "metric German",
+			display: Display{WeightUnit: Kilogram, LengthUnit: Centimetre, Number: NumberFormatFor("de_DE")},
+			weight:  "1.234,5 kg",
+			dims:    "30 x 20,5 x 15 cm",
+		},
+		{
+			name:    "Swiss German",
+			display: Display{WeightUnit: Kilogram, LengthUnit: Millimetre, Number: NumberFormatFor("de-CH")},
+			weight:  "1'234.5 kg",
+			dims:    "300 x 205 x 150 mm",
+		},
+		{
+			name:    "imperial",
+			display: Display{WeightUnit: Pound, LengthUnit: Inch, Number: NumberFormatFor("xx")},
+			weight:  "2,721.61 lb",
+			dims:    "11.8 x 8.1 x 5.9 in",
+		},
+	}
+	weight := Weight(1234.5)
+	dims := Dimensions{30, 20.5, 15}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.display.Weight(weight); got != tt.weight {
+				t.Errorf("Weight() = %q, want %q", got, tt.weight)
+			}
+			if got := tt.display.Dimensions(dims); got != tt.dims {
+				t.Errorf("Dimensions() = %q, want %q", got, tt.dims)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package measure
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// ParseError describes why a weight or dimensions value was rejected.
+type ParseError struct {
+	Field  string // "weight" or "dimensions"
+	Input  string
+	Reason string
+}
+
+func (e *ParseError) Error() string {
+	if e.Input == "" {
+		return fmt.Sprintf("missing %s", e.Field)
+	}
+	return fmt.Sprintf("invalid %s %q: %s", e.Field, e.Input, e.Reason)
+}
+
+// ParseWeight parses a weight like "12.5", "12.5kg", "500 g" or "3 lbs".
+// Numbers without a unit are in defaultUnit. A comma is accepted as the
+// decimal separator unless exactly three digits follow it.
+func ParseWeight(s string, defaultUnit WeightUnit) (Weight, error) {
+	fail := func(format string, args ...interface{}) (Weight, error) {
+		return 0, &ParseError{Field: "weight", Input: s, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	value, unitName, err := splitQuantity(s)
+	if err != nil {
+		return fail("%v", err)
+	}
+	unit := defaultUnit
+	if unitName != "" {
+		if unit, err = ParseWeightUnit(unitName); err != nil {
+			return fail("%v", err)
+		}
+	}
+
+	w := Weight(value * weightUnits[unit])
+	switch {
+	case w <= 0:
+		return fail("must be greater than 0")
+	case w > MaxWeight:
+		return fail("must be at most %d kg", MaxWeight)
+	}
+	return w, nil
+}
+
+// ParseDimensions parses three lengths separated by "x", like "30x20x15cm",
+// "12 x 8 x 6 in" or "30cm x 20cm x 15cm". A unit after the last length
+// applies to the lengths without one; lengths without any unit are in
+// defaultUnit.
+func ParseDimensions(s string, defaultUnit LengthUnit) (Dimensions, error) {
+	fail := func(format string, args ...interface{}) (Dimensions, error) {
+		return Dimensions{}, &ParseError{Field: "dimensions", Input: s, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	if strings.TrimSpace(s) == "" {
+		return fail("")
+	}
+	parts := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return r == 'x' || r == '×' || r == '*'
+	})
+	if len(parts) != 3 {
+		return fail("want length x width x height, got %d values", len(parts))
+	}
+
+	var values [3]float64
+	var units [3]string
+	for i, part := range parts {
+		var err error
+		if values[i], units[i], err = splitQuantity(part); err != nil {
+			return fail("value %d: %v", i+1, err)
+		}
+	}
+
+	shared := defaultUnit
+	if units[2] != "" {
+		u, err := ParseLengthUnit(units[2])
+		if err != nil {
+			return fail("value 3: %v", err)
+		}
+		shared = u
+	}
+
+	var d Dimensions
+	for i := range values {
+		unit := shared
+		if units[i] != "" {
+			u, err := ParseLengthUnit(units[i])
+			if err != nil {
+				return fail("value %d: %v", i+1, err)
+			}
+			unit = u
+		}
+		d[i] = values[i] * lengthUnits[unit]
+		switch {
+		case d[i] <= 0:
+			return fail("value %d must be greater than 0", i+1)
+		case d[i] > MaxDimension:
+			return fail("value %d must be at most %d cm", i+1, MaxDimension)
+		}
+	}
+	return d, nil
+}
+
+// splitQuantity splits "12.5 kg" into its number and unit.
+func splitQuantity(s string) (float64, string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, "", fmt.Errorf("empty value")
+	}
+	end := strings.IndexFunc(s, func(r rune) bool {
+		return !unicode.IsDigit(r) && r != '.' && r != ',' && r != '-' && r != '+'
+	})
+	if end == -1 {
+		end = len(s)
+	}
+	number, unit := s[:end], strings.TrimSpace(s[end:])
+	if number == "" {
+		return 0, "", fmt.Errorf("%q doesn't start with a number", s)
+	}
+	if whole, frac, ok := strings.Cut(number, ","); ok && !strings.Contains(number, ".") {
+		// "1,234" could be a thousands separator or a decimal comma.
+		if len(frac) == 3 {
+			return 0, "", fmt.Errorf("%q is ambiguous, use . as the decimal separator", number)
+		}
+		number = whole + "." + frac
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, "", fmt.Errorf("%q is not a number", s[:end])
+	}
+	return value, unit, nil
+}
+
+// UnmarshalJSON accepts a number of kilograms or a string with a unit.
+func (w *Weight) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		s = string(data)
+	}
+	parsed, err := ParseWeight(s, Kilogram)
+	if err != nil {
+		return err
+	}
+	*w = parsed
+	return nil
+}
+
+// MarshalJSON writes the weight as a number of kilograms.
+func (w Weight) MarshalJSON() ([]byte, error) {
+	return []byte(canonical(float64(w))), nil
+}
+
+// String returns the weight in kilograms, e.g. "12.5kg".
+func (w Weight) String() string {
+	return canonical(float64(w)) + string(Kilogram)
+}
+
+// UnmarshalJSON accepts a string such as "30x20x15cm".
+func (d *Dimensions) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return &ParseError{Field: "dimensions", Input: string(data), Reason: "must be a string"}
+	}
+	parsed, err := ParseDimensions(s, Centimetre)
+	if err != nil {
+		return err
+	}
+	*d = parsed
+	return nil
+}
+
+// MarshalJSON writes the dimensions in centimetres, in a form UnmarshalJSON reads back.
+func (d Dimensions) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// String returns the dimensions in centimetres, e.g. "30x20x15cm".
+func (d Dimensions) String() string {
+	parts := make([]string, len(d))
+	for i, v := range d {
+		parts[i] = canonical(v)
+	}
+	return strings.Join(parts, "x") + string(Centimetre)
+}
+
+// canonical formats a value in a canonical unit, rounded to a millionth so
+// conversions don't leave float noise behind.
+func canonical(v float64) string {
+	return strconv.FormatFloat(math.Round(v*1e6)/1e6, 'f', -1, 64)
+}
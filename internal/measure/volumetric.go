@@ -0,0 +1,67 @@
+package measure
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Divisor converts a box's volume into its volumetric weight. Carriers quote
+// it either in cubic centimetres per kilogram (5000, 6000) or in cubic inches
+// per pound (139, 166).
+type Divisor struct {
+	Value float64
+	// Unit is Centimetre for cm³/kg or Inch for in³/lb.
+	Unit LengthUnit
+}
+
+// DefaultDivisor is the common international air freight divisor.
+var DefaultDivisor = Divisor{Value: 5000, Unit: Centimetre}
+
+// ParseDivisor parses a divisor like "5000", "6000cm" or "139in". Numbers
+// without a unit are in cm³/kg.
+func ParseDivisor(s string) (Divisor, error) {
+	fail := func(format string, args ...interface{}) (Divisor, error) {
+		return Divisor{}, &ParseError{Field: "volumetric_divisor", Input: s, Reason: fmt.Sprintf(format, args...)}
+	}
+
+	value, unitName, err := splitQuantity(s)
+	if err != nil {
+		return fail("%v", err)
+	}
+	unit := Centimetre
+	if unitName != "" {
+		if unit, err = ParseLengthUnit(unitName); err != nil {
+			return fail("%v", err)
+		}
+	}
+	if unit != Centimetre && unit != Inch {
+		return fail("unit must be cm or in")
+	}
+	if value <= 0 {
+		return fail("must be greater than 0")
+	}
+	return Divisor{Value: value, Unit: unit}, nil
+}
+
+// String returns the divisor in a form ParseDivisor reads back, e.g. "139in".
+func (d Divisor) String() string {
+	return strconv.FormatFloat(d.Value, 'f', -1, 64) + string(d.Unit)
+}
+
+// Volumetric returns the volumetric weight of a box of dims.
+func (dims Dimensions) Volumetric(div Divisor) Weight {
+	if dims.IsZero() || div.Value <= 0 {
+		return 0
+	}
+	if div.Unit == Inch {
+		in := dims.In(Inch)
+		return Weight(in[0] * in[1] * in[2] / div.Value * weightUnits[Pound])
+	}
+	return Weight(dims[0] * dims[1] * dims[2] / div.Value)
+}
+
+// Chargeable returns the weight a carrier bills for: the greater of the
+// actual and volumetric weight.
+func Chargeable(actual Weight, dims Dimensions, div Divisor) Weight {
+	return max(actual, dims.Volumetric(div))
+}
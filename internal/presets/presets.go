@@ -0,0 +1,377 @@
+package presets
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	"gopkg.in/yaml.v3"
+
+	"watermark/internal/locale"
+	"watermark/internal/measure"
+	"watermark/internal/processor"
+)
+
+// DefaultName is the preset used when a request doesn't choose one.
+const DefaultName = "default"
+
+// DefaultText is the overlay text of presets that don't set their own.
+const DefaultText = "{{.label_weight}}: {{.weight}} | {{.label_dimensions}}: {{.dimensions}}"
+
+// ErrUnknown is returned for preset names that aren't defined.
+var ErrUnknown = errors.New("unknown preset")
+
+// Definition is a preset as written in the presets file. Empty fields inherit
+// from the default preset.
+type Definition struct {
+	Font      string   `yaml:"font" json:"font,omitempty"` // Path to a .ttf file
+	FontSize  float64  `yaml:"font_size" json:"font_size,omitempty"`
+	Color     string   `yaml:"color" json:"color,omitempty"`
+	Placement string   `yaml:"placement" json:"placement,omitempty"` // Anchor, e.g. bottom-right
+	Margin    *int     `yaml:"margin" json:"margin,omitempty"`
+	Opacity   *float64 `yaml:"opacity" json:"opacity,omitempty"`
+	Format    string   `yaml:"format" json:"format,omitempty"` // jpeg or png
+	Quality   int      `yaml:"quality" json:"quality,omitempty"`
+	// Units and locale the weight and dimensions are shown in.
+	WeightUnit    string `yaml:"weight_unit" json:"weight_unit,omitempty"`
+	DimensionUnit string `yaml:"dimension_unit" json:"dimension_unit,omitempty"`
+	Locale        string `yaml:"locale" json:"locale,omitempty"`
+	// Text is a Go template over the render's variables, see Preset.Vars and
+	// locale.Locale.Vars.
+	Text string `yaml:"text" json:"text,omitempty"`
+	// VolumetricDivisor turns dimensions into volumetric weight, e.g. 5000
+	// (cm³/kg) or 139in (in³/lb).
+	VolumetricDivisor string `yaml:"volumetric_divisor" json:"volumetric_divisor,omitempty"`
+	// Fields name the stored metadata fields Text may use, such as "sku".
+	// Images without one get an empty value.
+	Fields []string `yaml:"fields" json:"fields,omitempty"`
+}
+
+// merge returns d with its empty fields taken from base.
+func (d Definition) merge(base Definition) Definition {
+	if d.Font == "" {
+		d.Font = base.Font
+	}
+	if d.FontSize == 0 {
+		d.FontSize = base.FontSize
+	}
+	if d.Color == "" {
+		d.Color = base.Color
+	}
+	if d.Placement == "" {
+		d.Placement = base.Placement
+	}
+	if d.Margin == nil {
+		d.Margin = base.Margin
+	}
+	if d.Opacity == nil {
+		d.Opacity = base.Opacity
+	}
+	if d.Format == "" {
+		d.Format = base.Format
+	}
+	if d.Quality == 0 {
+		d.Quality = base.Quality
+	}
+	if d.WeightUnit == "" {
+		d.WeightUnit = base.WeightUnit
+	}
+	if d.DimensionUnit == "" {
+		d.DimensionUnit = base.DimensionUnit
+	}
+	if d.Locale == "" {
+		d.Locale = base.Locale
+	}
+	if d.Text == "" {
+		d.Text = base.Text
+	}
+	if d.VolumetricDivisor == "" {
+		d.VolumetricDivisor = base.VolumetricDivisor
+	}
+	if d.Fields == nil {
+		d.Fields = base.Fields
+	}
+	return d
+}
+
+// file is the layout of the presets file.
+type file struct {
+	Presets map[string]Definition `yaml:"presets" json:"presets"`
+}
+
+// Preset is a validated, ready-to-use preset.
+type Preset struct {
+	Name    string
+	Style   processor.TextStyle
+	Display measure.Display
+	// Locale is the default for requests that don't ask for one.
+	Locale  string
+	Text    *template.Template
+	Divisor measure.Divisor
+	// Fields are the metadata fields Text may use, lowercased.
+	Fields  []string
+	catalog *locale.Catalog
+	// Hash identifies the preset's resolved settings, including the font file's
+	// contents, so editing a preset changes the cache keys of its renders.
+	Hash string
+}
+
+// Vars returns the template variables for a shipment, formatted with d:
+// weight, dimensions, and, when both are known, volumetric_weight and
+// chargeable_weight. Zero values are left out.
+func (p *Preset) Vars(d measure.Display, weight measure.Weight, dims measure.Dimensions) map[string]string {
+	vars := make(map[string]string, 4)
+	if weight > 0 {
+		vars["weight"] = d.Weight(weight)
+	}
+	if !dims.IsZero() {
+		vars["dimensions"] = d.Dimensions(dims)
+	}
+	if weight > 0 && !dims.IsZero() {
+		vars["volumetric_weight"] = d.Weight(dims.Volumetric(p.Divisor))
+		vars["chargeable_weight"] = d.Weight(measure.Chargeable(weight, dims, p.Divisor))
+	}
+	return vars
+}
+
+// Localize returns the locale for tag, or the preset's own for an empty tag.
+func (p *Preset) Localize(tag string) *locale.Locale {
+	if tag == "" {
+		tag = p.Locale
+	}
+	return p.catalog.Lookup(tag)
+}
+
+// Render executes the preset's text template over vars.
+func (p *Preset) Render(vars map[string]string) (string, error) {
+	var buf strings.Builder
+	if err := p.Text.Execute(&buf, vars); err != nil {
+		return "", fmt.Errorf("preset %q: %w", p.Name, err)
+	}
+	return buf.String(), nil
+}
+
+// ContentType returns the MIME type of the preset's output.
+func (p *Preset) ContentType() string {
+	return processor.ContentType(p.Style.Format)
+}
+
+// Extension returns the file extension of the preset's output, with the dot.
+func (p *Preset) Extension() string {
+	if p.Style.Format == processor.FormatPNG {
+		return ".png"
+	}
+	return ".jpg"
+}
+
+// Registry holds every configured preset.
+type Registry struct {
+	presets map[string]*Preset
+}
+
+// Load builds the registry from defaults and the presets file at path, which
+// may be YAML or, with a .json extension, JSON. An empty path only defines the
+// default preset. A "default" entry in the file is merged over defaults.
+// Every preset is validated, and its font loaded, up front. Labels and
+// formatting for each preset's locale come from catalog.
+func Load(path string, defaults Definition, catalog *locale.Catalog) (*Registry, error) {
+	defs := map[string]Definition{}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read presets file: %w", err)
+		}
+		if defs, err = parse(data, strings.EqualFold(filepath.Ext(path), ".json")); err != nil {
+			return nil, fmt.Errorf("invalid presets file %s: %w", path, err)
+		}
+	}
+	defaults = defs[DefaultName].merge(defaults)
+	defs[DefaultName] = defaults
+
+	r := &Registry{presets: make(map[string]*Preset, len(defs))}
+	fonts := map[string]*font{}
+	for name, def := range defs {
+		preset, err := build(name, def.merge(defaults), fonts, catalog)
+		if err != nil {
+			return nil, fmt.Errorf("preset %q: %w", name, err)
+		}
+		r.presets[name] = preset
+	}
+	return r, nil
+}
+
+func parse(data []byte, isJSON bool) (map[string]Definition, error) {
+	var f file
+	if isJSON {
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&f); err != nil {
+			return nil, err
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(&f); err != nil {
+			return nil, err
+		}
+	}
+	if f.Presets == nil {
+		return map[string]Definition{}, nil
+	}
+	return f.Presets, nil
+}
+
+// font is a parsed font file and the hash of its contents.
+type font struct {
+	font *truetype.Font
+	hash []byte
+}
+
+func build(name string, def Definition, fonts map[string]*font, catalog *locale.Catalog) (*Preset, error) {
+	f, ok := fonts[def.Font]
+	if !ok {
+		data, err := os.ReadFile(def.Font)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read font: %w", err)
+		}
+		parsed, err := freetype.ParseFont(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse font %s: %w", def.Font, err)
+		}
+		hash := sha256.Sum256(data)
+		f = &font{font: parsed, hash: hash[:]}
+		fonts[def.Font] = f
+	}
+
+	c, err := processor.ParseHexColor(def.Color)
+	if err != nil {
+		return nil, err
+	}
+	margin, opacity := 0, 1.0
+	if def.Margin != nil {
+		margin = *def.Margin
+	}
+	if def.Opacity != nil {
+		opacity = *def.Opacity
+	}
+	style := processor.TextStyle{
+		Font:     f.font,
+		FontSize: def.FontSize,
+		Color:    c,
+		Anchor:   def.Placement,
+		Margin:   margin,
+		Opacity:  opacity,
+		Format:   strings.ToLower(def.Format),
+		Quality:  def.Quality,
+	}
+	if style.Format == "jpg" {
+		style.Format = processor.FormatJPEG
+	}
+	if err := style.Validate(); err != nil {
+		return nil, err
+	}
+
+	weightUnit, err := measure.ParseWeightUnit(def.WeightUnit)
+	if err != nil {
+		return nil, err
+	}
+	lengthUnit, err := measure.ParseLengthUnit(def.DimensionUnit)
+	if err != nil {
+		return nil, err
+	}
+	loc, ok := catalog.Get(def.Locale)
+	if !ok {
+		return nil, fmt.Errorf("unknown locale %q", def.Locale)
+	}
+	display := measure.Display{
+		WeightUnit: weightUnit,
+		LengthUnit: lengthUnit,
+		Number:     loc.Number,
+	}
+	divisor, err := measure.ParseDivisor(def.VolumetricDivisor)
+	if err != nil {
+		return nil, err
+	}
+	fields := make([]string, len(def.Fields))
+	for i, field := range def.Fields {
+		fields[i] = strings.ToLower(strings.TrimSpace(field))
+		if fields[i] == "" {
+			return nil, errors.New("fields must not be empty")
+		}
+	}
+	text, err := template.New(name).Option("missingkey=error").Parse(def.Text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid text: %w", err)
+	}
+	preset := &Preset{
+		Name:    name,
+		Style:   style,
+		Display: display,
+		Locale:  def.Locale,
+		Text:    text,
+		Divisor: divisor,
+		Fields:  fields,
+		catalog: catalog,
+	}
+	// Catch unknown variables now rather than on the first render. Every
+	// locale has the English labels, so checking one locale covers them all.
+	vars := preset.Vars(display, 1, measure.Dimensions{1, 1, 1})
+	for _, field := range fields {
+		vars[field] = ""
+	}
+	for k, v := range loc.Vars(time.Now()) {
+		vars[k] = v
+	}
+	if err := text.Execute(io.Discard, vars); err != nil {
+		return nil, fmt.Errorf("invalid text: %w", err)
+	}
+
+	// The font is identified by its contents, so the path can move freely.
+	def.Font = hex.EncodeToString(f.hash)
+	def.Format = style.Format
+	def.Margin, def.Opacity = &margin, &opacity
+	def.WeightUnit, def.DimensionUnit = string(weightUnit), string(lengthUnit)
+	def.VolumetricDivisor = divisor.String()
+	def.Fields = fields
+	encoded, err := json.Marshal(def)
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256(encoded)
+	preset.Hash = hex.EncodeToString(hash[:8])
+	return preset, nil
+}
+
+// Get returns the named preset, or the default preset for an empty name.
+func (r *Registry) Get(name string) (*Preset, error) {
+	if name == "" {
+		name = DefaultName
+	}
+	p, ok := r.presets[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknown, name)
+	}
+	return p, nil
+}
+
+// Names returns the names of every preset, sorted.
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.presets))
+	for name := range r.presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,306 @@
+package processor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// stampBorderWidth is the border drawn around stamp layers that don't set one.
+const stampBorderWidth = 4
+
+// Compose draws spec's layers over the image in order. vars feed the text
+// templates of text and stamp layers, and overlays holds the encoded image
+// for each image layer, keyed by its storage key.
+func (p *WatermarkProcessor) Compose(ctx context.Context, imageBytes []byte, spec RenderSpec, vars map[string]string, overlays map[string][]byte) ([]byte, error) {
+	if err := spec.Validate(); err != nil {
+		return nil, err
+	}
+
+	base, err := p.decodeRGBA(ctx, imageBytes)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, l := range spec.Layers {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("compose interrupted: %w", err)
+		}
+
+		layer, err := p.drawLayer(ctx, base.Bounds(), l, vars, overlays)
+		if err != nil {
+			return nil, fmt.Errorf("layer %d: %w", i, err)
+		}
+
+		opacity := 1.0
+		if l.Opacity != nil {
+			opacity = *l.Opacity
+		}
+		r := place(base.Bounds(), layer.Bounds().Size(), l.Placement)
+		mask := image.NewUniform(color.Alpha{A: uint8(math.Round(opacity * 255))})
+		draw.DrawMask(base, r, layer, layer.Bounds().Min, mask, image.Point{}, draw.Over)
+	}
+
+	quality := p.imageQuality
+	if spec.Quality > 0 {
+		quality = spec.Quality
+	}
+	return encode(ctx, base, FormatJPEG, quality)
+}
+
+// drawLayer renders l onto its own transparent canvas, sized to its content.
+func (p *WatermarkProcessor) drawLayer(ctx context.Context, bounds image.Rectangle, l Layer, vars map[string]string, overlays map[string][]byte) (*image.RGBA, error) {
+	switch l.Type {
+	case LayerText, LayerStamp:
+		text, err := renderText(l.Text, vars)
+		if err != nil {
+			return nil, err
+		}
+		size, fg := p.fontSize, p.fontColor
+		if l.Style.FontSize != 0 {
+			size = l.Style.FontSize
+		}
+		if l.Style.Color != "" {
+			fg, _ = ParseHexColor(l.Style.Color)
+		}
+		if err := checkInsets(bounds, l.Style); err != nil {
+			return nil, err
+		}
+		return drawText(bounds, p.font, text, l.Type == LayerStamp, size, fg, l.Style)
+	case LayerImage:
+		data, ok := overlays[l.Image]
+		if !ok {
+			return nil, fmt.Errorf("%w: overlay %q was not loaded", ErrInvalidSpec, l.Image)
+		}
+		return p.drawOverlay(ctx, bounds, data, l.Placement)
+	case LayerShape:
+		if err := checkInsets(bounds, l.Style); err != nil {
+			return nil, err
+		}
+		return drawShape(bounds, l.Shape, l.Placement, l.Style)
+	}
+	return nil, fmt.Errorf("%w: unknown layer type %q", ErrInvalidSpec, l.Type)
+}
+
+// checkInsets rejects padding and borders wider than the source image.
+func checkInsets(bounds image.Rectangle, style Style) error {
+	limit := max(bounds.Dx(), bounds.Dy())
+	if style.Padding > limit || style.BorderWidth > limit {
+		return fmt.Errorf("%w: border width and padding must be at most the image size (%d pixels)", ErrInvalidSpec, limit)
+	}
+	return nil
+}
+
+// renderText executes a layer's text template over vars. Output past
+// maxTextLength bytes is an error, so templates such as printf with a huge
+// width can't make us build a huge string.
+func renderText(text string, vars map[string]string) (string, error) {
+	tmpl, err := parseTextTemplate(text)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidSpec, err)
+	}
+	buf := &limitedBuilder{limit: maxTextLength}
+	if err := tmpl.Execute(buf, vars); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidSpec, err)
+	}
+	return buf.String(), nil
+}
+
+// limitedBuilder is a strings.Builder that refuses to grow past limit bytes.
+type limitedBuilder struct {
+	strings.Builder
+	limit int
+}
+
+func (b *limitedBuilder) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, fmt.Errorf("text is longer than %d bytes", b.limit)
+	}
+	return b.Builder.Write(p)
+}
+
+// drawText draws text in size and fg on a padded, optionally filled box.
+// Stamps always get a border.
+func drawText(bounds image.Rectangle, f *truetype.Font, text string, stamp bool, size float64, fg color.Color, style Style) (*image.RGBA, error) {
+	border := style.BorderWidth
+	borderColor := fg
+	if style.BorderColor != "" {
+		borderColor, _ = ParseHexColor(style.BorderColor)
+	}
+	if stamp && border == 0 {
+		border = stampBorderWidth
+	}
+	padding := style.Padding
+	if stamp && padding == 0 {
+		padding = int(size / 2)
+	}
+
+	face := truetype.NewFace(f, &truetype.Options{Size: size})
+	defer face.Close()
+	metrics := face.Metrics()
+	textWidth := font.MeasureString(face, text).Ceil()
+	textHeight := (metrics.Ascent + metrics.Descent).Ceil()
+
+	inset := border + padding
+	canvas := newCanvas(bounds, textWidth+2*inset, textHeight+2*inset)
+	if style.Background != "" {
+		bg, _ := ParseHexColor(style.Background)
+		draw.Draw(canvas, canvas.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	}
+	if border > 0 {
+		strokeRect(canvas, canvas.Bounds(), border, borderColor)
+	}
+
+	c := freetype.NewContext()
+	c.SetDPI(72)
+	c.SetFont(f)
+	c.SetFontSize(size)
+	c.SetClip(canvas.Bounds())
+	c.SetDst(canvas)
+	c.SetSrc(image.NewUniform(fg))
+	c.SetHinting(font.HintingNone)
+
+	baseline := fixed.Point26_6{X: fixed.I(inset), Y: fixed.I(inset) + metrics.Ascent}
+	if _, err := c.DrawString(text, baseline); err != nil {
+		return nil, fmt.Errorf("failed to draw string: %w", err)
+	}
+	return canvas, nil
+}
+
+// drawOverlay decodes an overlay image and scales it to the layer's size.
+func (p *WatermarkProcessor) drawOverlay(ctx context.Context, bounds image.Rectangle, data []byte, pl Placement) (*image.RGBA, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: overlay: %v", ErrInvalidImage, err)
+	}
+	if p.maxPixels > 0 && cfg.Width*cfg.Height > p.maxPixels {
+		return nil, fmt.Errorf("%w: overlay %dx%d exceeds %d pixels", ErrTooLarge, cfg.Width, cfg.Height, p.maxPixels)
+	}
+	img, _, err := image.Decode(&contextReader{ctx: ctx, r: bytes.NewReader(data)})
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("decode interrupted: %w", ctx.Err())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: overlay: %v", ErrInvalidImage, err)
+	}
+
+	src := img.Bounds().Size()
+	w := int(math.Round(float64(bounds.Dx()) * pl.Width / 100))
+	h := int(math.Round(float64(bounds.Dy()) * pl.Height / 100))
+	switch {
+	case w == 0:
+		w = int(math.Round(float64(h) * float64(src.X) / float64(src.Y)))
+	case h == 0:
+		h = int(math.Round(float64(w) * float64(src.Y) / float64(src.X)))
+	}
+
+	// The canvas may be clamped to the image, cropping the scaled overlay.
+	canvas := newCanvas(bounds, w, h)
+	xdraw.CatmullRom.Scale(canvas, image.Rect(0, 0, max(w, 1), max(h, 1)), img, img.Bounds(), draw.Src, nil)
+	return canvas, nil
+}
+
+// drawShape draws a filled and optionally outlined rectangle or ellipse.
+func drawShape(bounds image.Rectangle, shape string, pl Placement, style Style) (*image.RGBA, error) {
+	canvas := newCanvas(bounds,
+		int(math.Round(float64(bounds.Dx())*pl.Width/100)),
+		int(math.Round(float64(bounds.Dy())*pl.Height/100)))
+	w, h := canvas.Bounds().Dx(), canvas.Bounds().Dy()
+
+	var fill, stroke color.Color
+	if style.Background != "" {
+		fill, _ = ParseHexColor(style.Background)
+	} else if style.Color != "" {
+		fill, _ = ParseHexColor(style.Color)
+	}
+	if style.BorderWidth > 0 {
+		stroke = color.Black
+		if style.BorderColor != "" {
+			stroke, _ = ParseHexColor(style.BorderColor)
+		}
+	}
+
+	if shape == ShapeRect {
+		if fill != nil {
+			draw.Draw(canvas, canvas.Bounds(), image.NewUniform(fill), image.Point{}, draw.Src)
+		}
+		if stroke != nil {
+			strokeRect(canvas, canvas.Bounds(), style.BorderWidth, stroke)
+		}
+		return canvas, nil
+	}
+
+	// Ellipse: test each pixel centre against the outer and inner ellipses.
+	rx, ry := float64(w)/2, float64(h)/2
+	bw := float64(style.BorderWidth)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dx, dy := float64(x)+0.5-rx, float64(y)+0.5-ry
+			if dx*dx/(rx*rx)+dy*dy/(ry*ry) > 1 {
+				continue
+			}
+			inner := rx > bw && ry > bw &&
+				dx*dx/((rx-bw)*(rx-bw))+dy*dy/((ry-bw)*(ry-bw)) <= 1
+			switch {
+			case !inner && stroke != nil:
+				canvas.Set(x, y, stroke)
+			case fill != nil:
+				canvas.Set(x, y, fill)
+			}
+		}
+	}
+	return canvas, nil
+}
+
+// newCanvas allocates a transparent w×h layer canvas, clamped to the size of
+// the source image: a larger layer couldn't be seen in full anyway, and a
+// spec mustn't be able to make us allocate more than the image itself.
+func newCanvas(bounds image.Rectangle, w, h int) *image.RGBA {
+	w = min(max(w, 1), max(bounds.Dx(), 1))
+	h = min(max(h, 1), max(bounds.Dy(), 1))
+	return image.NewRGBA(image.Rect(0, 0, w, h))
+}
+
+// strokeRect draws a border of the given width just inside r.
+func strokeRect(dst draw.Image, r image.Rectangle, width int, c color.Color) {
+	src := image.NewUniform(c)
+	for _, edge := range []image.Rectangle{
+		image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+width),
+		image.Rect(r.Min.X, r.Max.Y-width, r.Max.X, r.Max.Y),
+		image.Rect(r.Min.X, r.Min.Y, r.Min.X+width, r.Max.Y),
+		image.Rect(r.Max.X-width, r.Min.Y, r.Max.X, r.Max.Y),
+	} {
+		draw.Draw(dst, edge.Intersect(r), src, image.Point{}, draw.Src)
+	}
+}
+
+// place returns where a layer of the given size lands on bounds.
+func place(bounds image.Rectangle, size image.Point, pl Placement) image.Rectangle {
+	anchor := anchors["center"]
+	if pl.Anchor != "" {
+		anchor = anchors[pl.Anchor]
+	}
+	align := func(lo, hi, n, a int) int {
+		switch a {
+		case 0:
+			return lo + pl.Margin
+		case 2:
+			return hi - n - pl.Margin
+		}
+		return lo + (hi-lo-n)/2
+	}
+	x := align(bounds.Min.X, bounds.Max.X, size.X, anchor[0]) + pl.OffsetX
+	y := align(bounds.Min.Y, bounds.Max.Y, size.Y, anchor[1]) + pl.OffsetY
+	return image.Rectangle{Min: image.Pt(x, y), Max: image.Pt(x+size.X, y+size.Y)}
+}
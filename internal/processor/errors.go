@@ -0,0 +1,14 @@
+package processor
+
+import "errors"
+
+var (
+	// ErrInvalidImage is returned when the input bytes cannot be decoded as an image.
+	ErrInvalidImage = errors.New("invalid image data")
+	// ErrUnsupportedFormat is returned when the input is in an image format we cannot decode.
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	// ErrTooLarge is returned when the input image exceeds the configured pixel limit.
+	ErrTooLarge = errors.New("image too large")
+	// ErrInvalidSpec is returned when a render spec fails validation.
+	ErrInvalidSpec = errors.New("invalid render spec")
+)
@@ -0,0 +1,190 @@
+package processor
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+)
+
+// Layer types supported in a RenderSpec.
+const (
+	LayerText  = "text"
+	LayerImage = "image"
+	LayerStamp = "stamp"
+	LayerShape = "shape"
+)
+
+// Shapes drawn by shape layers.
+const (
+	ShapeRect    = "rect"
+	ShapeEllipse = "ellipse"
+)
+
+// Limits on the work a single spec can ask for.
+const (
+	maxLayers = 32
+	// maxFontSize is the largest font size, in points, a layer may set.
+	maxFontSize = 1000
+	// maxInset is the largest padding or border width, in pixels, a layer may
+	// set. Renders also reject insets larger than the source image.
+	maxInset = 4096
+	// maxTextLength is the most bytes of text a layer may render, after its
+	// template has run.
+	maxTextLength = 1024
+)
+
+// anchors maps each placement anchor to its horizontal and vertical alignment,
+// where 0 is left/top, 1 is center and 2 is right/bottom.
+var anchors = map[string][2]int{
+	"top-left":     {0, 0},
+	"top":          {1, 0},
+	"top-right":    {2, 0},
+	"left":         {0, 1},
+	"center":       {1, 1},
+	"right":        {2, 1},
+	"bottom-left":  {0, 2},
+	"bottom":       {1, 2},
+	"bottom-right": {2, 2},
+}
+
+// RenderSpec describes a composition: layers are drawn over the source image in order.
+type RenderSpec struct {
+	Layers []Layer `json:"layers"`
+	// Quality overrides the JPEG quality of the output.
+	Quality int `json:"quality,omitempty"`
+}
+
+// Layer is one element of a composition.
+type Layer struct {
+	Type      string    `json:"type"`
+	Placement Placement `json:"placement"`
+	Style     Style     `json:"style"`
+	// Opacity of the whole layer, from 0 to 1. Defaults to 1.
+	Opacity *float64 `json:"opacity,omitempty"`
+	// Text for text and stamp layers. It is a Go template over the render's
+	// variables, e.g. "Weight: {{.weight}}".
+	Text string `json:"text,omitempty"`
+	// Image is the storage key of the overlay for image layers.
+	Image string `json:"image,omitempty"`
+	// Shape is rect or ellipse for shape layers.
+	Shape string `json:"shape,omitempty"`
+}
+
+// Placement positions a layer relative to the source image.
+type Placement struct {
+	// Anchor is one of top-left, top, top-right, left, center, right,
+	// bottom-left, bottom or bottom-right. Defaults to center.
+	Anchor string `json:"anchor,omitempty"`
+	// Margin keeps the layer this many pixels away from the anchored edges.
+	Margin int `json:"margin,omitempty"`
+	// OffsetX and OffsetY shift the layer after anchoring.
+	OffsetX int `json:"offset_x,omitempty"`
+	OffsetY int `json:"offset_y,omitempty"`
+	// Width and Height size image and shape layers, in percent of the source
+	// image's width and height. Image layers keep their aspect ratio when
+	// only one is set.
+	Width  float64 `json:"width,omitempty"`
+	Height float64 `json:"height,omitempty"`
+}
+
+// Style controls how a layer is drawn. Colors use #RGB, #RRGGBB or #RRGGBBAA notation.
+type Style struct {
+	FontSize    float64 `json:"font_size,omitempty"`
+	Color       string  `json:"color,omitempty"`
+	Background  string  `json:"background,omitempty"`
+	BorderColor string  `json:"border_color,omitempty"`
+	BorderWidth int     `json:"border_width,omitempty"`
+	Padding     int     `json:"padding,omitempty"`
+}
+
+// Validate checks the spec without rendering it.
+func (s RenderSpec) Validate() error {
+	if len(s.Layers) == 0 {
+		return fmt.Errorf("%w: no layers", ErrInvalidSpec)
+	}
+	if len(s.Layers) > maxLayers {
+		return fmt.Errorf("%w: %d layers, the limit is %d", ErrInvalidSpec, len(s.Layers), maxLayers)
+	}
+	if s.Quality < 0 || s.Quality > 100 {
+		return fmt.Errorf("%w: quality must be between 1 and 100", ErrInvalidSpec)
+	}
+	for i, l := range s.Layers {
+		if err := l.validate(); err != nil {
+			return fmt.Errorf("%w: layer %d: %v", ErrInvalidSpec, i, err)
+		}
+	}
+	return nil
+}
+
+func (l Layer) validate() error {
+	switch l.Type {
+	case LayerText, LayerStamp:
+		if strings.TrimSpace(l.Text) == "" {
+			return fmt.Errorf("%s layer needs text", l.Type)
+		}
+		if _, err := parseTextTemplate(l.Text); err != nil {
+			return err
+		}
+	case LayerImage:
+		if l.Image == "" {
+			return fmt.Errorf("image layer needs an image key")
+		}
+		if l.Placement.Width <= 0 && l.Placement.Height <= 0 {
+			return fmt.Errorf("image layer needs a width or height")
+		}
+	case LayerShape:
+		if l.Shape != ShapeRect && l.Shape != ShapeEllipse {
+			return fmt.Errorf("unknown shape %q", l.Shape)
+		}
+		if l.Placement.Width <= 0 || l.Placement.Height <= 0 {
+			return fmt.Errorf("shape layer needs a width and height")
+		}
+	default:
+		return fmt.Errorf("unknown layer type %q", l.Type)
+	}
+
+	if l.Placement.Anchor != "" {
+		if _, ok := anchors[l.Placement.Anchor]; !ok {
+			return fmt.Errorf("unknown anchor %q", l.Placement.Anchor)
+		}
+	}
+	if l.Placement.Width < 0 || l.Placement.Width > 100 || l.Placement.Height < 0 || l.Placement.Height > 100 {
+		return fmt.Errorf("width and height must be between 0 and 100 percent")
+	}
+	if l.Opacity != nil && (*l.Opacity < 0 || *l.Opacity > 1) {
+		return fmt.Errorf("opacity must be between 0 and 1")
+	}
+	if l.Style.FontSize < 0 || l.Style.BorderWidth < 0 || l.Style.Padding < 0 {
+		return fmt.Errorf("font size, border width and padding can't be negative")
+	}
+	if l.Style.FontSize > maxFontSize {
+		return fmt.Errorf("font size must be at most %d", maxFontSize)
+	}
+	if l.Style.BorderWidth > maxInset || l.Style.Padding > maxInset {
+		return fmt.Errorf("border width and padding must be at most %d pixels", maxInset)
+	}
+	for _, c := range []string{l.Style.Color, l.Style.Background, l.Style.BorderColor} {
+		if c == "" {
+			continue
+		}
+		if _, err := ParseHexColor(c); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Overlays returns the storage keys of every image layer's overlay.
+func (s RenderSpec) Overlays() []string {
+	var keys []string
+	for _, l := range s.Layers {
+		if l.Type == LayerImage {
+			keys = append(keys, l.Image)
+		}
+	}
+	return keys
+}
+
+func parseTextTemplate(text string) (*template.Template, error) {
+	return template.New("layer").Option("missingkey=error").Parse(text)
+}
@@ -2,18 +2,72 @@ package processor
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
 	"image/jpeg"
+	"image/png"
+	"io"
+	"math"
 
 	"github.com/golang/freetype"
 	"github.com/golang/freetype/truetype"
-	"golang.org/x/image/font"
-	"golang.org/x/image/math/fixed"
 )
 
+// Output formats.
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+)
+
+// ContentType returns the MIME type of an output format.
+func ContentType(format string) string {
+	if format == FormatPNG {
+		return "image/png"
+	}
+	return "image/jpeg"
+}
+
+// TextStyle is the look of a text watermark.
+type TextStyle struct {
+	Font     *truetype.Font
+	FontSize float64
+	Color    color.Color
+	// Anchor and Margin place the text like a spec layer's Placement.
+	Anchor  string
+	Margin  int
+	Opacity float64
+	Format  string
+	Quality int
+}
+
+// Validate checks the style without rendering with it.
+func (s TextStyle) Validate() error {
+	switch {
+	case s.Font == nil:
+		return fmt.Errorf("no font")
+	case s.FontSize <= 0:
+		return fmt.Errorf("font size must be positive")
+	case s.Color == nil:
+		return fmt.Errorf("no color")
+	case s.Margin < 0:
+		return fmt.Errorf("margin can't be negative")
+	case s.Opacity < 0 || s.Opacity > 1:
+		return fmt.Errorf("opacity must be between 0 and 1")
+	case s.Format != FormatJPEG && s.Format != FormatPNG:
+		return fmt.Errorf("unknown format %q: must be jpeg or png", s.Format)
+	case s.Quality < 1 || s.Quality > 100:
+		return fmt.Errorf("quality must be between 1 and 100")
+	}
+	if _, ok := anchors[s.Anchor]; !ok {
+		return fmt.Errorf("unknown placement %q", s.Anchor)
+	}
+	return nil
+}
+
 // WatermarkProcessor handles the logic of adding a text watermark to an image.
 
 type WatermarkProcessor struct {
@@ -21,10 +75,13 @@ type WatermarkProcessor struct {
 	fontSize     float64
 	fontColor    color.Color
 	imageQuality int
+	maxPixels    int
 }
 
-// NewWatermarkProcessor initializes a processor with font and style settings.
-func NewWatermarkProcessor(fontBytes []byte, fontSize float64, fontColor color.Color, imageQuality int) (*WatermarkProcessor, error) {
+// NewWatermarkProcessor initializes a processor with font and style settings,
+// which are the defaults for render spec layers.
+// Images with more than maxPixels pixels are rejected before they are decoded.
+func NewWatermarkProcessor(fontBytes []byte, fontSize float64, fontColor color.Color, imageQuality, maxPixels int) (*WatermarkProcessor, error) {
 	font, err := freetype.ParseFont(fontBytes)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse font: %w", err)
@@ -35,55 +92,107 @@ func NewWatermarkProcessor(fontBytes []byte, fontSize float64, fontColor color.C
 		fontSize:     fontSize,
 		fontColor:    fontColor,
 		imageQuality: imageQuality,
+		maxPixels:    maxPixels,
 	}, nil
 }
 
-// AddWatermark takes an image byte slice and adds a text overlay.
-func (p *WatermarkProcessor) AddWatermark(imageBytes []byte, text string) ([]byte, error) {
-	img, _, err := image.Decode(bytes.NewReader(imageBytes))
+// rowsPerCheck is how many rows are copied between context checks.
+const rowsPerCheck = 64
+
+// AddWatermark takes an image byte slice and adds a text overlay in the given style.
+// It stops early, returning the context's error, once ctx is done.
+func (p *WatermarkProcessor) AddWatermark(ctx context.Context, imageBytes []byte, text string, style TextStyle) ([]byte, error) {
+	rgba, err := p.decodeRGBA(ctx, imageBytes)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decode image: %w", err)
+		return nil, err
 	}
 
-	rgba := image.NewRGBA(img.Bounds())
-	draw.Draw(rgba, rgba.Bounds(), img, image.Point{}, draw.Src)
+	overlay, err := drawText(rgba.Bounds(), style.Font, text, false, style.FontSize, style.Color, Style{})
+	if err != nil {
+		return nil, err
+	}
+	r := place(rgba.Bounds(), overlay.Bounds().Size(), Placement{Anchor: style.Anchor, Margin: style.Margin})
+	mask := image.NewUniform(color.Alpha{A: uint8(math.Round(style.Opacity * 255))})
+	draw.DrawMask(rgba, r, overlay, overlay.Bounds().Min, mask, image.Point{}, draw.Over)
 
-	bounds := rgba.Bounds()
-	point := p.calculateTextPosition(bounds, text)
+	return encode(ctx, rgba, style.Format, style.Quality)
+}
 
-	c := freetype.NewContext()
-	c.SetDPI(72)
-	c.SetFont(p.font)
-	c.SetFontSize(p.fontSize)
-	c.SetClip(rgba.Bounds())
-	c.SetDst(rgba)
-	c.SetSrc(image.NewUniform(p.fontColor))
-	c.SetHinting(font.HintingNone)
+// decodeRGBA checks the image's size, decodes it and copies it into a
+// drawable RGBA image, checking ctx between stages and rows.
+func (p *WatermarkProcessor) decodeRGBA(ctx context.Context, imageBytes []byte) (*image.RGBA, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(imageBytes))
+	if errors.Is(err, image.ErrFormat) {
+		return nil, ErrUnsupportedFormat
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+	if p.maxPixels > 0 && cfg.Width*cfg.Height > p.maxPixels {
+		return nil, fmt.Errorf("%w: %dx%d exceeds %d pixels", ErrTooLarge, cfg.Width, cfg.Height, p.maxPixels)
+	}
 
-	_, err = c.DrawString(text, point)
+	img, _, err := image.Decode(&contextReader{ctx: ctx, r: bytes.NewReader(imageBytes)})
+	if ctx.Err() != nil {
+		return nil, fmt.Errorf("decode interrupted: %w", ctx.Err())
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to draw string: %w", err)
+		return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+	}
+
+	rgba := image.NewRGBA(img.Bounds())
+	for y := rgba.Rect.Min.Y; y < rgba.Rect.Max.Y; y += rowsPerCheck {
+		if err := ctx.Err(); err != nil {
+			return nil, fmt.Errorf("draw interrupted: %w", err)
+		}
+		rows := image.Rect(rgba.Rect.Min.X, y, rgba.Rect.Max.X, min(y+rowsPerCheck, rgba.Rect.Max.Y))
+		draw.Draw(rgba, rows, img, rows.Min, draw.Src)
+	}
+	return rgba, nil
+}
+
+// encode encodes img in format, stopping once ctx is done. quality only applies to JPEG.
+func encode(ctx context.Context, img image.Image, format string, quality int) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, fmt.Errorf("encode interrupted: %w", err)
 	}
 
 	buf := new(bytes.Buffer)
-	if err := jpeg.Encode(buf, rgba, &jpeg.Options{Quality: p.imageQuality}); err != nil {
+	w := &contextWriter{ctx: ctx, w: buf}
+	var err error
+	if format == FormatPNG {
+		err = png.Encode(w, img)
+	} else {
+		err = jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+	if err != nil {
 		return nil, fmt.Errorf("failed to encode image: %w", err)
 	}
-
 	return buf.Bytes(), nil
 }
 
-// calculateTextPosition determines where to place the watermark text.
-// Currently, it centers the text at the bottom of the image.
-func (p *WatermarkProcessor) calculateTextPosition(bounds image.Rectangle, text string) fixed.Point26_6 {
-	face := truetype.NewFace(p.font, &truetype.Options{Size: p.fontSize})
-	textWidth := font.MeasureString(face, text)
+// contextReader fails reads once ctx is done, so decoding stops partway through.
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
 
-	x := (bounds.Max.X - int(textWidth)>>6) / 2
-	y := bounds.Max.Y - int(p.fontSize*1.5) // Positioned slightly above the bottom edge
+// contextWriter fails writes once ctx is done, so encoding stops partway through.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
 
-	return fixed.Point26_6{
-		X: fixed.Int26_6(x * 64),
-		Y: fixed.Int26_6(y * 64),
+func (w *contextWriter) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, err
 	}
+	return w.w.Write(p)
 }
@@ -0,0 +1,218 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"watermark/internal/storage"
+)
+
+var (
+	cacheWriteQueueDepth = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "cache_write_queue_depth",
+		Help: "Number of cache writes waiting in the write-behind queue.",
+	})
+	cacheWritesDropped = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cache_write_dropped_total",
+		Help: "The total number of cache writes dropped before reaching the cache, by reason.",
+	}, []string{"reason"})
+	cacheWriteDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "cache_write_duration_seconds",
+		Help: "Duration of background cache writes.",
+	})
+	cacheWriteErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "cache_write_errors_total",
+		Help: "The total number of background cache writes that failed.",
+	})
+)
+
+// Queue policies for a full CacheWriter.
+const (
+	// WritePolicyDropOldest discards the oldest queued write to make room.
+	WritePolicyDropOldest = "drop-oldest"
+	// WritePolicyBlock makes the caller wait for room, up to its context deadline.
+	WritePolicyBlock = "block"
+)
+
+// CacheWriterConfig controls the write-behind queue.
+type CacheWriterConfig struct {
+	QueueSize int
+	Workers   int
+	Timeout   time.Duration // Per-write timeout
+	Policy    string
+}
+
+// CacheWrite is one cache entry to write in the background.
+type CacheWrite struct {
+	Key  string
+	Data []byte
+	Tags []string
+}
+
+// cacheWrite is one queued item. Its entries are written in order and
+// dropped together.
+type cacheWrite []CacheWrite
+
+// CacheWriter performs cache writes in the background on a bounded queue
+// served by a fixed pool of workers.
+type CacheWriter struct {
+	cache   storage.ImageCache
+	queue   chan cacheWrite
+	timeout time.Duration
+	policy  string
+	log     *logrus.Entry
+
+	// quit is closed by Close. The queue itself is never closed, so a send
+	// can wait for room without holding a lock that Close needs.
+	quit      chan struct{}
+	closeOnce sync.Once
+	wg        sync.WaitGroup
+}
+
+// NewCacheWriter creates a CacheWriter and starts its workers.
+func NewCacheWriter(cache storage.ImageCache, cfg CacheWriterConfig, logger *logrus.Logger) *CacheWriter {
+	if cfg.Workers < 1 {
+		cfg.Workers = 1
+	}
+	w := &CacheWriter{
+		cache:   cache,
+		queue:   make(chan cacheWrite, cfg.QueueSize),
+		timeout: cfg.Timeout,
+		policy:  cfg.Policy,
+		log:     logger.WithField("component", "CacheWriter"),
+		quit:    make(chan struct{}),
+	}
+
+	w.wg.Add(cfg.Workers)
+	for i := 0; i < cfg.Workers; i++ {
+		go w.work()
+	}
+	return w
+}
+
+// Enqueue schedules a cache write. It never returns an error; writes that
+// cannot be queued are dropped and counted, since the cache is best effort.
+func (w *CacheWriter) Enqueue(ctx context.Context, key string, data []byte, tags ...string) {
+	w.EnqueueGroup(ctx, CacheWrite{Key: key, Data: data, Tags: tags})
+}
+
+// EnqueueGroup schedules writes that belong together as a single item, so a
+// full queue drops all of them or none. They are written in order, and the
+// rest are skipped once one fails.
+func (w *CacheWriter) EnqueueGroup(ctx context.Context, writes ...CacheWrite) {
+	if len(writes) == 0 {
+		return
+	}
+	key := writes[0].Key
+
+	select {
+	case <-w.quit:
+		cacheWritesDropped.WithLabelValues("closed").Add(float64(len(writes)))
+		return
+	default:
+	}
+
+	item := cacheWrite(writes)
+	if w.policy == WritePolicyBlock {
+		select {
+		case w.queue <- item:
+		case <-w.quit:
+			cacheWritesDropped.WithLabelValues("closed").Add(float64(len(writes)))
+		case <-ctx.Done():
+			cacheWritesDropped.WithLabelValues("canceled").Add(float64(len(writes)))
+			w.log.WithField("cache_key", key).Warn("Gave up waiting for cache write queue")
+		}
+	} else {
+		for queued := false; !queued; {
+			select {
+			case w.queue <- item:
+				queued = true
+			default:
+				select {
+				case old := <-w.queue:
+					cacheWritesDropped.WithLabelValues("full").Add(float64(len(old)))
+					w.log.WithField("cache_key", old[0].Key).Warn("Cache write queue full, dropped oldest write")
+				default:
+				}
+			}
+		}
+	}
+	cacheWriteQueueDepth.Set(float64(len(w.queue)))
+}
+
+// Close stops accepting writes and waits for queued writes to finish,
+// or for ctx to be done, whichever comes first.
+func (w *CacheWriter) Close(ctx context.Context) error {
+	w.closeOnce.Do(func() { close(w.quit) })
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		w.log.WithField("pending", len(w.queue)).Warn("Cache write queue not drained before shutdown")
+		return ctx.Err()
+	}
+}
+
+// work writes queued items until Close, then drains what is left.
+func (w *CacheWriter) work() {
+	defer w.wg.Done()
+	for {
+		select {
+		case item := <-w.queue:
+			cacheWriteQueueDepth.Set(float64(len(w.queue)))
+			w.write(item)
+		case <-w.quit:
+			for {
+				select {
+				case item := <-w.queue:
+					w.write(item)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// write sets the entries of an item in order, stopping at the first failure.
+func (w *CacheWriter) write(item cacheWrite) {
+	for i, entry := range item {
+		if !w.set(entry) {
+			if skipped := len(item) - i - 1; skipped > 0 {
+				cacheWritesDropped.WithLabelValues("failed").Add(float64(skipped))
+			}
+			return
+		}
+	}
+}
+
+func (w *CacheWriter) set(entry CacheWrite) bool {
+	ctx := context.Background()
+	if w.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.timeout)
+		defer cancel()
+	}
+
+	startTime := time.Now()
+	err := w.cache.Set(ctx, entry.Key, entry.Data, entry.Tags...)
+	cacheWriteDuration.Observe(time.Since(startTime).Seconds())
+	if err != nil {
+		cacheWriteErrors.Inc()
+		w.log.WithError(err).WithField("cache_key", entry.Key).Error("Failed to set cache")
+		return false
+	}
+	return true
+}
@@ -0,0 +1,181 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// gatedCache holds every Set until release is closed, so tests can fill the
+// queue behind a busy worker.
+type gatedCache struct {
+	fakeCache
+	started chan string
+	release chan struct{}
+	fail    string // Key whose write fails
+
+	mu      sync.Mutex
+	written []string
+}
+
+func newGatedCache() *gatedCache {
+	return &gatedCache{
+		started: make(chan string, 16),
+		release: make(chan struct{}),
+	}
+}
+
+func (c *gatedCache) Set(ctx context.Context, key string, data []byte, tags ...string) error {
+	c.started <- key
+	<-c.release
+	if key == c.fail {
+		return errors.New("write failed")
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.written = append(c.written, key)
+	return nil
+}
+
+func (c *gatedCache) keys() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]string(nil), c.written...)
+}
+
+// newBusyWriter returns a single-worker CacheWriter that is stuck writing "a".
+func newBusyWriter(t *testing.T, cache *gatedCache, queueSize int, policy string) *CacheWriter {
+	t.Helper()
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	w := NewCacheWriter(cache, CacheWriterConfig{QueueSize: queueSize, Workers: 1, Policy: policy}, logger)
+	w.Enqueue(context.Background(), "a", nil)
+	select {
+	case <-cache.started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("worker did not pick up the first write")
+	}
+	return w
+}
+
+func TestCacheWriterDropOldest(t *testing.T) {
+	cache := newGatedCache()
+	w := newBusyWriter(t, cache, 2, WritePolicyDropOldest)
+
+	for _, key := range []string{"b", "c", "d"} {
+		w.Enqueue(context.Background(), key, nil)
+	}
+	close(cache.release)
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := cache.keys(), []string{"a", "c", "d"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("written %v, want %v", got, want)
+	}
+}
+
+func TestCacheWriterGroup(t *testing.T) {
+	group := []CacheWrite{{Key: "b"}, {Key: "b#version"}}
+
+	t.Run("dropped together", func(t *testing.T) {
+		cache := newGatedCache()
+		w := newBusyWriter(t, cache, 2, WritePolicyDropOldest)
+		w.EnqueueGroup(context.Background(), group...)
+		w.Enqueue(context.Background(), "c", nil)
+		w.Enqueue(context.Background(), "d", nil)
+		close(cache.release)
+		if err := w.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := cache.keys(), []string{"a", "c", "d"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("written %v, want %v", got, want)
+		}
+	})
+
+	t.Run("stops at a failed write", func(t *testing.T) {
+		cache := newGatedCache()
+		cache.fail = "b"
+		w := newBusyWriter(t, cache, 2, WritePolicyDropOldest)
+		w.EnqueueGroup(context.Background(), group...)
+		w.Enqueue(context.Background(), "c", nil)
+		close(cache.release)
+		if err := w.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, want := cache.keys(), []string{"a", "c"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("written %v, want %v", got, want)
+		}
+	})
+}
+
+func TestCacheWriterBlock(t *testing.T) {
+	cache := newGatedCache()
+	w := newBusyWriter(t, cache, 1, WritePolicyBlock)
+	w.Enqueue(context.Background(), "b", nil)
+
+	// The queue is full, so a caller with a deadline gives up...
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	w.Enqueue(ctx, "dropped", nil)
+
+	// ...and one without waits until there is room.
+	queued := make(chan struct{})
+	go func() {
+		w.Enqueue(context.Background(), "c", nil)
+		close(queued)
+	}()
+	select {
+	case <-queued:
+		t.Fatal("Enqueue returned while the queue was full")
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	close(cache.release)
+	<-queued
+	if err := w.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := cache.keys(), []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("written %v, want %v", got, want)
+	}
+}
+
+func TestCacheWriterClose(t *testing.T) {
+	t.Run("drains queued writes", func(t *testing.T) {
+		cache := newGatedCache()
+		w := newBusyWriter(t, cache, 4, WritePolicyDropOldest)
+		w.Enqueue(context.Background(), "b", nil)
+		close(cache.release)
+
+		if err := w.Close(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		w.Enqueue(context.Background(), "after close", nil)
+
+		if got, want := cache.keys(), []string{"a", "b"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("written %v, want %v", got, want)
+		}
+	})
+
+	t.Run("gives up at the deadline", func(t *testing.T) {
+		cache := newGatedCache()
+		w := newBusyWriter(t, cache, 4, WritePolicyDropOldest)
+		defer close(cache.release)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		if err := w.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Close() = %v, want %v", err, context.DeadlineExceeded)
+		}
+	})
+}
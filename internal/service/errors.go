@@ -0,0 +1,94 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"watermark/internal/presets"
+	"watermark/internal/processor"
+	"watermark/internal/storage"
+	"watermark/pkg/ratelimit"
+)
+
+// Error kinds returned by ImageService. Callers match them with errors.Is;
+// the underlying cause stays available through the same chain.
+var (
+	ErrNotFound          = errors.New("not found")
+	ErrInvalidInput      = errors.New("invalid input")
+	ErrInvalidSpec       = errors.New("invalid render spec")
+	ErrIncomplete        = errors.New("incomplete request")
+	ErrInvalidMetadata   = errors.New("invalid metadata")
+	ErrUnknownPreset     = errors.New("unknown preset")
+	ErrUnknownLocale     = errors.New("unknown locale")
+	ErrUnsupportedFormat = errors.New("unsupported format")
+	ErrTooLarge          = errors.New("too large")
+	ErrUpstream          = errors.New("upstream failure")
+	ErrTimeout           = errors.New("timeout")
+	ErrCanceled          = errors.New("canceled")
+	ErrRateLimited       = errors.New("rate limited")
+)
+
+// Error pairs a failure with the kind describing it.
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() []error {
+	return []error{e.Kind, e.Err}
+}
+
+// wrapError classifies err, using fallback when no more specific kind applies.
+// A nil fallback leaves unclassified errors untouched.
+func wrapError(err, fallback error) error {
+	if err == nil {
+		return nil
+	}
+	var serviceErr *Error
+	if errors.As(err, &serviceErr) {
+		return err
+	}
+
+	kind := classify(err)
+	if kind == nil {
+		kind = fallback
+	}
+	if kind == nil {
+		return err
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+func classify(err error) error {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled):
+		return ErrCanceled
+	case errors.Is(err, context.DeadlineExceeded):
+		return ErrTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrTimeout
+	case errors.Is(err, storage.ErrNotFound):
+		return ErrNotFound
+	case errors.Is(err, storage.ErrTooLarge), errors.Is(err, processor.ErrTooLarge):
+		return ErrTooLarge
+	case errors.Is(err, processor.ErrUnsupportedFormat):
+		return ErrUnsupportedFormat
+	case errors.Is(err, processor.ErrInvalidImage):
+		return ErrInvalidInput
+	case errors.Is(err, processor.ErrInvalidSpec):
+		return ErrInvalidSpec
+	case errors.Is(err, storage.ErrInvalidMetadata):
+		return ErrInvalidMetadata
+	case errors.Is(err, presets.ErrUnknown):
+		return ErrUnknownPreset
+	case errors.Is(err, ratelimit.ErrLimited):
+		return ErrRateLimited
+	}
+	return nil
+}
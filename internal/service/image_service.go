@@ -2,16 +2,25 @@ package service
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"net/http"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/sirupsen/logrus"
 
-	"watermark-service/internal/processor"
-	"watermark-service/internal/storage"
+	"watermark/internal/locale"
+	"watermark/internal/measure"
+	"watermark/internal/presets"
+	"watermark/internal/processor"
+	"watermark/internal/storage"
+	"watermark/pkg/auth"
+	"watermark/pkg/ratelimit"
 )
 
 var (
@@ -27,70 +36,602 @@ var (
 		Name: "image_cache_misses_total",
 		Help: "The total number of cache misses.",
 	})
+	cacheStaleServed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "image_cache_stale_served_total",
+		Help: "The total number of stale cache entries served, by reason.",
+	}, []string{"reason"})
+	imagesPublished = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "image_publish_total",
+		Help: "The total number of publish-mode requests, by whether the render already existed or was uploaded.",
+	}, []string{"result"})
+	cachePurged = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "image_cache_purged_total",
+		Help: "The total number of cache items removed by purges.",
+	})
+	processAborted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "image_process_aborted_total",
+		Help: "The total number of image requests that timed out or were canceled, by stage and reason.",
+	}, []string{"stage", "reason"})
+)
+
+// Processing stages, reported through WithProgress and used to label aborted requests.
+const (
+	StageCacheLookup = "cache_lookup"
+	StageOriginFetch = "origin_fetch"
+	StageRender      = "render"
+)
+
+type progressKey struct{}
+
+// WithProgress returns a context that reports each processing stage to fn as it starts.
+func WithProgress(ctx context.Context, fn func(stage string)) context.Context {
+	return context.WithValue(ctx, progressKey{}, fn)
+}
+
+func reportProgress(ctx context.Context, stage string) {
+	if fn, ok := ctx.Value(progressKey{}).(func(string)); ok {
+		fn(stage)
+	}
+}
+
+// Cache statuses reported in ProcessResult.
+const (
+	CacheHit   = "HIT"
+	CacheMiss  = "MISS"
+	CacheStale = "STALE"
 )
 
+// ProcessRequest describes a single watermark render.
+type ProcessRequest struct {
+	ImageID    string
+	Weight     measure.Weight
+	Dimensions measure.Dimensions
+	// Preset names the watermark style; empty means the default preset.
+	Preset string
+	// WeightUnit and LengthUnit override the preset's display units when set.
+	WeightUnit measure.WeightUnit
+	LengthUnit measure.LengthUnit
+	// Locale chooses the labels and the number and date formats; empty means
+	// the preset's. See ImageService.Locale.
+	Locale string
+	// Date, when set, is shown as {{.date}} in the locale's format.
+	Date time.Time
+	// Fields are further template variables, filled in from the image's
+	// stored metadata.
+	Fields map[string]string
+}
+
+// display returns how the request's values are shown with preset in loc.
+func (r ProcessRequest) display(preset *presets.Preset, loc *locale.Locale) measure.Display {
+	d := preset.Display
+	d.Number = loc.Number
+	if r.WeightUnit != "" {
+		d.WeightUnit = r.WeightUnit
+	}
+	if r.LengthUnit != "" {
+		d.LengthUnit = r.LengthUnit
+	}
+	return d
+}
+
+// Vars returns the template variables for the request, formatted in preset's
+// units and the request's locale.
+func (r ProcessRequest) Vars(preset *presets.Preset) map[string]string {
+	loc := preset.Localize(r.Locale)
+	vars := make(map[string]string, len(preset.Fields)+len(r.Fields))
+	for _, field := range preset.Fields {
+		vars[field] = ""
+	}
+	for k, v := range r.Fields {
+		vars[k] = v
+	}
+	for k, v := range preset.Vars(r.display(preset, loc), r.Weight, r.Dimensions) {
+		vars[k] = v
+	}
+	for k, v := range loc.Vars(r.Date) {
+		vars[k] = v
+	}
+	return vars
+}
+
+// WatermarkText returns the overlay text for the request from preset's template.
+func (r ProcessRequest) WatermarkText(preset *presets.Preset) (string, error) {
+	return preset.Render(r.Vars(preset))
+}
+
+// ProcessResult is a rendered image along with how it was obtained.
+type ProcessResult struct {
+	Data        []byte
+	ContentType string
+	// CacheStatus is empty for renders that don't go through the cache.
+	CacheStatus string
+	// Version is nil when the origin object's version isn't known.
+	Version *ImageVersion
+}
+
+// CachePolicy controls when cached renders are served, checked or replaced.
+type CachePolicy struct {
+	// StaleWhileRevalidate is how long after expiry an entry is served immediately while a refresh runs in the background.
+	StaleWhileRevalidate time.Duration
+	// StaleIfError is how long after expiry an entry is served when rendering a fresh copy fails.
+	StaleIfError time.Duration
+	// RevalidateAfter is how long a render is trusted before its origin object is
+	// checked for changes on the next hit. Zero disables revalidation.
+	RevalidateAfter time.Duration
+}
+
+// Timeouts are the deadline budgets for a single request. Each stage gets its
+// own timeout, but never more than what is left of the overall budget.
+type Timeouts struct {
+	Request     time.Duration
+	CacheLookup time.Duration
+	OriginFetch time.Duration
+	Render      time.Duration
+}
+
+type timeoutsKey struct{}
+
+// WithTimeouts returns a context whose requests run under t instead of the
+// service's timeouts. Jobs use it, since they aren't bound by an HTTP response.
+// Zero stage timeouts leave each stage bounded only by t.Request.
+func WithTimeouts(ctx context.Context, t Timeouts) context.Context {
+	return context.WithValue(ctx, timeoutsKey{}, t)
+}
+
+// timeoutsFor returns the timeouts that apply to a request made with ctx.
+func (s *ImageService) timeoutsFor(ctx context.Context) Timeouts {
+	if t, ok := ctx.Value(timeoutsKey{}).(Timeouts); ok {
+		return t
+	}
+	return s.timeouts
+}
+
+// stageContext derives a context for one stage; the parent's deadline still applies.
+func stageContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
 // ImageService is the core service for processing images.
-// It orchestrates the fetching, processing, and caching of images.	ype ImageService struct {
+// It orchestrates the fetching, processing, and caching of images.
+type ImageService struct {
 	storage   storage.ImageStorage
 	cache     storage.ImageCache
+	output    storage.OutputStorage
+	writer    *CacheWriter
 	processor *processor.WatermarkProcessor
+	presets   *presets.Registry
+	locales   *locale.Catalog
+	metadata  *storage.MetadataStore
+	policy    CachePolicy
+	timeouts  Timeouts
 	log       *logrus.Entry
+	// overrideMetadata lets request values win over stored metadata.
+	overrideMetadata bool
+	// overlayPrefixes are the key prefixes render specs may load overlays from.
+	overlayPrefixes []string
+
+	mu         sync.Mutex
+	refreshing map[string]struct{}
 }
 
 // NewImageService creates a new ImageService.
+// A nil output disables publish mode, and a nil metadata store stored metadata.
+// Render specs may only load overlays with keys under overlayPrefixes.
 func NewImageService(
 	storage storage.ImageStorage,
 	cache storage.ImageCache,
+	output storage.OutputStorage,
+	writer *CacheWriter,
 	processor *processor.WatermarkProcessor,
+	presets *presets.Registry,
+	locales *locale.Catalog,
+	metadata *storage.MetadataStore,
+	overrideMetadata bool,
+	overlayPrefixes []string,
+	policy CachePolicy,
+	timeouts Timeouts,
 	logger *logrus.Logger,
 ) *ImageService {
 	return &ImageService{
-		storage:   storage,
-		cache:     cache,
-		processor: processor,
-		log:       logger.WithField("component", "ImageService"),
+		storage:          storage,
+		cache:            cache,
+		output:           output,
+		writer:           writer,
+		processor:        processor,
+		presets:          presets,
+		locales:          locales,
+		metadata:         metadata,
+		policy:           policy,
+		timeouts:         timeouts,
+		log:              logger.WithField("component", "ImageService"),
+		overrideMetadata: overrideMetadata,
+		overlayPrefixes:  overlayPrefixes,
+		refreshing:       make(map[string]struct{}),
+	}
+}
+
+// drawFunc renders onto an original image fetched from storage.
+type drawFunc func(ctx context.Context, originalImage []byte) ([]byte, error)
+
+// textWatermark draws a single line of text in a preset's style.
+func (s *ImageService) textWatermark(text string, preset *presets.Preset) drawFunc {
+	return func(ctx context.Context, originalImage []byte) ([]byte, error) {
+		return s.processor.AddWatermark(ctx, originalImage, text, preset.Style)
 	}
 }
 
+// resolved is a request ready to render.
+type resolved struct {
+	preset   *presets.Preset
+	text     string
+	cacheKey string
+}
+
+// resolve looks up req's preset, completes req with the image's stored
+// metadata and works out the cache key of its render. The preset's hash and the
+// metadata's version are part of the key, so editing a preset or a sidecar
+// never serves renders made from the old one. So are the locale and its
+// catalog's hash, so each language is cached separately and editing a catalog
+// file renders afresh.
+func (s *ImageService) resolve(ctx context.Context, req ProcessRequest) (*resolved, error) {
+	preset, err := s.presets.Get(req.Preset)
+	if err != nil {
+		return nil, wrapError(err, nil)
+	}
+	req, version, err := s.withMetadata(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if req.Weight <= 0 || req.Dimensions.IsZero() {
+		return nil, &Error{Kind: ErrIncomplete, Err: fmt.Errorf("missing weight or dimensions for %s", req.ImageID)}
+	}
+	text, err := req.WatermarkText(preset)
+	if err != nil {
+		return nil, err
+	}
+	loc := preset.Localize(req.Locale)
+	return &resolved{
+		preset:   preset,
+		text:     text,
+		cacheKey: fmt.Sprintf("%s-%s-%s.%s-%s-%s", req.ImageID, preset.Hash, loc.Tag, loc.Hash, version, text),
+	}, nil
+}
+
 // ProcessImage handles the main logic for fetching, watermarking, and caching an image.
-func (s *ImageService) ProcessImage(ctx context.Context, imageKey, watermarkText string) ([]byte, error) {
-	cacheKey := fmt.Sprintf("%s-%s", imageKey, watermarkText)
+func (s *ImageService) ProcessImage(ctx context.Context, req ProcessRequest) (*ProcessResult, error) {
+	res, err := s.resolve(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	return s.process(ctx, req.ImageID, res.cacheKey, res.preset.ContentType(), s.textWatermark(res.text, res.preset))
+}
+
+// process serves the render cached under cacheKey, or renders imageID with draw
+// and caches the result. Renders are of type contentType.
+func (s *ImageService) process(ctx context.Context, imageID, cacheKey, contentType string, draw drawFunc) (*ProcessResult, error) {
+	log := s.logFor(ctx)
+
+	ctx, cancel := stageContext(ctx, s.timeoutsFor(ctx).Request)
+	defer cancel()
 
 	// 1. Check cache first
-	cachedImage, err := s.cache.Get(ctx, cacheKey)
+	reportProgress(ctx, StageCacheLookup)
+	lookupCtx, cancelLookup := stageContext(ctx, s.timeoutsFor(ctx).CacheLookup)
+	entry, err := s.cache.GetEntry(lookupCtx, cacheKey)
+	cancelLookup()
+	if ctx.Err() != nil {
+		return nil, s.aborted(ctx, StageCacheLookup, cacheKey)
+	}
 	if err != nil {
 		// Log the error but continue, as we can still fetch from origin.
-		s.log.WithError(err).WithField("cache_key", cacheKey).Error("Cache GET failed")
+		log.WithError(err).WithField("cache_key", cacheKey).Error("Cache GET failed")
+	}
+	var rec *versionRecord
+	if entry != nil {
+		rec = s.loadVersion(ctx, cacheKey)
 	}
-	if cachedImage != nil {
-		cacheHits.Inc()
-		s.log.WithField("cache_key", cacheKey).Info("Cache hit")
-		return cachedImage, nil
+	if entry != nil && !entry.Stale {
+		if !s.originChanged(ctx, imageID, cacheKey, rec) {
+			cacheHits.Inc()
+			log.WithField("cache_key", cacheKey).Info("Cache hit")
+			return &ProcessResult{Data: entry.Data, ContentType: contentType, CacheStatus: CacheHit, Version: rec.imageVersion(cacheKey)}, nil
+		}
+		// The source was replaced, so the cached render is wrong rather than just old.
+		entry = nil
+	}
+
+	// 2. Stale within the revalidation window: serve it and refresh behind the response.
+	if entry != nil && entry.StaleFor <= s.policy.StaleWhileRevalidate {
+		cacheStaleServed.WithLabelValues("revalidate").Inc()
+		log.WithField("cache_key", cacheKey).Info("Serving stale cache entry while revalidating")
+		s.refreshInBackground(imageID, cacheKey, contentType, draw)
+		return &ProcessResult{Data: entry.Data, ContentType: contentType, CacheStatus: CacheStale, Version: rec.imageVersion(cacheKey)}, nil
 	}
 
-	// 2. Cache miss: fetch original image
+	// 3. Cache miss: render a fresh copy
 	cacheMisses.Inc()
-	s.log.WithField("cache_key", cacheKey).Info("Cache miss")
+	log.WithField("cache_key", cacheKey).Info("Cache miss")
 
-	originalImage, err := s.storage.Get(ctx, imageKey)
+	if err := ratelimit.ChargeRender(ctx); err != nil {
+		if entry != nil && entry.StaleFor <= s.policy.StaleIfError {
+			// A stale copy beats a refusal when the client only ran out of render budget.
+			cacheStaleServed.WithLabelValues("rate_limited").Inc()
+			return &ProcessResult{Data: entry.Data, ContentType: contentType, CacheStatus: CacheStale, Version: rec.imageVersion(cacheKey)}, nil
+		}
+		return nil, wrapError(err, nil)
+	}
+
+	processedImage, info, err := s.render(ctx, imageID, draw)
+	if errors.Is(err, ErrCanceled) {
+		// Nobody is waiting for the response, so there's no point serving stale.
+		return nil, err
+	}
 	if err != nil {
-		return nil, fmt.Errorf("failed to get image from storage: %w", err)
+		// A missing origin object is a definitive answer, not an outage, so don't mask it.
+		if entry != nil && entry.StaleFor <= s.policy.StaleIfError && !errors.Is(err, ErrNotFound) {
+			cacheStaleServed.WithLabelValues("error").Inc()
+			log.WithError(err).WithField("cache_key", cacheKey).Warn("Render failed, serving stale cache entry")
+			return &ProcessResult{Data: entry.Data, ContentType: contentType, CacheStatus: CacheStale, Version: rec.imageVersion(cacheKey)}, nil
+		}
+		return nil, err
 	}
 
-	// 3. Process the image
+	// 4. Store in cache for future requests (async)
+	rec = newVersionRecord(info, processedImage, contentType)
+	s.storeRender(ctx, cacheKey, imageID, processedImage, rec)
+
+	return &ProcessResult{Data: processedImage, ContentType: contentType, CacheStatus: CacheMiss, Version: rec.imageVersion(cacheKey)}, nil
+}
+
+// Locale picks the locale for a request: lang when set, which must be in the
+// catalog, or else the best match for an Accept-Language header. An empty
+// result leaves the choice to the preset.
+func (s *ImageService) Locale(lang, acceptLanguage string) (string, error) {
+	if lang != "" {
+		l, ok := s.locales.Get(lang)
+		if !ok {
+			return "", fmt.Errorf("%w: %q", ErrUnknownLocale, lang)
+		}
+		return l.Tag, nil
+	}
+	return s.locales.Match(acceptLanguage), nil
+}
+
+// Publishing reports whether renders are published to output storage.
+func (s *ImageService) Publishing() bool {
+	return s.output != nil
+}
+
+// PublishImage makes sure the render for req exists in output storage and returns
+// a URL for it. Renders that were already published are not rendered again.
+// Published keys include the origin object's version, so once the source is
+// replaced, or purged and replaced, the new render is published under a new key.
+func (s *ImageService) PublishImage(ctx context.Context, req ProcessRequest) (string, error) {
+	res, err := s.resolve(ctx, req)
+	if err != nil {
+		return "", err
+	}
+	version, err := s.currentVersion(ctx, req.ImageID, res.cacheKey)
+	if err != nil {
+		return "", err
+	}
+	key := publishKey(res, version)
+
+	exists, err := s.output.Exists(ctx, key)
+	if err != nil {
+		return "", wrapError(fmt.Errorf("failed to check published image: %w", err), ErrUpstream)
+	}
+
+	if exists {
+		imagesPublished.WithLabelValues("existing").Inc()
+	} else {
+		result, err := s.ProcessImage(ctx, req)
+		if err != nil {
+			return "", err
+		}
+		// Publish under the version the render was actually made from.
+		key = publishKey(res, result.Version)
+		if err := s.output.Put(ctx, key, result.Data, result.ContentType); err != nil {
+			return "", wrapError(fmt.Errorf("failed to publish image: %w", err), ErrUpstream)
+		}
+		imagesPublished.WithLabelValues("uploaded").Inc()
+		s.logFor(ctx).WithField("key", key).Info("Published image")
+	}
+
+	url, err := s.output.URL(ctx, key)
+	if err != nil {
+		return "", wrapError(fmt.Errorf("failed to build published image URL: %w", err), nil)
+	}
+	return url, nil
+}
+
+// currentVersion returns the version of the render for cacheKey as of the
+// origin object's current version: from the render's version record while it
+// is trusted, or else from the origin. It returns nil if the origin object
+// has no version.
+func (s *ImageService) currentVersion(ctx context.Context, imageID, cacheKey string) (*ImageVersion, error) {
+	if rec := s.loadVersion(ctx, cacheKey); rec != nil && !s.originChanged(ctx, imageID, cacheKey, rec) {
+		return rec.imageVersion(cacheKey), nil
+	}
+
+	statCtx, cancel := stageContext(ctx, s.timeoutsFor(ctx).OriginFetch)
+	defer cancel()
+	info, err := s.storage.Stat(statCtx, imageID, "")
+	if err != nil {
+		return nil, wrapError(fmt.Errorf("failed to stat image: %w", err), ErrUpstream)
+	}
+	return newVersionRecord(info, nil, "").imageVersion(cacheKey), nil
+}
+
+// publishKey names the published render for res made from version, which may be nil.
+func publishKey(res *resolved, version *ImageVersion) string {
+	if version != nil {
+		return strings.Trim(version.ETag, `"`) + res.preset.Extension()
+	}
+	hash := sha256.Sum256([]byte(res.cacheKey))
+	return hex.EncodeToString(hash[:]) + res.preset.Extension()
+}
+
+// PurgeImage removes every cached variant of an image, including a cached
+// original, and returns how many items were removed.
+func (s *ImageService) PurgeImage(ctx context.Context, imageID string) (int, error) {
+	return s.purge(ctx, func(p storage.Purger) (int, error) { return p.Purge(ctx, imageID) })
+}
+
+// PurgePrefix removes every cached variant of all images whose ID starts with prefix.
+func (s *ImageService) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	return s.purge(ctx, func(p storage.Purger) (int, error) { return p.PurgePrefix(ctx, prefix) })
+}
+
+// purge applies fn to the render cache, to any cache layered into storage and
+// to remembered metadata.
+func (s *ImageService) purge(ctx context.Context, fn func(storage.Purger) (int, error)) (int, error) {
+	purgers := []storage.Purger{s.cache}
+	if p, ok := s.storage.(storage.Purger); ok {
+		purgers = append(purgers, p)
+	}
+	if s.metadata != nil {
+		purgers = append(purgers, s.metadata)
+	}
+
+	total := 0
+	for _, p := range purgers {
+		n, err := fn(p)
+		total += n
+		if err != nil {
+			return total, wrapError(fmt.Errorf("failed to purge cache: %w", err), ErrUpstream)
+		}
+	}
+	cachePurged.Add(float64(total))
+	s.logFor(ctx).WithField("purged", total).Info("Purged cache")
+	return total, nil
+}
+
+// ChargeRender charges one render to the caller's render budget, for work
+// that renders later, outside the request, such as a job.
+func (s *ImageService) ChargeRender(ctx context.Context) error {
+	return wrapError(ratelimit.ChargeRender(ctx), nil)
+}
+
+// logFor returns the service logger annotated with the request's principal, if any.
+func (s *ImageService) logFor(ctx context.Context) *logrus.Entry {
+	if p := auth.PrincipalFromContext(ctx); p != nil {
+		return s.log.WithField("principal", p.Subject)
+	}
+	return s.log
+}
+
+// render fetches the original image and draws on it, each stage under its own deadline.
+// It also returns the version of the origin object it used, if known.
+func (s *ImageService) render(ctx context.Context, imageKey string, draw drawFunc) ([]byte, *storage.ObjectInfo, error) {
+	reportProgress(ctx, StageOriginFetch)
+	fetchCtx, cancelFetch := stageContext(ctx, s.timeoutsFor(ctx).OriginFetch)
+	originalImage, info, err := s.storage.Get(fetchCtx, imageKey)
+	cancelFetch()
+	if err != nil {
+		s.recordAbort(ctx, fetchCtx, StageOriginFetch, imageKey)
+		return nil, nil, wrapError(fmt.Errorf("failed to get image from storage: %w", err), ErrUpstream)
+	}
+
+	processedImage, err := s.watermark(ctx, imageKey, originalImage, draw)
+	if err != nil {
+		return nil, nil, err
+	}
+	return processedImage, info, nil
+}
+
+// watermark runs the render stage under its own deadline.
+func (s *ImageService) watermark(ctx context.Context, imageKey string, originalImage []byte, draw drawFunc) ([]byte, error) {
+	reportProgress(ctx, StageRender)
+	renderCtx, cancelRender := stageContext(ctx, s.timeoutsFor(ctx).Render)
+	defer cancelRender()
+
 	startTime := time.Now()
-	processedImage, err := s.processor.AddWatermark(originalImage, watermarkText)
+	processedImage, err := draw(renderCtx, originalImage)
 	if err != nil {
-		return nil, fmt.Errorf("failed to add watermark: %w", err)
+		s.recordAbort(ctx, renderCtx, StageRender, imageKey)
+		return nil, wrapError(fmt.Errorf("failed to add watermark: %w", err), nil)
 	}
 	imageProcessDuration.Observe(time.Since(startTime).Seconds())
+	return processedImage, nil
+}
+
+// ProcessUpload watermarks an image supplied by the caller. Storage and the
+// cache aren't involved; req's ImageID is only used for logging.
+func (s *ImageService) ProcessUpload(ctx context.Context, imageBytes []byte, req ProcessRequest) (*ProcessResult, error) {
+	preset, err := s.presets.Get(req.Preset)
+	if err != nil {
+		return nil, wrapError(err, nil)
+	}
+	text, err := req.WatermarkText(preset)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := stageContext(ctx, s.timeoutsFor(ctx).Request)
+	defer cancel()
+
+	if err := ratelimit.ChargeRender(ctx); err != nil {
+		return nil, wrapError(err, nil)
+	}
+	processedImage, err := s.watermark(ctx, req.ImageID, imageBytes, s.textWatermark(text, preset))
+	if err != nil {
+		return nil, err
+	}
+	s.logFor(ctx).WithField("bytes", len(imageBytes)).Info("Watermarked uploaded image")
+	return &ProcessResult{Data: processedImage, ContentType: preset.ContentType()}, nil
+}
+
+// refreshInBackground re-renders a stale entry, ensuring only one refresh per key runs at a time.
+func (s *ImageService) refreshInBackground(imageKey, cacheKey, contentType string, draw drawFunc) {
+	s.mu.Lock()
+	if _, ok := s.refreshing[cacheKey]; ok {
+		s.mu.Unlock()
+		return
+	}
+	s.refreshing[cacheKey] = struct{}{}
+	s.mu.Unlock()
 
-	// 4. Store in cache for future requests (async)
 	go func() {
-		if err := s.cache.Set(context.Background(), cacheKey, processedImage); err != nil {
-			s.log.WithError(err).WithField("cache_key", cacheKey).Error("Failed to set cache")
+		defer func() {
+			s.mu.Lock()
+			delete(s.refreshing, cacheKey)
+			s.mu.Unlock()
+		}()
+
+		// The refresh outlives the request that triggered it, so it gets a fresh budget.
+		ctx, cancel := stageContext(context.Background(), s.timeouts.Request)
+		defer cancel()
+
+		processedImage, info, err := s.render(ctx, imageKey, draw)
+		if err != nil {
+			s.log.WithError(err).WithField("cache_key", cacheKey).Error("Background refresh failed")
+			return
 		}
+		s.storeRender(ctx, cacheKey, imageKey, processedImage, newVersionRecord(info, processedImage, contentType))
 	}()
+}
 
-	return processedImage, nil
+// aborted records a request that ran out of time or was canceled during stage
+// and returns the matching error.
+func (s *ImageService) aborted(ctx context.Context, stage, key string) error {
+	s.recordAbort(ctx, ctx, stage, key)
+	return wrapError(fmt.Errorf("%s aborted: %w", stage, ctx.Err()), nil)
+}
+
+// recordAbort counts and logs stage failures caused by a deadline or cancellation.
+// Client cancellations are logged separately from timeouts, as they aren't failures on our side.
+func (s *ImageService) recordAbort(ctx, stageCtx context.Context, stage, key string) {
+	log := s.log.WithField("stage", stage).WithField("key", key)
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled):
+		processAborted.WithLabelValues(stage, "canceled").Inc()
+		log.Info("Request canceled by client")
+	case errors.Is(stageCtx.Err(), context.DeadlineExceeded):
+		processAborted.WithLabelValues(stage, "timeout").Inc()
+		log.Warn("Stage deadline exceeded")
+	}
 }
@@ -0,0 +1,314 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/image/font/gofont/goregular"
+
+	"watermark/internal/locale"
+	"watermark/internal/measure"
+	"watermark/internal/presets"
+	"watermark/internal/processor"
+	"watermark/internal/storage"
+)
+
+// fakeStorage serves one original image, or fails with err.
+type fakeStorage struct {
+	mu      sync.Mutex
+	data    []byte
+	etag    string
+	err     error
+	statErr error
+	calls   int
+	stats   int
+}
+
+func (s *fakeStorage) Get(ctx context.Context, key string) ([]byte, *storage.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	if s.err != nil {
+		return nil, nil, s.err
+	}
+	return s.data, &storage.ObjectInfo{ETag: s.etag}, nil
+}
+
+func (s *fakeStorage) Stat(ctx context.Context, key, ifNoneMatch string) (*storage.ObjectInfo, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.stats++
+	switch {
+	case s.statErr != nil:
+		return nil, s.statErr
+	case s.err != nil:
+		return nil, s.err
+	case ifNoneMatch != "" && ifNoneMatch == s.etag:
+		return nil, storage.ErrNotModified
+	}
+	return &storage.ObjectInfo{ETag: s.etag}, nil
+}
+
+func (s *fakeStorage) fetches() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.calls
+}
+
+// fakeCache keeps entries in a map and reports each write on sets.
+type fakeCache struct {
+	mu      sync.Mutex
+	entries map[string]*storage.CacheEntry
+	sets    chan string
+}
+
+func newFakeCache() *fakeCache {
+	return &fakeCache{entries: make(map[string]*storage.CacheEntry), sets: make(chan string, 8)}
+}
+
+func (c *fakeCache) put(key string, entry *storage.CacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[key] = entry
+}
+
+func (c *fakeCache) Get(ctx context.Context, key string) ([]byte, error) {
+	entry, _ := c.GetEntry(ctx, key)
+	if entry == nil || entry.Stale {
+		return nil, nil
+	}
+	return entry.Data, nil
+}
+
+func (c *fakeCache) GetEntry(ctx context.Context, key string) (*storage.CacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.entries[key], nil
+}
+
+func (c *fakeCache) Set(ctx context.Context, key string, data []byte, tags ...string) error {
+	c.put(key, &storage.CacheEntry{Data: data})
+	c.sets <- key
+	return nil
+}
+
+func (c *fakeCache) Purge(ctx context.Context, tag string) (int, error) { return 0, nil }
+
+func (c *fakeCache) PurgePrefix(ctx context.Context, prefix string) (int, error) { return 0, nil }
+
+// waitForSet waits for key to be written, which happens off the request path.
+func (c *fakeCache) waitForSet(t *testing.T, key string) {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case written := <-c.sets:
+			if written == key {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("%s was not written to the cache", key)
+		}
+	}
+}
+
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 48))
+	for i := range img.Pix {
+		img.Pix[i] = 0x80
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func newTestService(t *testing.T, store storage.ImageStorage, cache storage.ImageCache, policy CachePolicy) *ImageService {
+	t.Helper()
+	proc, err := processor.NewWatermarkProcessor(goregular.TTF, 12, color.White, 80, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	writer := NewCacheWriter(cache, CacheWriterConfig{QueueSize: 8, Workers: 1}, logger)
+	t.Cleanup(func() { writer.Close(context.Background()) })
+	return NewImageService(store, cache, nil, writer, proc, testPresets(t), testLocales(t), nil, false, nil, policy, Timeouts{}, logger)
+}
+
+func TestProcessImageStale(t *testing.T) {
+	policy := CachePolicy{StaleWhileRevalidate: time.Minute, StaleIfError: time.Hour}
+	cached := []byte("cached render")
+	originDown := errors.New("origin down")
+
+	tests := []struct {
+		name        string
+		entry       *storage.CacheEntry
+		originErr   error
+		wantStatus  string
+		wantCached  bool
+		wantErr     bool
+		wantRefresh bool
+	}{
+		{
+			name:       "fresh hit",
+			entry:      &storage.CacheEntry{Data: cached},
+			wantStatus: CacheHit,
+			wantCached: true,
+		},
+		{
+			name:        "stale within stale-while-revalidate",
+			entry:       &storage.CacheEntry{Data: cached, Stale: true, StaleFor: 30 * time.Second},
+			wantStatus:  CacheStale,
+			wantCached:  true,
+			wantRefresh: true,
+		},
+		{
+			name:       "stale past revalidation is rendered again",
+			entry:      &storage.CacheEntry{Data: cached, Stale: true, StaleFor: 10 * time.Minute},
+			wantStatus: CacheMiss,
+		},
+		{
+			name:       "stale-if-error when the origin fails",
+			entry:      &storage.CacheEntry{Data: cached, Stale: true, StaleFor: 10 * time.Minute},
+			originErr:  originDown,
+			wantStatus: CacheStale,
+			wantCached: true,
+		},
+		{
+			name:      "too stale to cover an error",
+			entry:     &storage.CacheEntry{Data: cached, Stale: true, StaleFor: 2 * time.Hour},
+			originErr: originDown,
+			wantErr:   true,
+		},
+		{
+			name:      "miss when the origin fails",
+			originErr: originDown,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := ProcessRequest{ImageID: "a.jpg", Weight: 1, Dimensions: measure.Dimensions{1, 1, 1}}
+			store := &fakeStorage{data: testJPEG(t), err: tt.originErr}
+			cache := newFakeCache()
+			svc := newTestService(t, store, cache, policy)
+			key := testCacheKey(t, svc, req)
+			if tt.entry != nil {
+				cache.put(key, tt.entry)
+			}
+
+			res, err := svc.ProcessImage(context.Background(), req)
+			if tt.wantErr {
+				if !errors.Is(err, originDown) {
+					t.Fatalf("ProcessImage() = %v, %v; want the origin's error", res, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ProcessImage() = %v", err)
+			}
+			if res.CacheStatus != tt.wantStatus {
+				t.Errorf("CacheStatus = %s, want %s", res.CacheStatus, tt.wantStatus)
+			}
+			if got := bytes.Equal(res.Data, cached); got != tt.wantCached {
+				t.Errorf("served the cached copy: %v, want %v", got, tt.wantCached)
+			}
+			if tt.wantRefresh || tt.wantStatus == CacheMiss {
+				cache.waitForSet(t, key)
+			}
+			if tt.wantStatus == CacheHit && store.fetches() != 0 {
+				t.Errorf("fresh hit fetched the original %d times", store.fetches())
+			}
+		})
+	}
+}
+
+func TestRefreshInBackgroundRunsOncePerKey(t *testing.T) {
+	req := ProcessRequest{ImageID: "a.jpg", Weight: 1, Dimensions: measure.Dimensions{1, 1, 1}}
+	store := &fakeStorage{data: testJPEG(t)}
+	cache := newFakeCache()
+	svc := newTestService(t, store, cache, CachePolicy{StaleWhileRevalidate: time.Minute})
+	key := testCacheKey(t, svc, req)
+	cache.put(key, &storage.CacheEntry{Data: []byte("cached"), Stale: true, StaleFor: time.Second})
+
+	// Hold the storage lock so the first refresh can't finish while the
+	// others are requested.
+	store.mu.Lock()
+	for i := 0; i < 5; i++ {
+		if _, err := svc.ProcessImage(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.mu.Unlock()
+	cache.waitForSet(t, key)
+
+	if n := store.fetches(); n != 1 {
+		t.Errorf("stale requests started %d refreshes, want 1", n)
+	}
+}
+
+// testPresets returns a registry holding only the default preset.
+func testPresets(t *testing.T) *presets.Registry {
+	t.Helper()
+	fontPath := filepath.Join(t.TempDir(), "font.ttf")
+	if err := os.WriteFile(fontPath, goregular.TTF, 0600); err != nil {
+		t.Fatal(err)
+	}
+	margin, opacity := 10, 1.0
+	registry, err := presets.Load("", presets.Definition{
+		Font:      fontPath,
+		FontSize:  12,
+		Color:     "#FFFFFF",
+		Placement: "bottom",
+		Margin:    &margin,
+		Opacity:   &opacity,
+		Format:    processor.FormatJPEG,
+		Quality:   80,
+
+		WeightUnit:    string(measure.Kilogram),
+		DimensionUnit: string(measure.Centimetre),
+		Locale:        "en",
+
+		Text:              presets.DefaultText,
+		VolumetricDivisor: "5000",
+	}, testLocales(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+// testCacheKey returns the cache key the service uses for req.
+func testCacheKey(t *testing.T, svc *ImageService, req ProcessRequest) string {
+	t.Helper()
+	r, err := svc.resolve(context.Background(), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r.cacheKey
+}
+
+// testLocales returns the built-in locales.
+func testLocales(t *testing.T) *locale.Catalog {
+	t.Helper()
+	locales, err := locale.Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return locales
+}
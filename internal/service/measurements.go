@@ -0,0 +1,49 @@
+package service
+
+import (
+	"context"
+
+	"watermark/internal/measure"
+)
+
+// Measurements are the weights behind a request's overlay.
+type Measurements struct {
+	Preset           string
+	Locale           string
+	Weight           measure.Weight
+	Dimensions       measure.Dimensions
+	VolumetricWeight measure.Weight
+	// ChargeableWeight is the greater of Weight and VolumetricWeight.
+	ChargeableWeight measure.Weight
+	Divisor          measure.Divisor
+	// Vars are the template variables the overlay is drawn from.
+	Vars map[string]string
+}
+
+// Measure works out req's volumetric and chargeable weight with its preset's
+// divisor, without fetching or rendering the image, completing req with the
+// image's stored metadata like a render would. Either of the weight and
+// dimensions may be missing, in which case the derived weights are zero.
+func (s *ImageService) Measure(ctx context.Context, req ProcessRequest) (*Measurements, error) {
+	preset, err := s.presets.Get(req.Preset)
+	if err != nil {
+		return nil, wrapError(err, nil)
+	}
+	req, _, err = s.withMetadata(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	m := &Measurements{
+		Preset:     preset.Name,
+		Locale:     preset.Localize(req.Locale).Tag,
+		Weight:     req.Weight,
+		Dimensions: req.Dimensions,
+		Divisor:    preset.Divisor,
+		Vars:       req.Vars(preset),
+	}
+	if req.Weight > 0 && !req.Dimensions.IsZero() {
+		m.VolumetricWeight = req.Dimensions.Volumetric(preset.Divisor)
+		m.ChargeableWeight = measure.Chargeable(req.Weight, req.Dimensions, preset.Divisor)
+	}
+	return m, nil
+}
@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"watermark/internal/measure"
+	"watermark/internal/storage"
+)
+
+// UsesMetadata reports whether requests for stored images may leave out
+// values that the image's stored metadata provides.
+func (s *ImageService) UsesMetadata() bool {
+	return s.metadata != nil
+}
+
+// withMetadata completes req with the metadata stored with its image, and
+// returns the metadata's version, which is empty if there is none. Stored
+// weight, dimensions and date win over req's unless overrides are allowed, in
+// which case they only fill in what req leaves out; the same goes for fields.
+// Every stored field is available to the preset's text.
+func (s *ImageService) withMetadata(ctx context.Context, req ProcessRequest) (ProcessRequest, string, error) {
+	if s.metadata == nil || req.ImageID == "" {
+		return req, "", nil
+	}
+	ctx, cancel := stageContext(ctx, s.timeoutsFor(ctx).OriginFetch)
+	defer cancel()
+	md, err := s.metadata.Get(ctx, req.ImageID)
+	if err != nil {
+		return req, "", wrapError(err, ErrUpstream)
+	}
+
+	if v, ok := md.Fields["weight"]; ok && (!s.overrideMetadata || req.Weight <= 0) {
+		w, err := measure.ParseWeight(v, measure.Kilogram)
+		if err != nil {
+			return req, "", invalidMetadata(req.ImageID, err)
+		}
+		req.Weight = w
+	}
+	if v, ok := md.Fields["dimensions"]; ok && (!s.overrideMetadata || req.Dimensions.IsZero()) {
+		d, err := measure.ParseDimensions(v, measure.Centimetre)
+		if err != nil {
+			return req, "", invalidMetadata(req.ImageID, err)
+		}
+		req.Dimensions = d
+	}
+	if v, ok := md.Fields["date"]; ok && (!s.overrideMetadata || req.Date.IsZero()) {
+		d, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			return req, "", invalidMetadata(req.ImageID, fmt.Errorf("date: %v", err))
+		}
+		req.Date = d
+	}
+	fields := make(map[string]string, len(md.Fields)+len(req.Fields))
+	first, second := req.Fields, md.Fields
+	if s.overrideMetadata {
+		first, second = second, first
+	}
+	for k, v := range first {
+		fields[k] = v
+	}
+	for k, v := range second {
+		fields[k] = v
+	}
+	req.Fields = fields
+	return req, md.Version, nil
+}
+
+func invalidMetadata(imageID string, err error) error {
+	return &Error{Kind: ErrInvalidMetadata, Err: fmt.Errorf("%w for %s: %v", storage.ErrInvalidMetadata, imageID, err)}
+}
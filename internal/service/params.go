@@ -0,0 +1,80 @@
+package service
+
+import (
+	"errors"
+	"net/url"
+
+	"watermark/internal/measure"
+)
+
+// RenderParams are the parameters of an image render as clients give them,
+// either per image or as defaults for several, as in /batch, /admin/warm and
+// /jobs. Unset values are nil or empty.
+type RenderParams struct {
+	Weight     *measure.Weight     `json:"weight,omitempty"`
+	Dimensions *measure.Dimensions `json:"dimensions,omitempty"`
+	Preset     string              `json:"preset,omitempty"`
+	Lang       string              `json:"lang,omitempty"`
+}
+
+// ParseRenderParams reads the weight, dimensions, preset and lang query
+// parameters. Weights without a unit are in kilograms, and dimensions without
+// one in centimetres. Parameters that are absent are left unset.
+func ParseRenderParams(values url.Values) (RenderParams, error) {
+	p := RenderParams{Preset: values.Get("preset"), Lang: values.Get("lang")}
+	if v := values.Get("weight"); v != "" {
+		weight, err := measure.ParseWeight(v, measure.Kilogram)
+		if err != nil {
+			return RenderParams{}, err
+		}
+		p.Weight = &weight
+	}
+	if v := values.Get("dimensions"); v != "" {
+		dimensions, err := measure.ParseDimensions(v, measure.Centimetre)
+		if err != nil {
+			return RenderParams{}, err
+		}
+		p.Dimensions = &dimensions
+	}
+	return p, nil
+}
+
+// WithDefaults returns p with its unset values taken from defaults.
+func (p RenderParams) WithDefaults(defaults RenderParams) RenderParams {
+	if p.Weight == nil {
+		p.Weight = defaults.Weight
+	}
+	if p.Dimensions == nil {
+		p.Dimensions = defaults.Dimensions
+	}
+	if p.Preset == "" {
+		p.Preset = defaults.Preset
+	}
+	if p.Lang == "" {
+		p.Lang = defaults.Lang
+	}
+	return p
+}
+
+// Request validates p and turns it into a request for imageID. With stored,
+// weight and dimensions may be left out for the image's stored metadata to
+// provide.
+func (p RenderParams) Request(imageID string, stored bool) (ProcessRequest, error) {
+	if imageID == "" {
+		return ProcessRequest{}, errors.New("missing image_id")
+	}
+	if p.Weight == nil && !stored {
+		return ProcessRequest{}, &measure.ParseError{Field: "weight"}
+	}
+	if p.Dimensions == nil && !stored {
+		return ProcessRequest{}, &measure.ParseError{Field: "dimensions"}
+	}
+	req := ProcessRequest{ImageID: imageID, Preset: p.Preset, Locale: p.Lang}
+	if p.Weight != nil {
+		req.Weight = *p.Weight
+	}
+	if p.Dimensions != nil {
+		req.Dimensions = *p.Dimensions
+	}
+	return req, nil
+}
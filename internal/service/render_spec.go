@@ -0,0 +1,143 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"watermark/internal/processor"
+	"watermark/internal/storage"
+)
+
+// RenderRequest describes a render driven by a multi-layer spec.
+type RenderRequest struct {
+	ImageID string
+	Spec    processor.RenderSpec
+	// Vars are the values available to the spec's text templates.
+	Vars map[string]string
+	// Locale is the locale Vars were formatted in.
+	Locale string
+}
+
+// cacheKey identifies the render in the cache. It starts with the image ID so
+// purges by image or prefix also cover spec renders. overlays maps each
+// overlay key to its current ETag, so replacing an overlay changes the key.
+func (r RenderRequest) cacheKey(overlays map[string]string) (string, error) {
+	spec, err := json.Marshal(r.Spec)
+	if err != nil {
+		return "", err
+	}
+	// Map keys are marshaled in sorted order, so equal vars hash equally.
+	vars, err := json.Marshal(r.Vars)
+	if err != nil {
+		return "", err
+	}
+	versions, err := json.Marshal(overlays)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, part := range [][]byte{spec, vars, versions} {
+		h.Write(part)
+		h.Write([]byte{'\n'})
+	}
+	return fmt.Sprintf("%s-spec-%s-%s", r.ImageID, r.Locale, hex.EncodeToString(h.Sum(nil)[:16])), nil
+}
+
+// ProcessSpec renders req's spec over the image, sharing the cache, stale
+// serving and revalidation of ProcessImage.
+func (s *ImageService) ProcessSpec(ctx context.Context, req RenderRequest) (*ProcessResult, error) {
+	if err := req.Spec.Validate(); err != nil {
+		return nil, wrapError(err, nil)
+	}
+	if err := s.checkOverlays(req.Spec); err != nil {
+		return nil, wrapError(err, nil)
+	}
+	overlays, err := s.overlayVersions(ctx, req.Spec)
+	if err != nil {
+		return nil, err
+	}
+	cacheKey, err := req.cacheKey(overlays)
+	if err != nil {
+		return nil, wrapError(fmt.Errorf("failed to build cache key: %w", err), nil)
+	}
+	return s.process(ctx, req.ImageID, cacheKey, processor.ContentType(processor.FormatJPEG), s.compose(req))
+}
+
+// checkOverlays rejects overlay keys outside the configured prefixes, so a
+// spec can't draw arbitrary objects from the bucket onto an image.
+func (s *ImageService) checkOverlays(spec processor.RenderSpec) error {
+	for _, key := range spec.Overlays() {
+		if !s.overlayAllowed(key) {
+			return fmt.Errorf("%w: overlay %q is not under an allowed prefix", processor.ErrInvalidSpec, key)
+		}
+	}
+	return nil
+}
+
+func (s *ImageService) overlayAllowed(key string) bool {
+	// Keys that aren't already clean, such as "overlays/../private.png", could
+	// resolve outside the prefix on some backends.
+	if path.Clean("/"+key) != "/"+key {
+		return false
+	}
+	for _, prefix := range s.overlayPrefixes {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// overlayVersions returns the current ETag of each overlay the spec uses.
+func (s *ImageService) overlayVersions(ctx context.Context, spec processor.RenderSpec) (map[string]string, error) {
+	keys := spec.Overlays()
+	if len(keys) == 0 {
+		return nil, nil
+	}
+	statCtx, cancel := stageContext(ctx, s.timeoutsFor(ctx).OriginFetch)
+	defer cancel()
+
+	versions := make(map[string]string, len(keys))
+	for _, key := range keys {
+		if _, ok := versions[key]; ok {
+			continue
+		}
+		info, err := s.storage.Stat(statCtx, key, "")
+		if errors.Is(err, storage.ErrNotFound) {
+			return nil, wrapError(fmt.Errorf("%w: overlay %q not found", processor.ErrInvalidSpec, key), nil)
+		}
+		if err != nil {
+			return nil, wrapError(fmt.Errorf("failed to stat overlay %q: %w", key, err), ErrUpstream)
+		}
+		versions[key] = info.ETag
+	}
+	return versions, nil
+}
+
+// compose loads the spec's overlay images and draws its layers.
+func (s *ImageService) compose(req RenderRequest) drawFunc {
+	return func(ctx context.Context, originalImage []byte) ([]byte, error) {
+		overlays := make(map[string][]byte)
+		for _, key := range req.Spec.Overlays() {
+			if _, ok := overlays[key]; ok {
+				continue
+			}
+			data, _, err := s.storage.Get(ctx, key)
+			if errors.Is(err, storage.ErrNotFound) {
+				// The spec names an overlay that doesn't exist, which is the caller's mistake.
+				return nil, fmt.Errorf("%w: overlay %q not found", processor.ErrInvalidSpec, key)
+			}
+			if err != nil {
+				return nil, wrapError(fmt.Errorf("failed to get overlay %q from storage: %w", key, err), ErrUpstream)
+			}
+			overlays[key] = data
+		}
+		return s.processor.Compose(ctx, originalImage, req.Spec, req.Vars, overlays)
+	}
+}
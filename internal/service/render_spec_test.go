@@ -0,0 +1,44 @@
+package service
+
+import "testing"
+
+func TestOverlayAllowed(t *testing.T) {
+	s := &ImageService{overlayPrefixes: []string{"overlays/", "brand/logos/"}}
+
+	tests := []struct {
+		key  string
+		want bool
+	}{
+		{key: "overlays/acme.png", want: true},
+		{key: "brand/logos/2024/acme.png", want: true},
+		{key: "private/invoice.png", want: false},
+		{key: "overlays/../private/invoice.png", want: false},
+		{key: "overlays//acme.png", want: false},
+		{key: "overlays/./acme.png", want: false},
+		{key: "brand/acme.png", want: false},
+	}
+	for _, tt := range tests {
+		if got := s.overlayAllowed(tt.key); got != tt.want {
+			t.Errorf("overlayAllowed(%q) = %v, want %v", tt.key, got, tt.want)
+		}
+	}
+
+	if (&ImageService{}).overlayAllowed("overlays/acme.png") {
+		t.Error("without prefixes, an overlay was allowed")
+	}
+}
+
+func TestRenderCacheKeyCoversOverlayVersions(t *testing.T) {
+	req := RenderRequest{ImageID: "a.jpg"}
+	v1, err := req.cacheKey(map[string]string{"overlays/acme.png": `"v1"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := req.cacheKey(map[string]string{"overlays/acme.png": `"v2"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v1 == v2 {
+		t.Errorf("replacing an overlay kept the cache key %s", v1)
+	}
+}
@@ -0,0 +1,193 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"watermark/internal/storage"
+)
+
+var originRevalidations = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "image_origin_revalidations_total",
+	Help: "The total number of cached renders checked against their origin object, by result.",
+}, []string{"result"})
+
+// versionRecord is cached next to each render and describes the origin object
+// it was made from, along with the render's own length, type and creation
+// time, so HEAD requests can be answered from the record alone.
+type versionRecord struct {
+	storage.ObjectInfo
+	ContentType   string    `json:"content_type,omitempty"`
+	ContentLength int64     `json:"content_length,omitempty"`
+	RenderedAt    time.Time `json:"rendered_at,omitempty"`
+	ValidatedAt   time.Time `json:"validated_at"`
+}
+
+// newVersionRecord describes a render made just now from the origin version
+// info, or, with no data, the render that version would give. It returns nil
+// if the version isn't known.
+func newVersionRecord(info *storage.ObjectInfo, data []byte, contentType string) *versionRecord {
+	if info == nil {
+		return nil
+	}
+	rec := &versionRecord{ObjectInfo: *info, ContentType: contentType, ContentLength: int64(len(data))}
+	if data != nil {
+		rec.RenderedAt = time.Now().UTC()
+	}
+	return rec
+}
+
+func versionKey(cacheKey string) string {
+	return cacheKey + storage.VersionRecordSuffix
+}
+
+// ImageVersion identifies a render for HTTP cache validation.
+type ImageVersion struct {
+	// ETag is a strong entity tag derived from the render spec and the origin object's version.
+	ETag string
+	// LastModified is when the render was made, or zero if that isn't known.
+	// The origin object's own time wouldn't do: a changed preset, locale or
+	// text gives a new render of an unchanged object.
+	LastModified time.Time
+	// ContentType and ContentLength describe the render. ContentLength is
+	// zero if the render's version record predates them.
+	ContentType   string
+	ContentLength int64
+}
+
+// imageVersion derives the version of the render the record belongs to from
+// the origin object it was made from; r may be nil.
+func (r *versionRecord) imageVersion(cacheKey string) *ImageVersion {
+	if r == nil || r.ETag == "" {
+		return nil
+	}
+	hash := sha256.Sum256([]byte(cacheKey + "\n" + r.ETag + "\n" + strconv.FormatInt(r.LastModified.Unix(), 10)))
+	return &ImageVersion{
+		ETag:          `"` + hex.EncodeToString(hash[:16]) + `"`,
+		LastModified:  r.RenderedAt,
+		ContentType:   r.ContentType,
+		ContentLength: r.ContentLength,
+	}
+}
+
+// Version returns the version of the render for req without loading the render
+// itself, or nil if it isn't known. Like a cache hit, it checks the origin for
+// changes once the revalidation window has passed.
+func (s *ImageService) Version(ctx context.Context, req ProcessRequest) *ImageVersion {
+	ctx, cancel := stageContext(ctx, s.timeoutsFor(ctx).Request)
+	defer cancel()
+
+	res, err := s.resolve(ctx, req)
+	if err != nil {
+		return nil
+	}
+	cacheKey := res.cacheKey
+	rec := s.loadVersion(ctx, cacheKey)
+	if rec == nil || s.originChanged(ctx, req.ImageID, cacheKey, rec) {
+		return nil
+	}
+	return rec.imageVersion(cacheKey)
+}
+
+// storeVersion records which origin version a render was made from, marking it
+// validated now. A nil rec is ignored.
+func (s *ImageService) storeVersion(ctx context.Context, cacheKey, imageID string, rec *versionRecord) {
+	if write, ok := s.versionWrite(cacheKey, imageID, rec); ok {
+		s.writer.EnqueueGroup(ctx, write)
+	}
+}
+
+// storeRender caches a render together with its version record, so the
+// write-behind queue never keeps one without the other.
+func (s *ImageService) storeRender(ctx context.Context, cacheKey, imageID string, data []byte, rec *versionRecord) {
+	writes := []CacheWrite{{Key: cacheKey, Data: data, Tags: []string{imageID}}}
+	if write, ok := s.versionWrite(cacheKey, imageID, rec); ok {
+		writes = append(writes, write)
+	}
+	s.writer.EnqueueGroup(ctx, writes...)
+}
+
+// versionWrite encodes rec, marked validated now, as a cache write. It
+// reports false for a nil rec or one that can't be encoded.
+func (s *ImageService) versionWrite(cacheKey, imageID string, rec *versionRecord) (CacheWrite, bool) {
+	if rec == nil {
+		return CacheWrite{}, false
+	}
+	validated := *rec
+	validated.ValidatedAt = time.Now().UTC()
+	data, err := json.Marshal(validated)
+	if err != nil {
+		s.log.WithError(err).WithField("cache_key", cacheKey).Error("Failed to encode version record")
+		return CacheWrite{}, false
+	}
+	return CacheWrite{Key: versionKey(cacheKey), Data: data, Tags: []string{imageID}}, true
+}
+
+// loadVersion returns the version record for a render, or nil if there is none.
+func (s *ImageService) loadVersion(ctx context.Context, cacheKey string) *versionRecord {
+	lookupCtx, cancel := stageContext(ctx, s.timeoutsFor(ctx).CacheLookup)
+	defer cancel()
+
+	data, err := s.cache.Get(lookupCtx, versionKey(cacheKey))
+	if err != nil {
+		s.log.WithError(err).WithField("cache_key", cacheKey).Warn("Failed to load version record")
+		return nil
+	}
+	if data == nil {
+		return nil
+	}
+	var rec versionRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		s.log.WithError(err).WithField("cache_key", cacheKey).Warn("Corrupt version record")
+		return nil
+	}
+	return &rec
+}
+
+// originChanged reports whether the origin object behind a cached render with
+// version record rec has changed. It only asks the origin once the render's
+// freshness window has passed, using a conditional request. If the origin can't
+// be reached, the cached render is assumed to still be current.
+func (s *ImageService) originChanged(ctx context.Context, imageID, cacheKey string, rec *versionRecord) bool {
+	if s.policy.RevalidateAfter <= 0 {
+		return false
+	}
+	if rec == nil || rec.ETag == "" || time.Since(rec.ValidatedAt) < s.policy.RevalidateAfter {
+		return false
+	}
+
+	statCtx, cancel := stageContext(ctx, s.timeoutsFor(ctx).OriginFetch)
+	defer cancel()
+	info, err := s.storage.Stat(statCtx, imageID, rec.ETag)
+
+	log := s.log.WithField("cache_key", cacheKey).WithField("etag", rec.ETag)
+	switch {
+	case errors.Is(err, storage.ErrNotModified) || (err == nil && info.ETag == rec.ETag):
+		originRevalidations.WithLabelValues("unchanged").Inc()
+		log.Debug("Origin unchanged")
+		s.storeVersion(ctx, cacheKey, imageID, rec)
+		return false
+	case err != nil:
+		originRevalidations.WithLabelValues("error").Inc()
+		log.WithError(err).Warn("Failed to revalidate against origin, serving cached render")
+		return false
+	}
+
+	originRevalidations.WithLabelValues("changed").Inc()
+	log.WithField("new_etag", info.ETag).Info("Origin changed, re-rendering")
+	// A cached original would be just as outdated as the render.
+	if p, ok := s.storage.(storage.Purger); ok {
+		if _, err := p.Purge(ctx, imageID); err != nil {
+			log.WithError(err).Warn("Failed to purge cached original")
+		}
+	}
+	return true
+}
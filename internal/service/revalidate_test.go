@@ -0,0 +1,119 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"watermark/internal/measure"
+	"watermark/internal/storage"
+)
+
+func TestOriginRevalidation(t *testing.T) {
+	policy := CachePolicy{RevalidateAfter: time.Minute}
+	req := ProcessRequest{ImageID: "a.jpg", Weight: 1, Dimensions: measure.Dimensions{1, 1, 1}}
+	cached := []byte("cached render")
+	key := testCacheKey(t, newTestService(t, &fakeStorage{}, newFakeCache(), policy), req)
+
+	// setup caches a fresh render made from version v1 of the origin object,
+	// last checked validatedAgo.
+	setup := func(t *testing.T, store *fakeStorage, validatedAgo time.Duration) *fakeCache {
+		t.Helper()
+		cache := newFakeCache()
+		cache.put(key, &storage.CacheEntry{Data: cached})
+		rec, err := json.Marshal(versionRecord{
+			ObjectInfo:  storage.ObjectInfo{ETag: "v1"},
+			ValidatedAt: time.Now().Add(-validatedAgo),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		cache.put(versionKey(key), &storage.CacheEntry{Data: rec})
+		return cache
+	}
+	version := func(t *testing.T, cache *fakeCache) versionRecord {
+		t.Helper()
+		var rec versionRecord
+		data, _ := cache.Get(context.Background(), versionKey(key))
+		if err := json.Unmarshal(data, &rec); err != nil {
+			t.Fatalf("version record: %v", err)
+		}
+		return rec
+	}
+
+	t.Run("a miss records the origin version", func(t *testing.T) {
+		store := &fakeStorage{data: testJPEG(t), etag: "v1"}
+		cache := newFakeCache()
+		svc := newTestService(t, store, cache, policy)
+
+		if _, err := svc.ProcessImage(context.Background(), req); err != nil {
+			t.Fatal(err)
+		}
+		cache.waitForSet(t, versionKey(key))
+		if rec := version(t, cache); rec.ETag != "v1" {
+			t.Errorf("recorded ETag %q, want v1", rec.ETag)
+		}
+		if store.stats != 0 || store.fetches() != 1 {
+			t.Errorf("origin checked %d times and fetched %d, want the version from the fetch itself", store.stats, store.fetches())
+		}
+	})
+
+	t.Run("recently validated renders are trusted", func(t *testing.T) {
+		store := &fakeStorage{data: testJPEG(t), etag: "v2"}
+		svc := newTestService(t, store, setup(t, store, 10*time.Second), policy)
+
+		res, err := svc.ProcessImage(context.Background(), req)
+		if err != nil || res.CacheStatus != CacheHit {
+			t.Fatalf("ProcessImage() = %v, %v; want a hit", res, err)
+		}
+		if store.stats != 0 {
+			t.Errorf("origin was checked %d times, want 0", store.stats)
+		}
+	})
+
+	t.Run("unchanged origin extends the render", func(t *testing.T) {
+		store := &fakeStorage{data: testJPEG(t), etag: "v1"}
+		cache := setup(t, store, 2*time.Minute)
+		svc := newTestService(t, store, cache, policy)
+
+		res, err := svc.ProcessImage(context.Background(), req)
+		if err != nil || res.CacheStatus != CacheHit || !bytes.Equal(res.Data, cached) {
+			t.Fatalf("ProcessImage() = %v, %v; want the cached render", res, err)
+		}
+		if store.stats != 1 || store.fetches() != 0 {
+			t.Errorf("origin checked %d times and fetched %d, want 1 and 0", store.stats, store.fetches())
+		}
+		cache.waitForSet(t, versionKey(key))
+		if rec := version(t, cache); time.Since(rec.ValidatedAt) > time.Minute {
+			t.Errorf("version record validated at %v, want now", rec.ValidatedAt)
+		}
+	})
+
+	t.Run("changed origin is rendered again", func(t *testing.T) {
+		store := &fakeStorage{data: testJPEG(t), etag: "v2"}
+		cache := setup(t, store, 2*time.Minute)
+		svc := newTestService(t, store, cache, policy)
+
+		res, err := svc.ProcessImage(context.Background(), req)
+		if err != nil || res.CacheStatus != CacheMiss || bytes.Equal(res.Data, cached) {
+			t.Fatalf("ProcessImage() = %v, %v; want a fresh render", res, err)
+		}
+		cache.waitForSet(t, versionKey(key))
+		if rec := version(t, cache); rec.ETag != "v2" {
+			t.Errorf("recorded ETag %q, want v2", rec.ETag)
+		}
+	})
+
+	t.Run("unreachable origin serves the cached render", func(t *testing.T) {
+		store := &fakeStorage{data: testJPEG(t), etag: "v2", statErr: errors.New("origin down")}
+		svc := newTestService(t, store, setup(t, store, 2*time.Minute), policy)
+
+		res, err := svc.ProcessImage(context.Background(), req)
+		if err != nil || res.CacheStatus != CacheHit || !bytes.Equal(res.Data, cached) {
+			t.Fatalf("ProcessImage() = %v, %v; want the cached render", res, err)
+		}
+	})
+}
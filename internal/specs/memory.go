@@ -0,0 +1,59 @@
+package specs
+
+import (
+	"context"
+	"sync"
+
+	"watermark/internal/processor"
+)
+
+// MemoryStore implements Store in process. Specs are lost on restart and are
+// only visible to the instance that saved them.
+type MemoryStore struct {
+	mu    sync.RWMutex
+	specs map[string]processor.RenderSpec
+}
+
+// NewMemoryStore creates an empty store.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{specs: make(map[string]processor.RenderSpec)}
+}
+
+// Get returns the spec saved under name.
+func (s *MemoryStore) Get(ctx context.Context, name string) (*processor.RenderSpec, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	spec, ok := s.specs[name]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	// Layers is shared with the stored copy, so hand out a copy of it too.
+	spec.Layers = append([]processor.Layer(nil), spec.Layers...)
+	return &spec, nil
+}
+
+// Put saves spec under name, replacing any existing spec.
+func (s *MemoryStore) Put(ctx context.Context, name string, spec processor.RenderSpec) error {
+	if err := CheckName(name); err != nil {
+		return err
+	}
+	spec.Layers = append([]processor.Layer(nil), spec.Layers...)
+
+	s.mu.Lock()
+	s.specs[name] = spec
+	s.mu.Unlock()
+	return nil
+}
+
+// Delete removes the spec saved under name.
+func (s *MemoryStore) Delete(ctx context.Context, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.specs[name]; !ok {
+		return ErrNotFound
+	}
+	delete(s.specs, name)
+	return nil
+}
@@ -0,0 +1,75 @@
+package specs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/redis/go-redis/v9"
+
+	"watermark/internal/config"
+	"watermark/internal/processor"
+)
+
+const redisSpecKeyPrefix = "render-spec:"
+
+// RedisStore implements Store on Redis, so every instance sees the same specs.
+type RedisStore struct {
+	client *redis.Client
+}
+
+// NewRedisStore creates a Redis-backed store. Specs never expire.
+func NewRedisStore(cfg config.RedisConfig) *RedisStore {
+	return &RedisStore{
+		client: redis.NewClient(&redis.Options{
+			Addr:     cfg.Addr,
+			Password: cfg.Password,
+			DB:       cfg.DB,
+		}),
+	}
+}
+
+// Get returns the spec saved under name.
+func (s *RedisStore) Get(ctx context.Context, name string) (*processor.RenderSpec, error) {
+	data, err := s.client.Get(ctx, redisSpecKeyPrefix+name).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("redis GET failed for render spec %s: %w", name, err)
+	}
+
+	var spec processor.RenderSpec
+	if err := json.Unmarshal(data, &spec); err != nil {
+		return nil, fmt.Errorf("failed to decode render spec %s: %w", name, err)
+	}
+	return &spec, nil
+}
+
+// Put saves spec under name, replacing any existing spec.
+func (s *RedisStore) Put(ctx context.Context, name string, spec processor.RenderSpec) error {
+	if err := CheckName(name); err != nil {
+		return err
+	}
+	data, err := json.Marshal(spec)
+	if err != nil {
+		return fmt.Errorf("failed to encode render spec %s: %w", name, err)
+	}
+	if err := s.client.Set(ctx, redisSpecKeyPrefix+name, data, 0).Err(); err != nil {
+		return fmt.Errorf("redis SET failed for render spec %s: %w", name, err)
+	}
+	return nil
+}
+
+// Delete removes the spec saved under name.
+func (s *RedisStore) Delete(ctx context.Context, name string) error {
+	n, err := s.client.Del(ctx, redisSpecKeyPrefix+name).Result()
+	if err != nil {
+		return fmt.Errorf("redis DEL failed for render spec %s: %w", name, err)
+	}
+	if n == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
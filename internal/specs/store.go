@@ -0,0 +1,37 @@
+package specs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"watermark/internal/processor"
+)
+
+var (
+	// ErrNotFound is returned when no spec is stored under a name.
+	ErrNotFound = errors.New("render spec not found")
+	// ErrInvalidName is returned for names that don't match validName.
+	ErrInvalidName = errors.New("invalid render spec name")
+)
+
+// validName keeps names safe to use in URLs and storage keys.
+var validName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// Store keeps named render specs so clients can refer to them instead of
+// sending the full spec with every request.
+type Store interface {
+	Get(ctx context.Context, name string) (*processor.RenderSpec, error)
+	Put(ctx context.Context, name string, spec processor.RenderSpec) error
+	Delete(ctx context.Context, name string) error
+}
+
+// CheckName returns ErrInvalidName unless name is lowercase letters, digits,
+// dashes and underscores, starting with a letter or digit, at most 64 long.
+func CheckName(name string) error {
+	if !validName.MatchString(name) {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+// A negative TTL makes Set store items that expired that long ago, which
+// lets the memory cache be tested at any age without sleeping.
+func TestMemoryCacheGrace(t *testing.T) {
+	ctx := context.Background()
+
+	fresh := NewMemoryCache(1<<20, time.Hour, time.Hour, testLogger())
+	fresh.Set(ctx, "k", []byte("v"))
+	if entry, _ := fresh.GetEntry(ctx, "k"); entry == nil || entry.Stale {
+		t.Fatalf("fresh item: GetEntry() = %+v, want a fresh entry", entry)
+	}
+
+	stale := NewMemoryCache(1<<20, -time.Minute, time.Hour, testLogger())
+	stale.Set(ctx, "k", []byte("v"))
+	entry, _ := stale.GetEntry(ctx, "k")
+	if entry == nil || !entry.Stale || entry.StaleFor < time.Minute {
+		t.Fatalf("item within grace: GetEntry() = %+v, want stale for about a minute", entry)
+	}
+	if data, _ := stale.Get(ctx, "k"); data != nil {
+		t.Errorf("item within grace: Get() = %q, want nil", data)
+	}
+
+	gone := NewMemoryCache(1<<20, -2*time.Hour, time.Hour, testLogger())
+	gone.Set(ctx, "k", []byte("v"))
+	if entry, _ := gone.GetEntry(ctx, "k"); entry != nil {
+		t.Fatalf("item past grace: GetEntry() = %+v, want a miss", entry)
+	}
+	if gone.size != 0 {
+		t.Errorf("item past grace still counts %d bytes", gone.size)
+	}
+}
+
+func TestLocalCacheGrace(t *testing.T) {
+	ctx := context.Background()
+	c, err := NewLocalCache(t.TempDir(), time.Hour, time.Hour, testLogger())
+	if err != nil {
+		t.Fatalf("NewLocalCache: %v", err)
+	}
+
+	age := func(key string, d time.Duration) {
+		t.Helper()
+		when := time.Now().Add(-d)
+		if err := os.Chtimes(c.getFilePath(key), when, when); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c.Set(ctx, "fresh", []byte("v"))
+	c.Set(ctx, "stale", []byte("v"))
+	age("stale", 90*time.Minute)
+	c.Set(ctx, "gone", []byte("v"))
+	age("gone", 3*time.Hour)
+
+	if entry, _ := c.GetEntry(ctx, "fresh"); entry == nil || entry.Stale {
+		t.Errorf("fresh: GetEntry() = %+v, want a fresh entry", entry)
+	}
+	entry, _ := c.GetEntry(ctx, "stale")
+	if entry == nil || !entry.Stale || entry.StaleFor < 29*time.Minute || entry.StaleFor > 31*time.Minute {
+		t.Errorf("stale: GetEntry() = %+v, want stale for 30m", entry)
+	}
+	if data, _ := c.Get(ctx, "stale"); data != nil {
+		t.Errorf("stale: Get() = %q, want nil", data)
+	}
+	if entry, _ := c.GetEntry(ctx, "gone"); entry != nil {
+		t.Errorf("past grace: GetEntry() = %+v, want a miss", entry)
+	}
+	if _, err := os.Stat(c.getFilePath("gone")); !os.IsNotExist(err) {
+		t.Errorf("past grace: file was not removed (%v)", err)
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	local, err := NewLocalCache(t.TempDir(), time.Hour, 0, testLogger())
+	if err != nil {
+		t.Fatalf("NewLocalCache: %v", err)
+	}
+	client, _ := newFakeRedisClient()
+	caches := map[string]ImageCache{
+		"memory": NewMemoryCache(1<<20, time.Hour, 0, testLogger()),
+		"local":  local,
+		"redis":  &RedisCache{client: client, ttl: time.Hour, log: testLogger().WithField("component", "RedisCache")},
+	}
+
+	for name, c := range caches {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			items := map[string]string{
+				"photos/a.jpg:small": "photos/a.jpg",
+				"photos/a.jpg:large": "photos/a.jpg",
+				"photos/b.jpg:small": "photos/b.jpg",
+				"other/c.jpg:small":  "other/c.jpg",
+				// Version records go with their render but aren't counted.
+				"photos/a.jpg:small" + VersionRecordSuffix: "photos/a.jpg",
+			}
+			for key, tag := range items {
+				if err := c.Set(ctx, key, []byte("v"), tag); err != nil {
+					t.Fatalf("Set(%s): %v", key, err)
+				}
+			}
+			// Writing an item again must not count it twice.
+			c.Set(ctx, "photos/a.jpg:small", []byte("v"), "photos/a.jpg")
+
+			purge := func(n int, err error) int {
+				t.Helper()
+				if err != nil {
+					t.Fatal(err)
+				}
+				return n
+			}
+			cached := func(key string) bool {
+				data, _ := c.Get(ctx, key)
+				return data != nil
+			}
+
+			if n := purge(c.Purge(ctx, "photos/a.jpg")); n != 2 {
+				t.Errorf("Purge(photos/a.jpg) = %d, want 2", n)
+			}
+			if cached("photos/a.jpg:small") || cached("photos/a.jpg:large") || cached("photos/a.jpg:small"+VersionRecordSuffix) {
+				t.Error("purged items are still cached")
+			}
+			if !cached("photos/b.jpg:small") {
+				t.Error("Purge removed an item with another tag")
+			}
+			if n := purge(c.Purge(ctx, "photos/a.jpg")); n != 0 {
+				t.Errorf("second Purge(photos/a.jpg) = %d, want 0", n)
+			}
+
+			// Glob characters in a prefix are matched literally.
+			if n := purge(c.PurgePrefix(ctx, "photos*")); n != 0 {
+				t.Errorf("PurgePrefix(photos*) = %d, want 0", n)
+			}
+			if n := purge(c.PurgePrefix(ctx, "photos/")); n != 1 {
+				t.Errorf("PurgePrefix(photos/) = %d, want 1", n)
+			}
+			if cached("photos/b.jpg:small") || !cached("other/c.jpg:small") {
+				t.Error("PurgePrefix(photos/) removed the wrong items")
+			}
+		})
+	}
+}
+
+func TestRedisCacheTagExpiry(t *testing.T) {
+	ctx := context.Background()
+	client, fake := newFakeRedisClient()
+	renders := &RedisCache{client: client, ttl: 24 * time.Hour, log: testLogger().WithField("component", "RedisCache")}
+	originals := &RedisCache{client: client, ttl: time.Hour, log: testLogger().WithField("component", "RedisCache")}
+	tagKey := redisTagPrefix + "a.jpg"
+
+	if err := originals.Set(ctx, "original:a.jpg", []byte("v"), "a.jpg"); err != nil {
+		t.Fatal(err)
+	}
+	if ttl := fake.ttl(tagKey); ttl <= 0 || ttl > time.Hour {
+		t.Fatalf("new tag set TTL = %v, want the entry's hour", ttl)
+	}
+	renders.Set(ctx, "render:a.jpg", []byte("v"), "a.jpg")
+	if ttl := fake.ttl(tagKey); ttl <= time.Hour {
+		t.Fatalf("tag set TTL after a longer-lived entry = %v, want a day", ttl)
+	}
+
+	// A shorter-lived entry written later must not cut the set short, or the
+	// render would outlive its index and escape purges.
+	originals.Set(ctx, "original:a.jpg", []byte("v"), "a.jpg")
+	if ttl := fake.ttl(tagKey); ttl <= time.Hour {
+		t.Errorf("tag set TTL after a shorter-lived entry = %v, want a day", ttl)
+	}
+}
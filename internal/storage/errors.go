@@ -0,0 +1,14 @@
+package storage
+
+import "errors"
+
+var (
+	// ErrNotFound is returned by ImageStorage implementations when the requested object does not exist.
+	ErrNotFound = errors.New("object not found")
+	// ErrTooLarge is returned when the requested object exceeds the configured size limit.
+	ErrTooLarge = errors.New("object too large")
+	// ErrNotModified is returned by Stat when the object still matches the given ETag.
+	ErrNotModified = errors.New("object not modified")
+	// ErrInvalidMetadata is returned by MetadataStore when an image's sidecar can't be used.
+	ErrInvalidMetadata = errors.New("invalid metadata")
+)
@@ -2,17 +2,74 @@ package storage
 
 import (
 	"context"
+	"strings"
+	"time"
 )
 
 // ImageStorage defines the interface for an object storage backend.
 // It is responsible for fetching the original images.
 type ImageStorage interface {
-	Get(ctx context.Context, key string) ([]byte, error)
+	// Get returns the object along with the version it was read at.
+	Get(ctx context.Context, key string) ([]byte, *ObjectInfo, error)
+	// Stat returns the object's current version without fetching it. If
+	// ifNoneMatch is set and the object still has that ETag, it returns ErrNotModified.
+	Stat(ctx context.Context, key, ifNoneMatch string) (*ObjectInfo, error)
+}
+
+// ObjectInfo identifies a version of an origin object.
+type ObjectInfo struct {
+	ETag         string    `json:"etag"`
+	LastModified time.Time `json:"last_modified"`
+	// Metadata is the object's user metadata, keyed by lowercase name without
+	// the x-amz-meta- prefix. It isn't kept with stored versions.
+	Metadata map[string]string `json:"-"`
+}
+
+// VersionRecordSuffix ends the keys of records that describe another cache
+// entry, such as the origin version a render was made from. Purges remove
+// them along with the entries they describe, but don't count them.
+const VersionRecordSuffix = "#version"
+
+// IsVersionRecord reports whether key holds a version record.
+func IsVersionRecord(key string) bool {
+	return strings.HasSuffix(key, VersionRecordSuffix)
 }
 
 // ImageCache defines the interface for a cache backend.
 // It is responsible for storing and retrieving processed images to improve performance.
 type ImageCache interface {
+	// Get returns a fresh item, or nil if the item is missing or expired.
 	Get(ctx context.Context, key string) ([]byte, error)
-	Set(ctx context.Context, key string, data []byte) error
+	// GetEntry also returns items that have expired but are still within the
+	// cache's grace period, flagged as stale. It returns nil on a miss.
+	GetEntry(ctx context.Context, key string) (*CacheEntry, error)
+	// Set stores an item, indexing it under each tag so it can be purged later.
+	Set(ctx context.Context, key string, data []byte, tags ...string) error
+	Purger
+}
+
+// Purger is implemented by caches, and by decorators holding caches, that can
+// drop items by tag. Tags are image IDs, so purging one removes every variant
+// of that image.
+type Purger interface {
+	// Purge removes every item tagged with tag and returns how many were removed.
+	Purge(ctx context.Context, tag string) (int, error)
+	// PurgePrefix removes every item with a tag starting with prefix.
+	PurgePrefix(ctx context.Context, prefix string) (int, error)
+}
+
+// CacheEntry is a cached item along with its freshness.
+type CacheEntry struct {
+	Data     []byte
+	Stale    bool
+	StaleFor time.Duration // How long ago the item expired; zero when fresh
+}
+
+// OutputStorage defines the interface for a writable destination for rendered images.
+// It lets the service publish renders once and send clients straight to them.
+type OutputStorage interface {
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+	Exists(ctx context.Context, key string) (bool, error)
+	// URL returns a URL clients can fetch the object from, presigned if necessary.
+	URL(ctx context.Context, key string) (string, error)
 }
@@ -4,41 +4,71 @@ import (
 	"context"
 	"crypto/sha1"
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
 )
 
+// localTagDir holds the sidecar tag index: a directory per tag, holding an
+// empty marker file named after each cache file tagged with it. Tagging a
+// file again just finds its marker, so the index never outgrows the cache.
+const localTagDir = "tags"
+
 // LocalCache implements the ImageCache interface using the local filesystem.
 type LocalCache struct {
-	path string
-	ttl  time.Duration
-	log  *logrus.Entry
+	path  string
+	ttl   time.Duration
+	grace time.Duration
+	log   *logrus.Entry
+
+	tagMu sync.Mutex // Serializes tag index updates
 }
 
 // NewLocalCache creates a new filesystem-based cache.
-// It ensures the cache directory exists.
-func NewLocalCache(path string, ttl time.Duration, logger *logrus.Logger) (*LocalCache, error) {
-	if err := os.MkdirAll(path, 0755); err != nil {
+// It ensures the cache directory exists. Expired items are kept on disk for
+// the grace period so they can still be served as stale.
+func NewLocalCache(path string, ttl, grace time.Duration, logger *logrus.Logger) (*LocalCache, error) {
+	if err := os.MkdirAll(filepath.Join(path, localTagDir), 0755); err != nil {
 		return nil, fmt.Errorf("cannot create cache directory %s: %w", path, err)
 	}
 	return &LocalCache{
-		path: path,
-		tl:   ttl,
-		log:  logger.WithField("component", "LocalCache"),
+		path:  path,
+		ttl:   ttl,
+		grace: grace,
+		log:   logger.WithField("component", "LocalCache"),
 	}, nil
 }
 
+// localRecordExt names the files of version records, so purges can tell them
+// apart from rendered images by their tag index markers.
+const localRecordExt = ".version"
+
 // getFilePath generates a safe, unique file path for a given cache key.
 func (c *LocalCache) getFilePath(key string) string {
 	hash := sha1.Sum([]byte(key))
-	return filepath.Join(c.path, fmt.Sprintf("%x.jpg", hash))
+	ext := ".jpg"
+	if IsVersionRecord(key) {
+		ext = localRecordExt
+	}
+	return filepath.Join(c.path, fmt.Sprintf("%x%s", hash, ext))
 }
 
 // Get retrieves an item from the cache. It returns nil if the item is not found or expired.
 func (c *LocalCache) Get(ctx context.Context, key string) ([]byte, error) {
+	entry, err := c.GetEntry(ctx, key)
+	if err != nil || entry == nil || entry.Stale {
+		return nil, err
+	}
+	return entry.Data, nil
+}
+
+// GetEntry retrieves an item from the cache, including items within the grace period.
+func (c *LocalCache) GetEntry(ctx context.Context, key string) (*CacheEntry, error) {
 	filePath := c.getFilePath(key)
 	info, err := os.Stat(filePath)
 	if os.IsNotExist(err) {
@@ -49,7 +79,8 @@ func (c *LocalCache) Get(ctx context.Context, key string) ([]byte, error) {
 		return nil, err // Other error
 	}
 
-	if time.Since(info.ModTime()) > c.ttl {
+	age := time.Since(info.ModTime())
+	if age > c.ttl+c.grace {
 		c.log.WithField("path", filePath).Info("Cache item expired, removing")
 		// Attempt to remove the stale file, but don't fail the Get operation if it fails.
 		if err := os.Remove(filePath); err != nil {
@@ -58,17 +89,126 @@ func (c *LocalCache) Get(ctx context.Context, key string) ([]byte, error) {
 		return nil, nil // Cache miss
 	}
 
-	c.log.WithField("path", filePath).Debug("Cache hit")
-	return os.ReadFile(filePath)
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return nil, nil // Removed between stat and read
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	entry := &CacheEntry{Data: data}
+	if age > c.ttl {
+		entry.Stale = true
+		entry.StaleFor = age - c.ttl
+		c.log.WithField("path", filePath).Debug("Stale cache hit")
+	} else {
+		c.log.WithField("path", filePath).Debug("Cache hit")
+	}
+	return entry, nil
 }
 
-// Set adds an item to the cache.
-func (c *LocalCache) Set(ctx context.Context, key string, data []byte) error {
+// Set adds an item to the cache and records it in the index of each tag.
+func (c *LocalCache) Set(ctx context.Context, key string, data []byte, tags ...string) error {
 	filePath := c.getFilePath(key)
 	c.log.WithField("path", filePath).Debug("Setting cache item")
 	if err := os.WriteFile(filePath, data, 0644); err != nil {
 		c.log.WithError(err).WithField("path", filePath).Error("Failed to write cache file")
 		return err
 	}
+
+	if len(tags) == 0 {
+		return nil
+	}
+
+	c.tagMu.Lock()
+	defer c.tagMu.Unlock()
+	for _, tag := range tags {
+		if err := c.addToTagIndex(tag, filepath.Base(filePath)); err != nil {
+			c.log.WithError(err).WithField("tag", tag).Error("Failed to update tag index")
+			return err
+		}
+	}
 	return nil
 }
+
+// Purge removes every item tagged with tag.
+func (c *LocalCache) Purge(ctx context.Context, tag string) (int, error) {
+	c.tagMu.Lock()
+	defer c.tagMu.Unlock()
+	return c.purgeLocked(tag)
+}
+
+// PurgePrefix removes every item with a tag starting with prefix.
+func (c *LocalCache) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	c.tagMu.Lock()
+	defer c.tagMu.Unlock()
+
+	entries, err := os.ReadDir(filepath.Join(c.path, localTagDir))
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, e := range entries {
+		tag, err := url.PathUnescape(e.Name())
+		if err != nil || !strings.HasPrefix(tag, prefix) {
+			continue
+		}
+		n, err := c.purgeLocked(tag)
+		if err != nil {
+			return purged, err
+		}
+		purged += n
+	}
+	return purged, nil
+}
+
+func (c *LocalCache) tagIndexPath(tag string) string {
+	return filepath.Join(c.path, localTagDir, url.PathEscape(tag))
+}
+
+func (c *LocalCache) addToTagIndex(tag, fileName string) error {
+	indexPath := c.tagIndexPath(tag)
+	if err := os.MkdirAll(indexPath, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(indexPath, fileName), os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// purgeLocked removes the files listed in a tag's index, then the index itself,
+// and counts the removed images. c.tagMu must be held.
+func (c *LocalCache) purgeLocked(tag string) (int, error) {
+	indexPath := c.tagIndexPath(tag)
+	markers, err := os.ReadDir(indexPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, m := range markers {
+		err := os.Remove(filepath.Join(c.path, m.Name()))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		if filepath.Ext(m.Name()) != localRecordExt {
+			purged++
+		}
+	}
+
+	if err := os.RemoveAll(indexPath); err != nil {
+		return purged, err
+	}
+	c.log.WithField("tag", tag).WithField("purged", purged).Info("Purged local cache items")
+	return purged, nil
+}
@@ -0,0 +1,164 @@
+package storage
+
+import (
+	"container/list"
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// MemoryCache implements the ImageCache interface as an in-process LRU bounded by total size.
+type MemoryCache struct {
+	mu       sync.Mutex
+	ttl      time.Duration
+	grace    time.Duration
+	maxBytes int64
+	size     int64
+	order    *list.List
+	items    map[string]*list.Element
+	tags     map[string]map[string]struct{} // tag -> keys
+	log      *logrus.Entry
+}
+
+type memoryEntry struct {
+	key       string
+	data      []byte
+	tags      []string
+	expiresAt time.Time
+}
+
+// NewMemoryCache creates a new in-memory cache holding at most maxBytes of data.
+// Expired items are retained for the grace period so they can be served as stale.
+func NewMemoryCache(maxBytes int64, ttl, grace time.Duration, logger *logrus.Logger) *MemoryCache {
+	return &MemoryCache{
+		ttl:      ttl,
+		grace:    grace,
+		maxBytes: maxBytes,
+		order:    list.New(),
+		items:    make(map[string]*list.Element),
+		tags:     make(map[string]map[string]struct{}),
+		log:      logger.WithField("component", "MemoryCache"),
+	}
+}
+
+// Get retrieves an item from the cache. It returns nil if the item is not found or expired.
+func (c *MemoryCache) Get(ctx context.Context, key string) ([]byte, error) {
+	entry, err := c.GetEntry(ctx, key)
+	if err != nil || entry == nil || entry.Stale {
+		return nil, err
+	}
+	return entry.Data, nil
+}
+
+// GetEntry retrieves an item from the cache, including items within the grace period.
+func (c *MemoryCache) GetEntry(ctx context.Context, key string) (*CacheEntry, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, nil // Cache miss
+	}
+	item := el.Value.(*memoryEntry)
+	staleFor := time.Since(item.expiresAt)
+	if staleFor > c.grace {
+		c.removeElement(el)
+		return nil, nil // Cache miss
+	}
+
+	c.order.MoveToFront(el)
+	if staleFor > 0 {
+		return &CacheEntry{Data: item.data, Stale: true, StaleFor: staleFor}, nil
+	}
+	return &CacheEntry{Data: item.data}, nil
+}
+
+// Set adds an item to the cache, evicting the least recently used items to stay within budget.
+func (c *MemoryCache) Set(ctx context.Context, key string, data []byte, tags ...string) error {
+	size := int64(len(data))
+	if size > c.maxBytes {
+		c.log.WithField("key", key).WithField("size", size).Debug("Item exceeds cache budget, skipping")
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.items[key]; ok {
+		c.removeElement(el)
+	}
+
+	for c.size+size > c.maxBytes {
+		oldest := c.order.Back()
+		if oldest == nil {
+			break
+		}
+		c.log.WithField("key", oldest.Value.(*memoryEntry).key).Debug("Evicting cache item")
+		c.removeElement(oldest)
+	}
+
+	c.items[key] = c.order.PushFront(&memoryEntry{
+		key:       key,
+		data:      data,
+		tags:      tags,
+		expiresAt: time.Now().Add(c.ttl),
+	})
+	c.size += size
+	for _, tag := range tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = make(map[string]struct{})
+		}
+		c.tags[tag][key] = struct{}{}
+	}
+	return nil
+}
+
+// Purge removes every item tagged with tag.
+func (c *MemoryCache) Purge(ctx context.Context, tag string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.purgeLocked(tag), nil
+}
+
+// PurgePrefix removes every item with a tag starting with prefix.
+func (c *MemoryCache) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged := 0
+	for tag := range c.tags {
+		if strings.HasPrefix(tag, prefix) {
+			purged += c.purgeLocked(tag)
+		}
+	}
+	return purged, nil
+}
+
+func (c *MemoryCache) purgeLocked(tag string) int {
+	purged := 0
+	for key := range c.tags[tag] {
+		if el, ok := c.items[key]; ok {
+			c.removeElement(el)
+			if !IsVersionRecord(key) {
+				purged++
+			}
+		}
+	}
+	delete(c.tags, tag)
+	return purged
+}
+
+func (c *MemoryCache) removeElement(el *list.Element) {
+	entry := c.order.Remove(el).(*memoryEntry)
+	delete(c.items, entry.key)
+	c.size -= int64(len(entry.data))
+	for _, tag := range entry.tags {
+		delete(c.tags[tag], entry.key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
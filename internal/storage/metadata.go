@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"watermark/internal/config"
+)
+
+// Metadata is overlay data stored with an image.
+type Metadata struct {
+	// Fields are keyed by lowercase name, with dashes in object metadata
+	// names turned into underscores.
+	Fields map[string]string
+	// Version changes whenever the sidecar or the user metadata does. It is
+	// empty when the image has neither.
+	Version string
+}
+
+// metadataEntry is a remembered lookup. The sidecar's ETag lets a refresh
+// skip downloading a sidecar that hasn't changed.
+type metadataEntry struct {
+	sidecar     map[string]string
+	sidecarETag string
+	object      map[string]string
+	expiry      time.Time
+}
+
+// MetadataStore reads overlay data for images from a JSON sidecar stored
+// next to each image (123.json for 123.jpg) and from the image's S3 user
+// metadata. Sidecar fields win over user metadata. Lookups are remembered for
+// a TTL, so renders served from the cache don't each cost a trip to the bucket.
+type MetadataStore struct {
+	storage ImageStorage
+	cfg     config.MetadataConfig
+	log     *logrus.Entry
+
+	mu      sync.Mutex
+	entries map[string]*metadataEntry
+}
+
+// NewMetadataStore reads metadata through storage, which should not be a
+// cache of originals: sidecars are revalidated against the bucket by ETag.
+func NewMetadataStore(storage ImageStorage, cfg config.MetadataConfig, logger *logrus.Logger) *MetadataStore {
+	return &MetadataStore{
+		storage: storage,
+		cfg:     cfg,
+		log:     logger.WithField("component", "MetadataStore"),
+		entries: make(map[string]*metadataEntry),
+	}
+}
+
+// SidecarKey returns the key of the sidecar for an image key.
+func SidecarKey(imageKey string) string {
+	return strings.TrimSuffix(imageKey, path.Ext(imageKey)) + ".json"
+}
+
+// Get returns the metadata stored with the image at key. Missing sidecars and
+// images without user metadata just contribute no fields.
+func (m *MetadataStore) Get(ctx context.Context, key string) (*Metadata, error) {
+	m.mu.Lock()
+	prev := m.entries[key]
+	m.mu.Unlock()
+	if prev != nil && time.Now().Before(prev.expiry) {
+		return prev.metadata(), nil
+	}
+
+	entry := &metadataEntry{expiry: time.Now().Add(m.cfg.TTL)}
+	if m.cfg.ObjectMetadata {
+		info, err := m.storage.Stat(ctx, key, "")
+		if err != nil && !errors.Is(err, ErrNotFound) {
+			return nil, fmt.Errorf("failed to read object metadata: %w", err)
+		}
+		if info != nil {
+			entry.object = make(map[string]string, len(info.Metadata))
+			for name, value := range info.Metadata {
+				entry.object[strings.ReplaceAll(strings.ToLower(name), "-", "_")] = value
+			}
+		}
+	}
+	if m.cfg.Sidecar && key != SidecarKey(key) {
+		if err := m.loadSidecar(ctx, SidecarKey(key), prev, entry); err != nil {
+			return nil, err
+		}
+	}
+
+	m.remember(key, entry)
+	return entry.metadata(), nil
+}
+
+// loadSidecar fills entry's sidecar fields, reusing prev's when the sidecar's
+// ETag hasn't changed.
+func (m *MetadataStore) loadSidecar(ctx context.Context, key string, prev, entry *metadataEntry) error {
+	var etag string
+	if prev != nil {
+		etag = prev.sidecarETag
+	}
+	info, err := m.storage.Stat(ctx, key, etag)
+	switch {
+	case errors.Is(err, ErrNotModified):
+		entry.sidecar, entry.sidecarETag = prev.sidecar, prev.sidecarETag
+		return nil
+	case errors.Is(err, ErrNotFound):
+		return nil
+	case err != nil:
+		return fmt.Errorf("failed to stat sidecar %s: %w", key, err)
+	}
+
+	data, _, err := m.storage.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		// Deleted between the two requests.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get sidecar %s: %w", key, err)
+	}
+	fields, err := parseSidecar(data)
+	if err != nil {
+		return fmt.Errorf("%w: sidecar %s: %v", ErrInvalidMetadata, key, err)
+	}
+	entry.sidecar, entry.sidecarETag = fields, info.ETag
+	m.log.WithField("key", key).WithField("fields", len(fields)).Debug("Loaded sidecar")
+	return nil
+}
+
+// parseSidecar reads a flat JSON object. Numbers and booleans become their
+// JSON text; nested values are rejected.
+func parseSidecar(data []byte) (map[string]string, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string, len(raw))
+	for name, value := range raw {
+		value = bytes.TrimSpace(value)
+		switch {
+		case len(value) == 0 || string(value) == "null":
+			continue
+		case value[0] == '"':
+			var s string
+			if err := json.Unmarshal(value, &s); err != nil {
+				return nil, fmt.Errorf("field %s: %v", name, err)
+			}
+			fields[strings.ToLower(name)] = s
+		case value[0] == '{' || value[0] == '[':
+			return nil, fmt.Errorf("field %s must be a string, number or boolean", name)
+		default:
+			fields[strings.ToLower(name)] = string(value)
+		}
+	}
+	return fields, nil
+}
+
+// metadata merges the entry's sources and derives its version.
+func (e *metadataEntry) metadata() *Metadata {
+	md := &Metadata{Fields: make(map[string]string, len(e.object)+len(e.sidecar))}
+	for name, value := range e.object {
+		md.Fields[name] = value
+	}
+	for name, value := range e.sidecar {
+		md.Fields[name] = value
+	}
+	if len(e.object) == 0 && e.sidecarETag == "" {
+		return md
+	}
+
+	// User metadata can change without the image's ETag changing, so it is
+	// versioned by its contents; the sidecar by its ETag.
+	names := make([]string, 0, len(e.object))
+	for name := range e.object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	h := sha256.New()
+	fmt.Fprintf(h, "sidecar=%s\n", e.sidecarETag)
+	for _, name := range names {
+		fmt.Fprintf(h, "%s=%s\n", name, e.object[name])
+	}
+	md.Version = hex.EncodeToString(h.Sum(nil)[:8])
+	return md
+}
+
+func (m *MetadataStore) remember(key string, entry *metadataEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if len(m.entries) >= m.cfg.MaxEntries {
+		// Drop expired entries first; if that's not enough, start over rather than grow unbounded.
+		for k, e := range m.entries {
+			if now.After(e.expiry) {
+				delete(m.entries, k)
+			}
+		}
+		if len(m.entries) >= m.cfg.MaxEntries {
+			m.log.WithField("entries", len(m.entries)).Warn("Metadata cache full, resetting")
+			m.entries = make(map[string]*metadataEntry)
+		}
+	}
+	m.entries[key] = entry
+}
+
+// Purge forgets the metadata of the image at key, so an updated sidecar is
+// read on the next request. It reports no purged items, since no renders are dropped.
+func (m *MetadataStore) Purge(ctx context.Context, key string) (int, error) {
+	m.mu.Lock()
+	delete(m.entries, key)
+	m.mu.Unlock()
+	return 0, nil
+}
+
+// PurgePrefix forgets the metadata of every image whose key starts with prefix.
+func (m *MetadataStore) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	m.mu.Lock()
+	for key := range m.entries {
+		if strings.HasPrefix(key, prefix) {
+			delete(m.entries, key)
+		}
+	}
+	m.mu.Unlock()
+	return 0, nil
+}
@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// NegativeCachedStorage decorates an ImageStorage by remembering keys that were not found,
+// so repeated requests for a missing object don't reach the origin until the TTL passes.
+type NegativeCachedStorage struct {
+	origin     ImageStorage
+	ttl        time.Duration
+	maxEntries int
+	log        *logrus.Entry
+
+	mu      sync.Mutex
+	missing map[string]time.Time // key -> expiry
+}
+
+// NewNegativeCachedStorage wraps origin, remembering at most maxEntries missing keys for ttl.
+func NewNegativeCachedStorage(origin ImageStorage, ttl time.Duration, maxEntries int, logger *logrus.Logger) *NegativeCachedStorage {
+	return &NegativeCachedStorage{
+		origin:     origin,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		log:        logger.WithField("component", "NegativeCachedStorage"),
+		missing:    make(map[string]time.Time),
+	}
+}
+
+// Get returns ErrNotFound without contacting the origin if the key was recently found missing.
+func (s *NegativeCachedStorage) Get(ctx context.Context, key string) ([]byte, *ObjectInfo, error) {
+	if s.isMissing(key) {
+		return nil, nil, ErrNotFound
+	}
+
+	data, info, err := s.origin.Get(ctx, key)
+	if errors.Is(err, ErrNotFound) {
+		s.remember(key)
+	}
+	return data, info, err
+}
+
+// Stat returns ErrNotFound without contacting the origin if the key was recently found missing.
+func (s *NegativeCachedStorage) Stat(ctx context.Context, key, ifNoneMatch string) (*ObjectInfo, error) {
+	if s.isMissing(key) {
+		return nil, ErrNotFound
+	}
+
+	info, err := s.origin.Stat(ctx, key, ifNoneMatch)
+	if errors.Is(err, ErrNotFound) {
+		s.remember(key)
+	}
+	return info, err
+}
+
+func (s *NegativeCachedStorage) isMissing(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiry, ok := s.missing[key]
+	if ok && time.Now().After(expiry) {
+		delete(s.missing, key)
+		return false
+	}
+	if ok {
+		s.log.WithField("key", key).Debug("Negative cache hit")
+	}
+	return ok
+}
+
+// Purge forgets that key was missing, so a re-uploaded object is found
+// immediately, and forwards to the wrapped storage if it holds a cache.
+func (s *NegativeCachedStorage) Purge(ctx context.Context, key string) (int, error) {
+	s.mu.Lock()
+	delete(s.missing, key)
+	s.mu.Unlock()
+
+	if p, ok := s.origin.(Purger); ok {
+		return p.Purge(ctx, key)
+	}
+	return 0, nil
+}
+
+// PurgePrefix forgets every missing key starting with prefix and forwards to
+// the wrapped storage if it holds a cache.
+func (s *NegativeCachedStorage) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	s.mu.Lock()
+	for key := range s.missing {
+		if strings.HasPrefix(key, prefix) {
+			delete(s.missing, key)
+		}
+	}
+	s.mu.Unlock()
+
+	if p, ok := s.origin.(Purger); ok {
+		return p.PurgePrefix(ctx, prefix)
+	}
+	return 0, nil
+}
+
+func (s *NegativeCachedStorage) remember(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if len(s.missing) >= s.maxEntries {
+		// Drop expired keys first; if that's not enough, start over rather than grow unbounded.
+		for k, expiry := range s.missing {
+			if now.After(expiry) {
+				delete(s.missing, k)
+			}
+		}
+		if len(s.missing) >= s.maxEntries {
+			s.log.WithField("entries", len(s.missing)).Warn("Negative cache full, resetting")
+			s.missing = make(map[string]time.Time)
+		}
+	}
+	s.missing[key] = now.Add(s.ttl)
+}
@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// stubOrigin answers every Get with err, or the key itself when err is nil.
+type stubOrigin struct {
+	err   error
+	calls map[string]int
+}
+
+func newStubOrigin(err error) *stubOrigin {
+	return &stubOrigin{err: err, calls: make(map[string]int)}
+}
+
+func (o *stubOrigin) Get(ctx context.Context, key string) ([]byte, *ObjectInfo, error) {
+	o.calls[key]++
+	if o.err != nil {
+		return nil, nil, o.err
+	}
+	return []byte(key), &ObjectInfo{ETag: key}, nil
+}
+
+func (o *stubOrigin) Stat(ctx context.Context, key, ifNoneMatch string) (*ObjectInfo, error) {
+	o.calls[key]++
+	if o.err != nil {
+		return nil, o.err
+	}
+	return &ObjectInfo{ETag: key}, nil
+}
+
+func TestNegativeCachedStorage(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("remembers misses for the TTL", func(t *testing.T) {
+		origin := newStubOrigin(fmt.Errorf("s3 object a: %w", ErrNotFound))
+		s := NewNegativeCachedStorage(origin, 50*time.Millisecond, 10, testLogger())
+
+		for i := 0; i < 3; i++ {
+			if _, _, err := s.Get(ctx, "a"); !errors.Is(err, ErrNotFound) {
+				t.Fatalf("Get() = %v, want ErrNotFound", err)
+			}
+		}
+		if origin.calls["a"] != 1 {
+			t.Fatalf("origin asked %d times within the TTL, want 1", origin.calls["a"])
+		}
+
+		time.Sleep(60 * time.Millisecond)
+		s.Get(ctx, "a")
+		if origin.calls["a"] != 2 {
+			t.Errorf("origin asked %d times after the TTL, want 2", origin.calls["a"])
+		}
+	})
+
+	t.Run("Stat shares what Get remembered", func(t *testing.T) {
+		origin := newStubOrigin(ErrNotFound)
+		s := NewNegativeCachedStorage(origin, time.Hour, 10, testLogger())
+		s.Get(ctx, "a")
+		if _, err := s.Stat(ctx, "a", ""); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("Stat() = %v, want ErrNotFound", err)
+		}
+		if origin.calls["a"] != 1 {
+			t.Errorf("origin asked %d times, want 1", origin.calls["a"])
+		}
+	})
+
+	t.Run("doesn't remember other errors", func(t *testing.T) {
+		origin := newStubOrigin(errors.New("connection reset"))
+		s := NewNegativeCachedStorage(origin, time.Hour, 10, testLogger())
+		s.Get(ctx, "a")
+		s.Get(ctx, "a")
+		if origin.calls["a"] != 2 {
+			t.Errorf("origin asked %d times, want 2", origin.calls["a"])
+		}
+	})
+
+	t.Run("found objects pass through", func(t *testing.T) {
+		origin := newStubOrigin(nil)
+		s := NewNegativeCachedStorage(origin, time.Hour, 10, testLogger())
+		if data, _, err := s.Get(ctx, "a"); err != nil || string(data) != "a" {
+			t.Errorf("Get() = %q, %v", data, err)
+		}
+	})
+
+	t.Run("stays within maxEntries", func(t *testing.T) {
+		origin := newStubOrigin(ErrNotFound)
+		s := NewNegativeCachedStorage(origin, time.Hour, 3, testLogger())
+		for i := 0; i < 10; i++ {
+			s.Get(ctx, fmt.Sprint(i))
+			if len(s.missing) > 3 {
+				t.Fatalf("%d keys remembered, limit is 3", len(s.missing))
+			}
+		}
+	})
+}
+
+func TestIsS3NotFound(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: &types.NoSuchKey{}, want: true},
+		{err: fmt.Errorf("get: %w", &types.NoSuchKey{}), want: true},
+		{err: &smithy.GenericAPIError{Code: "NotFound"}, want: true},
+		{err: &smithy.GenericAPIError{Code: "AccessDenied"}, want: false},
+		{err: errors.New("timeout"), want: false},
+		{err: nil, want: false},
+	}
+	for _, tt := range tests {
+		if got := isS3NotFound(tt.err); got != tt.want {
+			t.Errorf("isS3NotFound(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
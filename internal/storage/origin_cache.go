@@ -0,0 +1,172 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"watermark/internal/config"
+)
+
+// originKeyPrefix keeps original images apart from rendered images when both tiers share a backend.
+const originKeyPrefix = "origin:"
+
+// CachedStorage decorates an ImageStorage with a cache for original source images.
+// Concurrent misses for the same key are collapsed into a single origin fetch.
+type CachedStorage struct {
+	origin         ImageStorage
+	cache          ImageCache
+	maxObjectBytes int64
+	fetchTimeout   time.Duration
+	log            *logrus.Entry
+
+	mu       sync.Mutex
+	inflight map[string]*originCall
+}
+
+type originCall struct {
+	done chan struct{}
+	data []byte
+	info *ObjectInfo
+	err  error
+}
+
+// NewCachedStorage wraps origin with the given cache.
+// Objects larger than maxObjectBytes are passed through without being cached.
+// A shared fetch runs for at most fetchTimeout, or without a limit if it is zero.
+func NewCachedStorage(origin ImageStorage, cache ImageCache, maxObjectBytes int64, fetchTimeout time.Duration, logger *logrus.Logger) *CachedStorage {
+	return &CachedStorage{
+		origin:         origin,
+		cache:          cache,
+		maxObjectBytes: maxObjectBytes,
+		fetchTimeout:   fetchTimeout,
+		log:            logger.WithField("component", "CachedStorage"),
+		inflight:       make(map[string]*originCall),
+	}
+}
+
+// NewOriginCache builds the cache backend selected for original images.
+// It returns nil when the originals cache is disabled.
+func NewOriginCache(cfg config.OriginCacheConfig, redisCfg config.RedisConfig, logger *logrus.Logger) (ImageCache, error) {
+	switch cfg.Provider {
+	case "", "none":
+		return nil, nil
+	case "memory":
+		return NewMemoryCache(cfg.MaxBytes, cfg.TTL, 0, logger), nil
+	case "local":
+		return NewLocalCache(cfg.Local.Path, cfg.TTL, 0, logger)
+	case "redis":
+		return NewRedisCache(redisCfg, cfg.TTL, 0, logger), nil
+	default:
+		return nil, fmt.Errorf("unknown origin cache provider: %s", cfg.Provider)
+	}
+}
+
+// Get returns the original image, serving it from the cache when possible.
+// A cached original comes with the version it was fetched at.
+func (s *CachedStorage) Get(ctx context.Context, key string) ([]byte, *ObjectInfo, error) {
+	cacheKey := originKeyPrefix + key
+
+	cached, err := s.cache.Get(ctx, cacheKey)
+	if err != nil {
+		// The origin is still authoritative, so a broken cache only costs us a fetch.
+		s.log.WithError(err).WithField("key", key).Warn("Origin cache GET failed")
+	}
+	if cached != nil {
+		if data, info, ok := decodeOriginal(cached); ok {
+			s.log.WithField("key", key).Debug("Origin cache hit")
+			return data, info, nil
+		}
+		s.log.WithField("key", key).Warn("Corrupt origin cache entry")
+	}
+
+	s.mu.Lock()
+	call, ok := s.inflight[key]
+	if !ok {
+		call = &originCall{done: make(chan struct{})}
+		s.inflight[key] = call
+		// The fetch is shared, so it must not fail for everyone when the
+		// caller that happened to start it goes away. It may still run as
+		// long as that caller would have waited, if that is longer.
+		timeout := s.fetchTimeout
+		if dl, ok := ctx.Deadline(); ok && timeout > 0 && time.Until(dl) > timeout {
+			timeout = time.Until(dl)
+		}
+		go s.fetch(context.WithoutCancel(ctx), key, timeout, call)
+	}
+	s.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.data, call.info, call.err
+	case <-ctx.Done():
+		return nil, nil, ctx.Err()
+	}
+}
+
+// fetch gets key from the origin for every caller waiting on call, and
+// caches the result. A zero timeout leaves the fetch unbounded.
+func (s *CachedStorage) fetch(ctx context.Context, key string, timeout time.Duration, call *originCall) {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	call.data, call.info, call.err = s.origin.Get(ctx, key)
+
+	s.mu.Lock()
+	delete(s.inflight, key)
+	s.mu.Unlock()
+	close(call.done)
+
+	if call.err == nil && int64(len(call.data)) <= s.maxObjectBytes {
+		if err := s.cache.Set(ctx, originKeyPrefix+key, encodeOriginal(call.data, call.info), key); err != nil {
+			s.log.WithError(err).WithField("key", key).Warn("Origin cache SET failed")
+		}
+	}
+}
+
+// encodeOriginal packs an original and its version into one cache item, so
+// the two can't be evicted or replaced apart: the version as a line of JSON,
+// then the image bytes.
+func encodeOriginal(data []byte, info *ObjectInfo) []byte {
+	header, _ := json.Marshal(info)
+	buf := make([]byte, 0, len(header)+1+len(data))
+	buf = append(buf, header...)
+	buf = append(buf, '\n')
+	return append(buf, data...)
+}
+
+// decodeOriginal unpacks an item written by encodeOriginal.
+func decodeOriginal(item []byte) ([]byte, *ObjectInfo, bool) {
+	header, data, ok := bytes.Cut(item, []byte{'\n'})
+	if !ok {
+		return nil, nil, false
+	}
+	var info *ObjectInfo
+	if err := json.Unmarshal(header, &info); err != nil {
+		return nil, nil, false
+	}
+	return data, info, true
+}
+
+// Stat always asks the origin, since the point is to find out whether the cached copy is current.
+func (s *CachedStorage) Stat(ctx context.Context, key, ifNoneMatch string) (*ObjectInfo, error) {
+	return s.origin.Stat(ctx, key, ifNoneMatch)
+}
+
+// Purge drops the cached original for key, and anything else tagged with it.
+func (s *CachedStorage) Purge(ctx context.Context, key string) (int, error) {
+	return s.cache.Purge(ctx, key)
+}
+
+// PurgePrefix drops cached originals whose keys start with prefix.
+func (s *CachedStorage) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	return s.cache.PurgePrefix(ctx, prefix)
+}
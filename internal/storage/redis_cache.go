@@ -4,23 +4,29 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 
-	"watermark-service/internal/config"
+	"watermark/internal/config"
 )
 
+// redisTagPrefix namespaces the sets that index cache keys by tag.
+const redisTagPrefix = "cachetag:"
+
 // RedisCache implements the ImageCache interface using Redis.
 type RedisCache struct {
 	client *redis.Client
 	ttl    time.Duration
+	grace  time.Duration
 	log    *logrus.Entry
 }
 
 // NewRedisCache creates a new Redis-backed cache.
-func NewRedisCache(cfg config.RedisConfig, ttl time.Duration, logger *logrus.Logger) *RedisCache {
+// Keys live for ttl plus grace; the remaining TTL tells fresh and stale items apart.
+func NewRedisCache(cfg config.RedisConfig, ttl, grace time.Duration, logger *logrus.Logger) *RedisCache {
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     cfg.Addr,
 		Password: cfg.Password,
@@ -30,15 +36,31 @@ func NewRedisCache(cfg config.RedisConfig, ttl time.Duration, logger *logrus.Log
 	return &RedisCache{
 		client: rdb,
 		ttl:    ttl,
+		grace:  grace,
 		log:    logger.WithField("component", "RedisCache"),
 	}
 }
 
-// Get retrieves an item from the Redis cache.
+// Get retrieves an item from the Redis cache. It returns nil if the item is not found or expired.
 func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
+	entry, err := c.GetEntry(ctx, key)
+	if err != nil || entry == nil || entry.Stale {
+		return nil, err
+	}
+	return entry.Data, nil
+}
+
+// GetEntry retrieves an item from the Redis cache, including items within the grace period.
+func (c *RedisCache) GetEntry(ctx context.Context, key string) (*CacheEntry, error) {
 	c.log.WithField("key", key).Debug("Getting from redis")
-	val, err := c.client.Get(ctx, key).Bytes()
-	if errors.Is(err, redis.Nil) {
+
+	pipe := c.client.Pipeline()
+	getCmd := pipe.Get(ctx, key)
+	ttlCmd := pipe.PTTL(ctx, key)
+	_, err := pipe.Exec(ctx)
+
+	val, getErr := getCmd.Bytes()
+	if errors.Is(getErr, redis.Nil) {
 		c.log.WithField("key", key).Debug("Redis cache miss")
 		return nil, nil // Cache miss
 	} else if err != nil {
@@ -46,14 +68,33 @@ func (c *RedisCache) Get(ctx context.Context, key string) ([]byte, error) {
 		return nil, fmt.Errorf("redis GET failed for key %s: %w", key, err)
 	}
 
-	c.log.WithField("key", key).Debug("Redis cache hit")
-	return val, nil
+	entry := &CacheEntry{Data: val}
+	if remaining := ttlCmd.Val(); remaining >= 0 && remaining < c.grace {
+		entry.Stale = true
+		entry.StaleFor = c.grace - remaining
+		c.log.WithField("key", key).Debug("Redis stale cache hit")
+	} else {
+		c.log.WithField("key", key).Debug("Redis cache hit")
+	}
+	return entry, nil
 }
 
-// Set adds an item to the Redis cache with the configured TTL.
-func (c *RedisCache) Set(ctx context.Context, key string, data []byte) error {
+// Set adds an item to the Redis cache with the configured TTL plus the grace period.
+// Each tag is a Redis set of keys, expiring no earlier than its longest-lived
+// member. Caches with different TTLs share the sets, so a set's expiry is only
+// ever extended, which needs Redis 7 for EXPIRE NX and GT.
+func (c *RedisCache) Set(ctx context.Context, key string, data []byte, tags ...string) error {
 	c.log.WithField("key", key).Debug("Setting to redis")
-	err := c.client.Set(ctx, key, data, c.ttl).Err()
+	expiry := c.ttl + c.grace
+	_, err := c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, key, data, expiry)
+		for _, tag := range tags {
+			pipe.SAdd(ctx, redisTagPrefix+tag, key)
+			pipe.ExpireNX(ctx, redisTagPrefix+tag, expiry)
+			pipe.ExpireGT(ctx, redisTagPrefix+tag, expiry)
+		}
+		return nil
+	})
 	if err != nil {
 		c.log.WithError(err).WithField("key", key).Error("Redis SET failed")
 		return fmt.Errorf("redis SET failed for key %s: %w", key, err)
@@ -61,6 +102,72 @@ func (c *RedisCache) Set(ctx context.Context, key string, data []byte) error {
 	return nil
 }
 
+// Purge removes every item tagged with tag. Version records are removed
+// along with the items but not counted.
+func (c *RedisCache) Purge(ctx context.Context, tag string) (int, error) {
+	tagKey := redisTagPrefix + tag
+	keys, err := c.client.SMembers(ctx, tagKey).Result()
+	if err != nil {
+		return 0, fmt.Errorf("redis SMEMBERS failed for tag %s: %w", tag, err)
+	}
+
+	items, records := []string{}, []string{tagKey}
+	for _, key := range keys {
+		if IsVersionRecord(key) {
+			records = append(records, key)
+		} else {
+			items = append(items, key)
+		}
+	}
+	var itemsDel *redis.IntCmd
+	_, err = c.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		if len(items) > 0 {
+			itemsDel = pipe.Del(ctx, items...)
+		}
+		pipe.Del(ctx, records...)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("redis DEL failed for tag %s: %w", tag, err)
+	}
+	purged := 0
+	if itemsDel != nil {
+		purged = int(itemsDel.Val())
+	}
+	c.log.WithField("tag", tag).WithField("purged", purged).Info("Purged redis cache items")
+	return purged, nil
+}
+
+// PurgePrefix removes every item with a tag starting with prefix.
+func (c *RedisCache) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	purged := 0
+	iter := c.client.Scan(ctx, 0, redisTagPrefix+escapeGlob(prefix)+"*", 100).Iterator()
+	for iter.Next(ctx) {
+		n, err := c.Purge(ctx, strings.TrimPrefix(iter.Val(), redisTagPrefix))
+		if err != nil {
+			return purged, err
+		}
+		purged += n
+	}
+	if err := iter.Err(); err != nil {
+		return purged, fmt.Errorf("redis SCAN failed for prefix %s: %w", prefix, err)
+	}
+	return purged, nil
+}
+
+// escapeGlob escapes the characters Redis treats specially in SCAN patterns.
+func escapeGlob(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '*', '?', '[', ']', '\\':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
 func (c *RedisCache) Close() error {
 	return c.client.Close()
 }
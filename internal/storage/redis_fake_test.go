@@ -0,0 +1,239 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// fakeRedis answers go-redis commands from memory by intercepting them in a
+// hook, so Redis-backed code can be tested without a server. It knows just
+// the commands this package sends.
+type fakeRedis struct {
+	mu      sync.Mutex
+	strings map[string]string
+	sets    map[string]map[string]struct{}
+	expires map[string]time.Time
+}
+
+func newFakeRedisClient() (*redis.Client, *fakeRedis) {
+	f := &fakeRedis{
+		strings: make(map[string]string),
+		sets:    make(map[string]map[string]struct{}),
+		expires: make(map[string]time.Time),
+	}
+	client := redis.NewClient(&redis.Options{Addr: "fake:6379"})
+	client.AddHook(f)
+	return client, f
+}
+
+func (f *fakeRedis) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return nil, fmt.Errorf("fake redis doesn't dial")
+	}
+}
+
+func (f *fakeRedis) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.process(cmd)
+		return cmd.Err()
+	}
+}
+
+func (f *fakeRedis) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		var firstErr error
+		for _, cmd := range cmds {
+			f.process(cmd)
+			if err := cmd.Err(); err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return firstErr
+	}
+}
+
+// ttl returns the remaining time to live of key, or -1 if it has none.
+func (f *fakeRedis) ttl(key string) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expire()
+	at, ok := f.expires[key]
+	if !ok {
+		return -1
+	}
+	return time.Until(at)
+}
+
+func (f *fakeRedis) exists(key string) bool {
+	_, isString := f.strings[key]
+	_, isSet := f.sets[key]
+	return isString || isSet
+}
+
+func (f *fakeRedis) expire() {
+	for key, at := range f.expires {
+		if !time.Now().Before(at) {
+			f.del(key)
+		}
+	}
+}
+
+func (f *fakeRedis) del(key string) bool {
+	existed := f.exists(key)
+	delete(f.strings, key)
+	delete(f.sets, key)
+	delete(f.expires, key)
+	return existed
+}
+
+func (f *fakeRedis) process(cmd redis.Cmder) {
+	f.expire()
+
+	args := make([]string, len(cmd.Args()))
+	for i, arg := range cmd.Args() {
+		switch v := arg.(type) {
+		case []byte:
+			args[i] = string(v)
+		default:
+			args[i] = fmt.Sprint(v)
+		}
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "multi", "exec":
+		// Commands inside a transaction are applied as they come.
+	case "set":
+		f.del(args[1])
+		f.strings[args[1]] = args[2]
+		if len(args) == 5 {
+			n, _ := strconv.ParseInt(args[4], 10, 64)
+			unit := time.Second
+			if strings.ToLower(args[3]) == "px" {
+				unit = time.Millisecond
+			}
+			f.expires[args[1]] = time.Now().Add(time.Duration(n) * unit)
+		}
+		cmd.(*redis.StatusCmd).SetVal("OK")
+	case "get":
+		if v, ok := f.strings[args[1]]; ok {
+			cmd.(*redis.StringCmd).SetVal(v)
+		} else {
+			cmd.SetErr(redis.Nil)
+		}
+	case "pttl":
+		switch at, ok := f.expires[args[1]]; {
+		case !f.exists(args[1]):
+			cmd.(*redis.DurationCmd).SetVal(-2)
+		case !ok:
+			cmd.(*redis.DurationCmd).SetVal(-1)
+		default:
+			cmd.(*redis.DurationCmd).SetVal(time.Until(at))
+		}
+	case "expire":
+		n, _ := strconv.ParseInt(args[2], 10, 64)
+		at := time.Now().Add(time.Duration(n) * time.Second)
+		current, volatile := f.expires[args[1]]
+		ok := f.exists(args[1])
+		if len(args) > 3 {
+			switch strings.ToLower(args[3]) {
+			case "nx":
+				ok = ok && !volatile
+			case "gt":
+				// Keys without an expiry count as living forever.
+				ok = ok && volatile && at.After(current)
+			}
+		}
+		if ok {
+			f.expires[args[1]] = at
+		}
+		cmd.(*redis.BoolCmd).SetVal(ok)
+	case "sadd":
+		set := f.sets[args[1]]
+		if set == nil {
+			set = make(map[string]struct{})
+			f.sets[args[1]] = set
+		}
+		added := 0
+		for _, member := range args[2:] {
+			if _, ok := set[member]; !ok {
+				set[member] = struct{}{}
+				added++
+			}
+		}
+		cmd.(*redis.IntCmd).SetVal(int64(added))
+	case "smembers":
+		members := []string{}
+		for member := range f.sets[args[1]] {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		cmd.(*redis.StringSliceCmd).SetVal(members)
+	case "del":
+		deleted := 0
+		for _, key := range args[1:] {
+			if f.del(key) {
+				deleted++
+			}
+		}
+		cmd.(*redis.IntCmd).SetVal(int64(deleted))
+	case "scan":
+		// The whole keyspace fits in one page.
+		match := regexp.MustCompile("^.*$")
+		for i := 2; i+1 < len(args); i += 2 {
+			if strings.ToLower(args[i]) == "match" {
+				match = globRegexp(args[i+1])
+			}
+		}
+		var keys []string
+		for key := range f.strings {
+			if match.MatchString(key) {
+				keys = append(keys, key)
+			}
+		}
+		for key := range f.sets {
+			if match.MatchString(key) {
+				keys = append(keys, key)
+			}
+		}
+		sort.Strings(keys)
+		cmd.(*redis.ScanCmd).SetVal(keys, 0)
+	default:
+		cmd.SetErr(fmt.Errorf("fake redis: unsupported command %q", args[0]))
+	}
+}
+
+// globRegexp translates the subset of Redis glob patterns escapeGlob produces.
+func globRegexp(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '\\':
+			i++
+			if i < len(pattern) {
+				b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
@@ -0,0 +1,352 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"watermark/internal/config"
+)
+
+var (
+	breakerState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "circuit_breaker_state",
+		Help: "Circuit breaker state per backend (0 = closed, 1 = half-open, 2 = open).",
+	}, []string{"backend"})
+	retryAttempts = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "backend_retries_total",
+		Help: "The total number of retried backend calls.",
+	}, []string{"backend"})
+)
+
+// ErrCircuitOpen is returned when a call is rejected because the backend's circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// transientCodes are S3 error codes for failures that may pass on their own.
+var transientCodes = map[string]bool{
+	"SlowDown":            true,
+	"Throttling":          true,
+	"ThrottlingException": true,
+	"RequestTimeout":      true,
+	"InternalError":       true,
+	"ServiceUnavailable":  true,
+}
+
+// isRetryable reports whether a failed call is worth repeating, which is
+// only the case for transient failures: timeouts, network errors, throttling
+// and 5xx responses. Anything else, like a denied or malformed request, would
+// fail the same way again, and says nothing about the backend's health.
+func isRetryable(err error) bool {
+	if err == nil || errors.Is(err, ErrCircuitOpen) || errors.Is(err, context.Canceled) {
+		return false
+	}
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) {
+		status := respErr.HTTPStatusCode()
+		return status >= http.StatusInternalServerError || status == http.StatusTooManyRequests
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		return transientCodes[apiErr.ErrorCode()]
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// retry runs fn until it succeeds, returns a non-retryable error, runs out of
+// attempts or ctx is done. Delays grow exponentially with full jitter.
+func retry(ctx context.Context, cfg config.RetryConfig, backend string, fn func(ctx context.Context) error) error {
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = fn(ctx)
+		if err == nil || !isRetryable(err) || attempt >= cfg.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		delay := cfg.BaseDelay << (attempt - 1)
+		if delay > cfg.MaxDelay || delay <= 0 {
+			delay = cfg.MaxDelay
+		}
+		if delay > 0 {
+			delay = time.Duration(rand.Int63n(int64(delay)) + 1)
+		}
+
+		retryAttempts.WithLabelValues(backend).Inc()
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+type breakerStatus int
+
+const (
+	breakerClosed breakerStatus = iota
+	breakerHalfOpen
+	breakerOpen
+)
+
+func (s breakerStatus) String() string {
+	switch s {
+	case breakerHalfOpen:
+		return "half-open"
+	case breakerOpen:
+		return "open"
+	default:
+		return "closed"
+	}
+}
+
+// CircuitBreaker stops calling a backend after consecutive failures. Once the
+// open timeout passes, a single probe call decides whether it closes again.
+type CircuitBreaker struct {
+	backend   string
+	threshold int
+	timeout   time.Duration
+	log       *logrus.Entry
+
+	mu       sync.Mutex
+	status   breakerStatus
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+// NewCircuitBreaker creates a closed circuit breaker for the named backend.
+func NewCircuitBreaker(backend string, cfg config.BreakerConfig, logger *logrus.Logger) *CircuitBreaker {
+	breakerState.WithLabelValues(backend).Set(float64(breakerClosed))
+	return &CircuitBreaker{
+		backend:   backend,
+		threshold: cfg.FailureThreshold,
+		timeout:   cfg.OpenTimeout,
+		log:       logger.WithField("component", "CircuitBreaker").WithField("backend", backend),
+	}
+}
+
+// Allow reports whether a call may proceed. Every allowed call must be followed by Record.
+func (b *CircuitBreaker) Allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.status {
+	case breakerOpen:
+		if time.Since(b.openedAt) < b.timeout {
+			return false
+		}
+		b.setStatus(breakerHalfOpen)
+		b.probing = true
+		return true
+	case breakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	default:
+		return true
+	}
+}
+
+// Record reports the outcome of an allowed call. Errors that say nothing about
+// the backend's health, such as a missing key, count as successes.
+func (b *CircuitBreaker) Record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if errors.Is(err, context.Canceled) {
+		// The caller gave up; that says nothing either way, so let another call probe.
+		b.probing = false
+		return
+	}
+
+	healthy := err == nil || !isRetryable(err)
+	if b.status == breakerHalfOpen {
+		b.probing = false
+		if healthy {
+			b.failures = 0
+			b.setStatus(breakerClosed)
+		} else {
+			b.trip()
+		}
+		return
+	}
+
+	if healthy {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.failures >= b.threshold {
+		b.trip()
+	}
+}
+
+func (b *CircuitBreaker) trip() {
+	b.openedAt = time.Now()
+	b.setStatus(breakerOpen)
+}
+
+func (b *CircuitBreaker) setStatus(status breakerStatus) {
+	if b.status != status {
+		b.log.WithField("state", status.String()).Warn("Circuit breaker state changed")
+	}
+	b.status = status
+	breakerState.WithLabelValues(b.backend).Set(float64(status))
+}
+
+// call runs fn through the breaker, retrying according to cfg.
+func (b *CircuitBreaker) call(ctx context.Context, cfg config.RetryConfig, fn func(ctx context.Context) error) error {
+	return retry(ctx, cfg, b.backend, func(ctx context.Context) error {
+		if !b.Allow() {
+			return ErrCircuitOpen
+		}
+		err := fn(ctx)
+		b.Record(err)
+		return err
+	})
+}
+
+// ResilientStorage decorates an ImageStorage with retries and a circuit breaker.
+// While the breaker is open, calls fail fast with ErrCircuitOpen.
+type ResilientStorage struct {
+	origin  ImageStorage
+	retry   config.RetryConfig
+	breaker *CircuitBreaker
+}
+
+// NewResilientStorage wraps origin with the given retry policy and breaker settings.
+func NewResilientStorage(origin ImageStorage, cfg config.ResilienceConfig, logger *logrus.Logger) *ResilientStorage {
+	return &ResilientStorage{
+		origin:  origin,
+		retry:   cfg.Retry,
+		breaker: NewCircuitBreaker("storage", cfg.Breaker, logger),
+	}
+}
+
+// Get retrieves an image from the wrapped storage.
+func (s *ResilientStorage) Get(ctx context.Context, key string) ([]byte, *ObjectInfo, error) {
+	var data []byte
+	var info *ObjectInfo
+	err := s.breaker.call(ctx, s.retry, func(ctx context.Context) error {
+		var err error
+		data, info, err = s.origin.Get(ctx, key)
+		return err
+	})
+	return data, info, err
+}
+
+// Stat retrieves an object's version from the wrapped storage.
+func (s *ResilientStorage) Stat(ctx context.Context, key, ifNoneMatch string) (*ObjectInfo, error) {
+	var info *ObjectInfo
+	err := s.breaker.call(ctx, s.retry, func(ctx context.Context) error {
+		var err error
+		info, err = s.origin.Stat(ctx, key, ifNoneMatch)
+		return err
+	})
+	return info, err
+}
+
+// Purge forwards to the wrapped storage if it holds a cache.
+func (s *ResilientStorage) Purge(ctx context.Context, tag string) (int, error) {
+	if p, ok := s.origin.(Purger); ok {
+		return p.Purge(ctx, tag)
+	}
+	return 0, nil
+}
+
+// PurgePrefix forwards to the wrapped storage if it holds a cache.
+func (s *ResilientStorage) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	if p, ok := s.origin.(Purger); ok {
+		return p.PurgePrefix(ctx, prefix)
+	}
+	return 0, nil
+}
+
+// ResilientCache decorates an ImageCache with retries and a circuit breaker.
+// While the breaker is open, reads are reported as misses so requests go
+// straight to the origin, and writes are rejected with ErrCircuitOpen.
+type ResilientCache struct {
+	cache   ImageCache
+	retry   config.RetryConfig
+	breaker *CircuitBreaker
+	log     *logrus.Entry
+}
+
+// NewResilientCache wraps cache with the given retry policy and breaker settings.
+func NewResilientCache(cache ImageCache, cfg config.ResilienceConfig, logger *logrus.Logger) *ResilientCache {
+	return &ResilientCache{
+		cache:   cache,
+		retry:   cfg.Retry,
+		breaker: NewCircuitBreaker("cache", cfg.Breaker, logger),
+		log:     logger.WithField("component", "ResilientCache"),
+	}
+}
+
+// Get retrieves a fresh item from the wrapped cache.
+func (c *ResilientCache) Get(ctx context.Context, key string) ([]byte, error) {
+	entry, err := c.GetEntry(ctx, key)
+	if err != nil || entry == nil || entry.Stale {
+		return nil, err
+	}
+	return entry.Data, nil
+}
+
+// GetEntry retrieves an item from the wrapped cache, bypassing it while the breaker is open.
+func (c *ResilientCache) GetEntry(ctx context.Context, key string) (*CacheEntry, error) {
+	var entry *CacheEntry
+	err := c.breaker.call(ctx, c.retry, func(ctx context.Context) error {
+		var err error
+		entry, err = c.cache.GetEntry(ctx, key)
+		return err
+	})
+	if errors.Is(err, ErrCircuitOpen) {
+		c.log.WithField("key", key).Debug("Cache circuit open, bypassing")
+		return nil, nil
+	}
+	return entry, err
+}
+
+// Set adds an item to the wrapped cache.
+func (c *ResilientCache) Set(ctx context.Context, key string, data []byte, tags ...string) error {
+	return c.breaker.call(ctx, c.retry, func(ctx context.Context) error {
+		return c.cache.Set(ctx, key, data, tags...)
+	})
+}
+
+// Purge removes every item tagged with tag from the wrapped cache.
+func (c *ResilientCache) Purge(ctx context.Context, tag string) (int, error) {
+	var purged int
+	err := c.breaker.call(ctx, c.retry, func(ctx context.Context) error {
+		var err error
+		purged, err = c.cache.Purge(ctx, tag)
+		return err
+	})
+	return purged, err
+}
+
+// PurgePrefix removes every item with a tag starting with prefix from the wrapped cache.
+func (c *ResilientCache) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	var purged int
+	err := c.breaker.call(ctx, c.retry, func(ctx context.Context) error {
+		var err error
+		purged, err = c.cache.PurgePrefix(ctx, prefix)
+		return err
+	})
+	return purged, err
+}
@@ -0,0 +1,180 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+
+	"watermark/internal/config"
+)
+
+var errBackendDown = &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+
+// responseError returns an S3 error response with the given status.
+func responseError(status int) error {
+	return &smithyhttp.ResponseError{
+		Response: &smithyhttp.Response{Response: &http.Response{StatusCode: status}},
+		Err:      errors.New(http.StatusText(status)),
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{err: errBackendDown, want: true},
+		{err: context.DeadlineExceeded, want: true},
+		{err: responseError(http.StatusServiceUnavailable), want: true},
+		{err: responseError(http.StatusTooManyRequests), want: true},
+		{err: responseError(http.StatusForbidden), want: false},
+		{err: &smithy.GenericAPIError{Code: "SlowDown"}, want: true},
+		{err: &smithy.GenericAPIError{Code: "AccessDenied"}, want: false},
+		{err: errors.New("malformed request"), want: false},
+		{err: fmt.Errorf("s3 object a: %w", ErrNotFound), want: false},
+		{err: ErrTooLarge, want: false},
+		{err: ErrCircuitOpen, want: false},
+		{err: context.Canceled, want: false},
+	}
+	for _, tt := range tests {
+		if got := isRetryable(tt.err); got != tt.want {
+			t.Errorf("isRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestRetry(t *testing.T) {
+	cfg := config.RetryConfig{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: 2 * time.Millisecond}
+	ctx := context.Background()
+
+	t.Run("stops after MaxAttempts", func(t *testing.T) {
+		calls := 0
+		err := retry(ctx, cfg, "test", func(ctx context.Context) error {
+			calls++
+			return errBackendDown
+		})
+		if !errors.Is(err, errBackendDown) || calls != 3 {
+			t.Errorf("retry() = %v after %d calls, want the last error after 3", err, calls)
+		}
+	})
+
+	t.Run("stops on success", func(t *testing.T) {
+		calls := 0
+		err := retry(ctx, cfg, "test", func(ctx context.Context) error {
+			if calls++; calls < 2 {
+				return errBackendDown
+			}
+			return nil
+		})
+		if err != nil || calls != 2 {
+			t.Errorf("retry() = %v after %d calls, want success after 2", err, calls)
+		}
+	})
+
+	t.Run("doesn't repeat definitive answers", func(t *testing.T) {
+		calls := 0
+		retry(ctx, cfg, "test", func(ctx context.Context) error {
+			calls++
+			return ErrNotFound
+		})
+		if calls != 1 {
+			t.Errorf("a missing key was tried %d times, want 1", calls)
+		}
+	})
+}
+
+func TestCircuitBreaker(t *testing.T) {
+	b := NewCircuitBreaker("test", config.BreakerConfig{FailureThreshold: 2, OpenTimeout: 20 * time.Millisecond}, testLogger())
+	call := func(err error) bool {
+		if !b.Allow() {
+			return false
+		}
+		b.Record(err)
+		return true
+	}
+	wantStatus := func(want breakerStatus) {
+		t.Helper()
+		if b.status != want {
+			t.Fatalf("breaker is %s, want %s", b.status, want)
+		}
+	}
+
+	// Misses and successes don't count towards the threshold, and a success
+	// resets it.
+	call(errBackendDown)
+	call(ErrNotFound)
+	call(nil)
+	call(errBackendDown)
+	wantStatus(breakerClosed)
+
+	call(errBackendDown)
+	wantStatus(breakerOpen)
+	if call(nil) {
+		t.Fatal("open breaker allowed a call")
+	}
+
+	// After the timeout, one probe goes through; a failed probe reopens it.
+	time.Sleep(25 * time.Millisecond)
+	if !b.Allow() {
+		t.Fatal("breaker didn't allow a probe after the open timeout")
+	}
+	wantStatus(breakerHalfOpen)
+	if b.Allow() {
+		t.Fatal("breaker allowed a second call while probing")
+	}
+	b.Record(errBackendDown)
+	wantStatus(breakerOpen)
+
+	// A canceled probe frees the slot for another; a successful one closes it.
+	time.Sleep(25 * time.Millisecond)
+	call(context.Canceled)
+	wantStatus(breakerHalfOpen)
+	if !call(nil) {
+		t.Fatal("breaker didn't allow a probe after a canceled one")
+	}
+	wantStatus(breakerClosed)
+}
+
+func TestResilientCacheBypassesWhenOpen(t *testing.T) {
+	cfg := config.ResilienceConfig{
+		Retry:   config.RetryConfig{MaxAttempts: 1},
+		Breaker: config.BreakerConfig{FailureThreshold: 1, OpenTimeout: time.Hour},
+	}
+	c := NewResilientCache(failingCache{}, cfg, testLogger())
+	ctx := context.Background()
+
+	if _, err := c.GetEntry(ctx, "k"); !errors.Is(err, errBackendDown) {
+		t.Fatalf("first GetEntry() = %v, want the backend's error", err)
+	}
+	if entry, err := c.GetEntry(ctx, "k"); entry != nil || err != nil {
+		t.Errorf("GetEntry() with the breaker open = %v, %v; want a miss", entry, err)
+	}
+	if err := c.Set(ctx, "k", nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Set() with the breaker open = %v, want ErrCircuitOpen", err)
+	}
+}
+
+type failingCache struct{}
+
+func (failingCache) Get(ctx context.Context, key string) ([]byte, error) { return nil, errBackendDown }
+
+func (failingCache) GetEntry(ctx context.Context, key string) (*CacheEntry, error) {
+	return nil, errBackendDown
+}
+
+func (failingCache) Set(ctx context.Context, key string, data []byte, tags ...string) error {
+	return errBackendDown
+}
+
+func (failingCache) Purge(ctx context.Context, tag string) (int, error) { return 0, errBackendDown }
+
+func (failingCache) PurgePrefix(ctx context.Context, prefix string) (int, error) {
+	return 0, errBackendDown
+}
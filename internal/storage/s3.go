@@ -1,28 +1,52 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/sirupsen/logrus"
 
-	appConfig "watermark-service/internal/config"
+	appConfig "watermark/internal/config"
 )
 
 // S3Storage implements the ImageStorage interface for AWS S3 and compatible services.
 type S3Storage struct {
-	client *s3.Client
-	bucket string
-	prefix string
-	log    *logrus.Entry
+	client   *s3.Client
+	bucket   string
+	prefix   string
+	maxBytes int64
+	log      *logrus.Entry
 }
 
 // NewS3Storage creates a new S3 storage backend.
 func NewS3Storage(cfg appConfig.S3Config, logger *logrus.Logger) (*S3Storage, error) {
+	client, err := newS3Client(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Storage{
+		client:   client,
+		bucket:   cfg.Bucket,
+		prefix:   cfg.Prefix,
+		maxBytes: cfg.MaxObjectBytes,
+		log:      logger.WithField("component", "S3Storage"),
+	}, nil
+}
+
+// newS3Client builds an S3 client from the connection settings in cfg.
+func newS3Client(cfg appConfig.S3Config) (*s3.Client, error) {
 	awsCfg, err := config.LoadDefaultConfig(context.TODO(),
 		config.WithRegion(cfg.Region),
 		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(cfg.AccessKeyID, cfg.SecretAccessKey, "")),
@@ -31,33 +55,17 @@ func NewS3Storage(cfg appConfig.S3Config, logger *logrus.Logger) (*S3Storage, er
 		return nil, fmt.Errorf("failed to load AWS config: %w", err)
 	}
 
-	// For S3-compatible services like R2, a custom endpoint is needed.
-	customResolver := aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+	return s3.NewFromConfig(awsCfg, func(o *s3.Options) {
+		// For S3-compatible services like R2, a custom endpoint is needed.
 		if cfg.Endpoint != "" {
-			return aws.Endpoint{
-				URL:               cfg.Endpoint,
-				HostnameImmutable: true,
-				Source:            aws.EndpointSourceCustom,
-			}, nil
+			o.BaseEndpoint = aws.String(cfg.Endpoint)
+			o.UsePathStyle = true
 		}
-		// fall back to default resolution
-		return aws.Endpoint{}, &aws.EndpointNotFoundError{}
-	})
-
-	client := s3.NewFromConfig(awsCfg, func(o *s3.Options) {
-		o.EndpointResolverV2 = customResolver
-	})
-
-	return &S3Storage{
-		client: client,
-		bucket: cfg.Bucket,
-		prefix: cfg.Prefix,
-		log:    logger.WithField("component", "S3Storage"),
-	}, nil
+	}), nil
 }
 
-// Get retrieves an image from S3.
-func (s *S3Storage) Get(ctx context.Context, key string) ([]byte, error) {
+// Get retrieves an image from S3, with the version from the same response.
+func (s *S3Storage) Get(ctx context.Context, key string) ([]byte, *ObjectInfo, error) {
 	fullKey := s.prefix + key
 	s.log.WithField("key", fullKey).Info("Getting from S3")
 
@@ -65,20 +73,111 @@ func (s *S3Storage) Get(ctx context.Context, key string) ([]byte, error) {
 		Bucket: aws.String(s.bucket),
 		Key:    aws.String(fullKey),
 	})
+	if isS3NotFound(err) {
+		s.log.WithField("key", fullKey).Info("Object not found in S3")
+		return nil, nil, fmt.Errorf("s3 object %s: %w", fullKey, ErrNotFound)
+	}
 	if err != nil {
 		s.log.WithError(err).WithField("key", fullKey).Error("Failed to get object from S3")
-		return nil, fmt.Errorf("could not get object from s3: %w", err)
+		return nil, nil, fmt.Errorf("could not get object from s3: %w", err)
 	}
 	defer result.Body.Close()
 
+	if s.maxBytes > 0 && aws.ToInt64(result.ContentLength) > s.maxBytes {
+		return nil, nil, fmt.Errorf("s3 object %s is %d bytes: %w", fullKey, aws.ToInt64(result.ContentLength), ErrTooLarge)
+	}
+
 	// This is not the most efficient way, but it's simple.
 	// For very large files, streaming would be better.
 	buf := new(bytes.Buffer)
-	_, err = io.Copy(buf, result.Body)
+	body := io.Reader(result.Body)
+	if s.maxBytes > 0 {
+		// Content-Length can't always be trusted, so guard the read as well.
+		body = io.LimitReader(result.Body, s.maxBytes+1)
+	}
+	_, err = io.Copy(buf, body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read object body: %w", err)
+		return nil, nil, fmt.Errorf("failed to read object body: %w", err)
+	}
+	if s.maxBytes > 0 && int64(buf.Len()) > s.maxBytes {
+		return nil, nil, fmt.Errorf("s3 object %s exceeds %d bytes: %w", fullKey, s.maxBytes, ErrTooLarge)
 	}
 
 	s.log.WithField("key", fullKey).Info("Successfully got object from S3")
-	return buf.Bytes(), nil
+	info := &ObjectInfo{
+		ETag:         aws.ToString(result.ETag),
+		LastModified: aws.ToTime(result.LastModified),
+		Metadata:     result.Metadata,
+	}
+	return buf.Bytes(), info, nil
+}
+
+// Stat retrieves an object's version from S3 with a (conditional) HEAD request.
+func (s *S3Storage) Stat(ctx context.Context, key, ifNoneMatch string) (*ObjectInfo, error) {
+	fullKey := s.prefix + key
+	input := &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(fullKey),
+	}
+	if ifNoneMatch != "" {
+		input.IfNoneMatch = aws.String(ifNoneMatch)
+	}
+
+	result, err := s.client.HeadObject(ctx, input)
+	if isS3NotModified(err) {
+		return nil, ErrNotModified
+	}
+	if isS3NotFound(err) {
+		return nil, fmt.Errorf("s3 object %s: %w", fullKey, ErrNotFound)
+	}
+	if err != nil {
+		s.log.WithError(err).WithField("key", fullKey).Error("Failed to head object in S3")
+		return nil, fmt.Errorf("could not head object in s3: %w", err)
+	}
+
+	info := &ObjectInfo{
+		ETag:         aws.ToString(result.ETag),
+		LastModified: aws.ToTime(result.LastModified),
+		Metadata:     result.Metadata,
+	}
+	if ifNoneMatch != "" && info.ETag == ifNoneMatch {
+		// Some S3-compatible services ignore If-None-Match on HEAD.
+		return nil, ErrNotModified
+	}
+	return info, nil
+}
+
+// Ping checks that the bucket exists and is reachable with the configured credentials.
+func (s *S3Storage) Ping(ctx context.Context) error {
+	if _, err := s.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(s.bucket)}); err != nil {
+		return fmt.Errorf("s3 bucket %s is not reachable: %w", s.bucket, err)
+	}
+	return nil
+}
+
+// isS3NotModified reports whether err is a 304 answer to a conditional request.
+func isS3NotModified(err error) bool {
+	var respErr *smithyhttp.ResponseError
+	if errors.As(err, &respErr) && respErr.HTTPStatusCode() == http.StatusNotModified {
+		return true
+	}
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotModified"
+}
+
+// isS3NotFound reports whether err means the object does not exist.
+// Some S3-compatible services return a bare NotFound code instead of NoSuchKey.
+func isS3NotFound(err error) bool {
+	var noSuchKey *types.NoSuchKey
+	if errors.As(err, &noSuchKey) {
+		return true
+	}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.ErrorCode() {
+		case "NoSuchKey", "NotFound":
+			return true
+		}
+	}
+	return false
 }
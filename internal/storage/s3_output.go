@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/sirupsen/logrus"
+
+	appConfig "watermark/internal/config"
+)
+
+// S3Output implements the OutputStorage interface for AWS S3 and compatible services.
+// Objects are served from PublicBaseURL when configured, otherwise through presigned URLs.
+type S3Output struct {
+	client        *s3.Client
+	presigner     *s3.PresignClient
+	bucket        string
+	prefix        string
+	publicBaseURL string
+	presignTTL    time.Duration
+	log           *logrus.Entry
+}
+
+// NewS3Output creates a destination for rendered images, reusing the connection settings in s3Cfg.
+func NewS3Output(s3Cfg appConfig.S3Config, cfg appConfig.PublishConfig, logger *logrus.Logger) (*S3Output, error) {
+	client, err := newS3Client(s3Cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3Output{
+		client:        client,
+		presigner:     s3.NewPresignClient(client),
+		bucket:        cfg.Bucket,
+		prefix:        cfg.Prefix,
+		publicBaseURL: strings.TrimSuffix(cfg.PublicBaseURL, "/"),
+		presignTTL:    cfg.PresignTTL,
+		log:           logger.WithField("component", "S3Output"),
+	}, nil
+}
+
+// Put uploads a rendered image.
+func (o *S3Output) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	fullKey := o.prefix + key
+	o.log.WithField("key", fullKey).Info("Putting to S3")
+
+	_, err := o.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(o.bucket),
+		Key:         aws.String(fullKey),
+		Body:        bytes.NewReader(data),
+		ContentType: aws.String(contentType),
+	})
+	if err != nil {
+		o.log.WithError(err).WithField("key", fullKey).Error("Failed to put object to S3")
+		return fmt.Errorf("could not put object to s3: %w", err)
+	}
+	return nil
+}
+
+// Exists reports whether a rendered image has already been published.
+func (o *S3Output) Exists(ctx context.Context, key string) (bool, error) {
+	fullKey := o.prefix + key
+	_, err := o.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(fullKey),
+	})
+	if isS3NotFound(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("could not head object in s3: %w", err)
+	}
+	return true, nil
+}
+
+// URL returns the public URL of a published image, or a presigned one if no public base URL is set.
+func (o *S3Output) URL(ctx context.Context, key string) (string, error) {
+	fullKey := o.prefix + key
+	if o.publicBaseURL != "" {
+		return o.publicBaseURL + "/" + fullKey, nil
+	}
+
+	req, err := o.presigner.PresignGetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(o.bucket),
+		Key:    aws.String(fullKey),
+	}, s3.WithPresignExpires(o.presignTTL))
+	if err != nil {
+		return "", fmt.Errorf("could not presign s3 object: %w", err)
+	}
+	return req.URL, nil
+}
+
+// Ping checks that the destination bucket exists and is reachable.
+func (o *S3Output) Ping(ctx context.Context) error {
+	if _, err := o.client.HeadBucket(ctx, &s3.HeadBucketInput{Bucket: aws.String(o.bucket)}); err != nil {
+		return fmt.Errorf("s3 bucket %s is not reachable: %w", o.bucket, err)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package warmer
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"watermark/internal/measure"
+	"watermark/internal/service"
+)
+
+// Item is a single image to warm.
+type Item struct {
+	ImageID string `json:"image_id"`
+	service.RenderParams
+}
+
+// Spec is the JSON form of a warm request.
+type Spec struct {
+	Defaults service.RenderParams `json:"defaults"`
+	Items    []Item               `json:"items"`
+}
+
+// Requests merges each item with the defaults and validates the result. With
+// stored, weight and dimensions may be left out for the image's stored
+// metadata to provide.
+func (s Spec) Requests(stored bool) ([]service.ProcessRequest, error) {
+	reqs := make([]service.ProcessRequest, 0, len(s.Items))
+	for i, item := range s.Items {
+		req, err := item.WithDefaults(s.Defaults).Request(item.ImageID, stored)
+		if err != nil {
+			return nil, fmt.Errorf("item %d: %w", i+1, err)
+		}
+		reqs = append(reqs, req)
+	}
+	return reqs, nil
+}
+
+// ParseJSON reads a Spec.
+func ParseJSON(r io.Reader) (Spec, error) {
+	var spec Spec
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&spec); err != nil {
+		return Spec{}, fmt.Errorf("invalid warm request: %w", err)
+	}
+	return spec, nil
+}
+
+// ParseList reads one image key per line. Blank lines and lines starting with
+// # are skipped. A line may also be CSV with the columns
+// image_id,weight,dimensions, where empty columns fall back to defaults.
+// A first line starting with "image_id" is treated as a header.
+func ParseList(r io.Reader, defaults service.RenderParams) (Spec, error) {
+	spec := Spec{Defaults: defaults}
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if lineNo == 1 && strings.HasPrefix(line, "image_id") {
+			continue
+		}
+
+		fields, err := csv.NewReader(strings.NewReader(line)).Read()
+		if err != nil {
+			return Spec{}, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if len(fields) > 3 {
+			return Spec{}, fmt.Errorf("line %d: expected at most 3 columns, got %d", lineNo, len(fields))
+		}
+
+		item := Item{ImageID: strings.TrimSpace(fields[0])}
+		if len(fields) > 1 && strings.TrimSpace(fields[1]) != "" {
+			weight, err := measure.ParseWeight(fields[1], measure.Kilogram)
+			if err != nil {
+				return Spec{}, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			item.Weight = &weight
+		}
+		if len(fields) > 2 && strings.TrimSpace(fields[2]) != "" {
+			dimensions, err := measure.ParseDimensions(fields[2], measure.Centimetre)
+			if err != nil {
+				return Spec{}, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			item.Dimensions = &dimensions
+		}
+		spec.Items = append(spec.Items, item)
+	}
+	if err := scanner.Err(); err != nil {
+		return Spec{}, err
+	}
+	return spec, nil
+}
@@ -0,0 +1,239 @@
+package warmer
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/sirupsen/logrus"
+
+	"watermark/internal/service"
+)
+
+var warmedImages = promauto.NewCounterVec(prometheus.CounterOpts{
+	Name: "cache_warm_items_total",
+	Help: "The total number of images processed by cache warming, by result.",
+}, []string{"result"})
+
+const (
+	// maxFailures bounds how many failures a task keeps for reporting.
+	maxFailures = 100
+	// maxFinishedTasks bounds how many finished tasks are kept for status queries.
+	maxFinishedTasks = 50
+)
+
+// Task statuses.
+const (
+	StatusRunning  = "running"
+	StatusDone     = "done"
+	StatusCanceled = "canceled"
+)
+
+// Renderer populates the cache for a request.
+type Renderer interface {
+	ProcessImage(ctx context.Context, req service.ProcessRequest) (*service.ProcessResult, error)
+}
+
+// Failure records an image that could not be warmed.
+type Failure struct {
+	ImageID string `json:"image_id"`
+	Error   string `json:"error"`
+}
+
+// Status is a point-in-time view of a warm task.
+type Status struct {
+	ID            string     `json:"id"`
+	Status        string     `json:"status"`
+	Total         int        `json:"total"`
+	Completed     int        `json:"completed"`
+	Rendered      int        `json:"rendered"`
+	AlreadyCached int        `json:"already_cached"`
+	Failed        int        `json:"failed"`
+	Failures      []Failure  `json:"failures,omitempty"`
+	StartedAt     time.Time  `json:"started_at"`
+	FinishedAt    *time.Time `json:"finished_at,omitempty"`
+}
+
+type task struct {
+	mu     sync.Mutex
+	status Status
+}
+
+func (t *task) snapshot() Status {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.status
+	s.Failures = append([]Failure(nil), t.status.Failures...)
+	return s
+}
+
+func (t *task) record(req service.ProcessRequest, result *service.ProcessResult, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.status.Completed++
+	switch {
+	case err != nil:
+		t.status.Failed++
+		if len(t.status.Failures) < maxFailures {
+			t.status.Failures = append(t.status.Failures, Failure{ImageID: req.ImageID, Error: err.Error()})
+		}
+		warmedImages.WithLabelValues("failed").Inc()
+	case result.CacheStatus == service.CacheHit:
+		t.status.AlreadyCached++
+		warmedImages.WithLabelValues("cached").Inc()
+	default:
+		t.status.Rendered++
+		warmedImages.WithLabelValues("rendered").Inc()
+	}
+}
+
+// Warmer populates the cache in the background, a bounded number of images at a time.
+type Warmer struct {
+	renderer    Renderer
+	concurrency int
+	log         *logrus.Entry
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu    sync.Mutex
+	tasks map[string]*task
+}
+
+// NewWarmer creates a Warmer rendering at most concurrency images at once per task.
+func NewWarmer(renderer Renderer, concurrency int, logger *logrus.Logger) *Warmer {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Warmer{
+		renderer:    renderer,
+		concurrency: concurrency,
+		log:         logger.WithField("component", "Warmer"),
+		ctx:         ctx,
+		cancel:      cancel,
+		tasks:       make(map[string]*task),
+	}
+}
+
+// Start begins warming reqs in the background and returns the new task's status.
+func (w *Warmer) Start(reqs []service.ProcessRequest) (Status, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return Status{}, err
+	}
+
+	t := &task{status: Status{
+		ID:        hex.EncodeToString(id),
+		Status:    StatusRunning,
+		Total:     len(reqs),
+		StartedAt: time.Now().UTC(),
+	}}
+
+	w.mu.Lock()
+	w.pruneLocked()
+	w.tasks[t.status.ID] = t
+	w.mu.Unlock()
+
+	w.wg.Add(1)
+	go w.run(t, reqs)
+
+	return t.snapshot(), nil
+}
+
+// Get returns the status of a task.
+func (w *Warmer) Get(id string) (Status, bool) {
+	w.mu.Lock()
+	t, ok := w.tasks[id]
+	w.mu.Unlock()
+	if !ok {
+		return Status{}, false
+	}
+	return t.snapshot(), true
+}
+
+// Close cancels running tasks and waits for them to stop, or for ctx to be done.
+func (w *Warmer) Close(ctx context.Context) error {
+	w.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		w.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (w *Warmer) run(t *task, reqs []service.ProcessRequest) {
+	defer w.wg.Done()
+	log := w.log.WithField("task_id", t.status.ID)
+	log.WithField("total", len(reqs)).Info("Cache warming started")
+
+	sem := make(chan struct{}, w.concurrency)
+	var wg sync.WaitGroup
+	for _, req := range reqs {
+		select {
+		case sem <- struct{}{}:
+		case <-w.ctx.Done():
+		}
+		if w.ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(req service.ProcessRequest) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			result, err := w.renderer.ProcessImage(w.ctx, req)
+			t.record(req, result, err)
+		}(req)
+	}
+	wg.Wait()
+
+	t.mu.Lock()
+	now := time.Now().UTC()
+	t.status.FinishedAt = &now
+	t.status.Status = StatusDone
+	if w.ctx.Err() != nil {
+		t.status.Status = StatusCanceled
+	}
+	t.mu.Unlock()
+
+	status := t.snapshot()
+	log.WithField("rendered", status.Rendered).
+		WithField("already_cached", status.AlreadyCached).
+		WithField("failed", status.Failed).
+		Info("Cache warming finished")
+}
+
+// pruneLocked drops the oldest finished tasks beyond maxFinishedTasks. w.mu must be held.
+func (w *Warmer) pruneLocked() {
+	var finished []Status
+	for _, t := range w.tasks {
+		if s := t.snapshot(); s.FinishedAt != nil {
+			finished = append(finished, s)
+		}
+	}
+	if len(finished) <= maxFinishedTasks {
+		return
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].FinishedAt.Before(*finished[j].FinishedAt)
+	})
+	for _, s := range finished[:len(finished)-maxFinishedTasks] {
+		delete(w.tasks, s.ID)
+	}
+}
@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// APIKey is a static API key. Only the key's hash is kept.
+type APIKey struct {
+	Name   string
+	Hash   string // Hex SHA-256 of the key
+	Scopes []string
+}
+
+// HashAPIKey returns the hex SHA-256 of key, as stored in API key entries.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseAPIKeys parses API key entries separated by semicolons or newlines.
+// Each entry has the form name:sha256hex:scope[,scope...]. Blank lines and
+// lines starting with # are ignored.
+func ParseAPIKeys(spec string) ([]APIKey, error) {
+	var keys []APIKey
+	for _, entry := range strings.FieldsFunc(spec, func(r rune) bool { return r == ';' || r == '\n' }) {
+		entry = strings.TrimSpace(entry)
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 || parts[0] == "" {
+			return nil, fmt.Errorf("invalid API key entry %q: want name:sha256hex:scopes", entry)
+		}
+		hash := strings.ToLower(parts[1])
+		if b, err := hex.DecodeString(hash); err != nil || len(b) != sha256.Size {
+			return nil, fmt.Errorf("invalid API key entry %q: hash must be a hex SHA-256", parts[0])
+		}
+		var scopes []string
+		for _, scope := range strings.Split(parts[2], ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				scopes = append(scopes, scope)
+			}
+		}
+		keys = append(keys, APIKey{Name: parts[0], Hash: hash, Scopes: scopes})
+	}
+	return keys, nil
+}
+
+// LoadAPIKeysFile reads API key entries from path, one per line.
+func LoadAPIKeysFile(path string) ([]APIKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read API keys file: %w", err)
+	}
+	keys, err := ParseAPIKeys(string(data))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return keys, nil
+}
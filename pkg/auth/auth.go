@@ -0,0 +1,164 @@
+// Package auth authenticates API clients with static API keys or JWTs and
+// describes them as a Principal with a set of scopes.
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Scopes guarding the service's routes.
+const (
+	ScopeRead  = "read"
+	ScopeBatch = "batch"
+	ScopeAdmin = "admin"
+)
+
+// Authentication methods reported in Principal.Method.
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+var (
+	ErrNoCredentials      = errors.New("no credentials")
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// Principal is an authenticated client.
+type Principal struct {
+	// Subject is the API key name or the JWT subject.
+	Subject string
+	Method  string
+	Scopes  []string
+}
+
+// HasScope reports whether the principal was granted scope.
+func (p *Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying p.
+func WithPrincipal(ctx context.Context, p *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, p)
+}
+
+// PrincipalFromContext returns the principal stored in ctx, or nil for anonymous requests.
+func PrincipalFromContext(ctx context.Context) *Principal {
+	p, _ := ctx.Value(principalKey{}).(*Principal)
+	return p
+}
+
+// Options configures an Authenticator. File contents are loaded by New.
+type Options struct {
+	// APIKeys holds API key entries separated by semicolons or newlines; see ParseAPIKeys.
+	APIKeys string
+	// APIKeysFile names a file of API key entries, one per line.
+	APIKeysFile string
+	// AdminToken, if set, is accepted as an API key named "admin" with the admin scope.
+	AdminToken string
+
+	// JWTSecret is the HS256 secret for JWTs.
+	JWTSecret string
+	// JWTPublicKeyFile names a PEM RSA (RS256) or Ed25519 (EdDSA) public key for JWTs.
+	JWTPublicKeyFile string
+	// JWKSFile names a local JSON Web Key Set for JWTs.
+	JWKSFile string
+	// JWTIssuer and JWTAudience, if set, must match the token's iss and aud claims.
+	JWTIssuer   string
+	JWTAudience string
+}
+
+// Authenticator checks the credentials presented with a request.
+type Authenticator struct {
+	keys map[string]APIKey // by hash
+	jwt  *JWTVerifier
+}
+
+// New builds an Authenticator from opts, loading any key files it names.
+func New(opts Options) (*Authenticator, error) {
+	keys, err := ParseAPIKeys(opts.APIKeys)
+	if err != nil {
+		return nil, err
+	}
+	if opts.APIKeysFile != "" {
+		fileKeys, err := LoadAPIKeysFile(opts.APIKeysFile)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, fileKeys...)
+	}
+	if opts.AdminToken != "" {
+		keys = append(keys, APIKey{Name: "admin", Hash: HashAPIKey(opts.AdminToken), Scopes: []string{ScopeAdmin}})
+	}
+
+	var verificationKeys []VerificationKey
+	if opts.JWTSecret != "" {
+		verificationKeys = append(verificationKeys, VerificationKey{Key: []byte(opts.JWTSecret)})
+	}
+	if opts.JWTPublicKeyFile != "" {
+		key, err := LoadPublicKeyFile(opts.JWTPublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		verificationKeys = append(verificationKeys, VerificationKey{Key: key})
+	}
+	if opts.JWKSFile != "" {
+		jwks, err := LoadJWKSFile(opts.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		verificationKeys = append(verificationKeys, jwks...)
+	}
+
+	a := &Authenticator{keys: make(map[string]APIKey, len(keys))}
+	for _, k := range keys {
+		if _, dup := a.keys[k.Hash]; dup {
+			return nil, fmt.Errorf("API key %q is configured twice", k.Name)
+		}
+		a.keys[k.Hash] = k
+	}
+	if len(verificationKeys) > 0 {
+		a.jwt = NewJWTVerifier(verificationKeys, opts.JWTIssuer, opts.JWTAudience)
+	}
+	return a, nil
+}
+
+// Authenticate identifies the client behind r. API keys are read from the
+// X-API-Key header or a bearer token; bearer tokens that look like a JWT are
+// verified as one. It returns ErrNoCredentials if r carries none.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return a.authenticateAPIKey(key)
+	}
+
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return nil, ErrNoCredentials
+	}
+	if strings.Count(token, ".") == 2 {
+		if a.jwt == nil {
+			return nil, fmt.Errorf("%w: JWTs are not accepted", ErrInvalidCredentials)
+		}
+		return a.jwt.Verify(token)
+	}
+	return a.authenticateAPIKey(token)
+}
+
+func (a *Authenticator) authenticateAPIKey(key string) (*Principal, error) {
+	k, ok := a.keys[HashAPIKey(key)]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown API key", ErrInvalidCredentials)
+	}
+	return &Principal{Subject: k.Name, Method: MethodAPIKey, Scopes: k.Scopes}, nil
+}
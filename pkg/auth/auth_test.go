@@ -0,0 +1,93 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseAPIKeys(t *testing.T) {
+	hash := HashAPIKey("k-thumbs")
+	keys, err := ParseAPIKeys("# clients\nthumbs:" + strings.ToUpper(hash) + ":read, batch ;\n\nops:" + HashAPIKey("k-ops") + ":admin")
+	if err != nil {
+		t.Fatalf("ParseAPIKeys: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("ParseAPIKeys() = %+v, want 2 keys", keys)
+	}
+	if keys[0].Name != "thumbs" || keys[0].Hash != hash || strings.Join(keys[0].Scopes, ",") != "read,batch" {
+		t.Errorf("first key = %+v", keys[0])
+	}
+
+	for _, spec := range []string{
+		"thumbs:" + hash,                // no scopes field
+		":" + hash + ":read",            // no name
+		"thumbs:k-thumbs:read",          // plaintext instead of a hash
+		"thumbs:" + hash[:10] + ":read", // truncated hash
+	} {
+		if _, err := ParseAPIKeys(spec); err == nil {
+			t.Errorf("ParseAPIKeys(%q): want an error", spec)
+		}
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	a, err := New(Options{
+		APIKeys:    "thumbs:" + HashAPIKey("k-thumbs") + ":read",
+		AdminToken: "admin-token",
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	request := func(header, value string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/image/a.jpg", nil)
+		if header != "" {
+			r.Header.Set(header, value)
+		}
+		return r
+	}
+
+	for name, r := range map[string]*http.Request{
+		"X-API-Key":    request("X-API-Key", "k-thumbs"),
+		"bearer token": request("Authorization", "bearer k-thumbs"),
+	} {
+		p, err := a.Authenticate(r)
+		if err != nil || p.Subject != "thumbs" || p.Method != MethodAPIKey || !p.HasScope(ScopeRead) || p.HasScope(ScopeAdmin) {
+			t.Errorf("%s: Authenticate() = %+v, %v", name, p, err)
+		}
+	}
+
+	if p, err := a.Authenticate(request("Authorization", "Bearer admin-token")); err != nil || !p.HasScope(ScopeAdmin) {
+		t.Errorf("admin token: Authenticate() = %+v, %v", p, err)
+	}
+	if _, err := a.Authenticate(request("", "")); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("no credentials: Authenticate() = %v, want ErrNoCredentials", err)
+	}
+	if _, err := a.Authenticate(request("Authorization", "Basic dXNlcjpwYXNz")); !errors.Is(err, ErrNoCredentials) {
+		t.Errorf("basic auth: Authenticate() = %v, want ErrNoCredentials", err)
+	}
+	if _, err := a.Authenticate(request("X-API-Key", "k-unknown")); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("unknown key: Authenticate() = %v, want ErrInvalidCredentials", err)
+	}
+	if _, err := a.Authenticate(request("Authorization", "Bearer a.b.c")); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("JWT without a verifier: Authenticate() = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestNewRejectsDuplicateKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys")
+	if err := os.WriteFile(path, []byte("copy:"+HashAPIKey("k-thumbs")+":read\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err := New(Options{
+		APIKeys:     "thumbs:" + HashAPIKey("k-thumbs") + ":read",
+		APIKeysFile: path,
+	})
+	if err == nil {
+		t.Error("New() with the same key twice: want an error")
+	}
+}
@@ -0,0 +1,290 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+)
+
+// clockSkew is tolerated when checking exp and nbf.
+const clockSkew = 30 * time.Second
+
+// VerificationKey is a key JWTs may be signed with. Key is a []byte HS256
+// secret, an *rsa.PublicKey for RS256 or an ed25519.PublicKey for EdDSA; the
+// key type decides which algorithm it accepts.
+type VerificationKey struct {
+	ID  string
+	Key interface{}
+}
+
+// JWTVerifier verifies compact-serialized JWTs.
+type JWTVerifier struct {
+	keys     []VerificationKey
+	issuer   string
+	audience string
+	now      func() time.Time
+}
+
+// NewJWTVerifier creates a verifier accepting tokens signed with any of keys.
+// Empty issuer or audience aren't checked.
+func NewJWTVerifier(keys []VerificationKey, issuer, audience string) *JWTVerifier {
+	return &JWTVerifier{keys: keys, issuer: issuer, audience: audience, now: time.Now}
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Subject   string     `json:"sub"`
+	Issuer    string     `json:"iss"`
+	Audience  stringList `json:"aud"`
+	ExpiresAt *float64   `json:"exp"`
+	NotBefore *float64   `json:"nbf"`
+	Scope     stringList `json:"scope"`
+	Scp       stringList `json:"scp"`
+}
+
+// stringList decodes a claim that may be a single string or an array of strings.
+type stringList []string
+
+func (l *stringList) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*l = stringList{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*l = list
+	return nil
+}
+
+// Verify checks token's signature and claims and returns its principal. Scopes
+// come from the space-separated scope claim or the scp claim. Tokens must expire.
+func (v *JWTVerifier) Verify(token string) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed JWT", ErrInvalidCredentials)
+	}
+
+	var header jwtHeader
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("%w: bad JWT header: %v", ErrInvalidCredentials, err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: bad JWT signature encoding", ErrInvalidCredentials)
+	}
+	if !v.verifySignature(header, parts[0]+"."+parts[1], sig) {
+		return nil, fmt.Errorf("%w: JWT signature doesn't verify", ErrInvalidCredentials)
+	}
+
+	var claims jwtClaims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("%w: bad JWT claims: %v", ErrInvalidCredentials, err)
+	}
+	if err := v.checkClaims(claims); err != nil {
+		return nil, err
+	}
+
+	var scopes []string
+	for _, s := range append(claims.Scope, claims.Scp...) {
+		scopes = append(scopes, strings.Fields(s)...)
+	}
+	return &Principal{Subject: claims.Subject, Method: MethodJWT, Scopes: scopes}, nil
+}
+
+// verifySignature tries every key usable with the token's algorithm, or only
+// the one named by kid if the token has one.
+func (v *JWTVerifier) verifySignature(header jwtHeader, signed string, sig []byte) bool {
+	for _, k := range v.keys {
+		if header.Kid != "" && k.ID != "" && k.ID != header.Kid {
+			continue
+		}
+		if verifyWithKey(header.Alg, k.Key, signed, sig) {
+			return true
+		}
+	}
+	return false
+}
+
+func verifyWithKey(alg string, key interface{}, signed string, sig []byte) bool {
+	switch key := key.(type) {
+	case []byte:
+		if alg != "HS256" {
+			return false
+		}
+		mac := hmac.New(sha256.New, key)
+		mac.Write([]byte(signed))
+		return hmac.Equal(sig, mac.Sum(nil))
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return false
+		}
+		digest := sha256.Sum256([]byte(signed))
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig) == nil
+	case ed25519.PublicKey:
+		if alg != "EdDSA" {
+			return false
+		}
+		return ed25519.Verify(key, []byte(signed), sig)
+	}
+	return false
+}
+
+func (v *JWTVerifier) checkClaims(claims jwtClaims) error {
+	now := v.now()
+	if claims.ExpiresAt == nil {
+		return fmt.Errorf("%w: JWT has no expiry", ErrInvalidCredentials)
+	}
+	if now.After(time.Unix(int64(*claims.ExpiresAt), 0).Add(clockSkew)) {
+		return fmt.Errorf("%w: JWT has expired", ErrInvalidCredentials)
+	}
+	if claims.NotBefore != nil && now.Add(clockSkew).Before(time.Unix(int64(*claims.NotBefore), 0)) {
+		return fmt.Errorf("%w: JWT is not valid yet", ErrInvalidCredentials)
+	}
+	if v.issuer != "" && claims.Issuer != v.issuer {
+		return fmt.Errorf("%w: unexpected JWT issuer %q", ErrInvalidCredentials, claims.Issuer)
+	}
+	if v.audience != "" && !containsString(claims.Audience, v.audience) {
+		return fmt.Errorf("%w: JWT is not meant for this audience", ErrInvalidCredentials)
+	}
+	if claims.Subject == "" {
+		return fmt.Errorf("%w: JWT has no subject", ErrInvalidCredentials)
+	}
+	return nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// LoadPublicKeyFile reads a PEM-encoded RSA or Ed25519 public key.
+func LoadPublicKeyFile(path string) (interface{}, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWT public key: %w", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s: no PEM data found", path)
+	}
+
+	var key interface{}
+	switch block.Type {
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	switch key.(type) {
+	case *rsa.PublicKey, ed25519.PublicKey:
+		return key, nil
+	}
+	return nil, fmt.Errorf("%s: unsupported public key type %T", path, key)
+}
+
+// jwk is the subset of RFC 7517 JSON Web Key fields needed for verification.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	K   string `json:"k"`
+}
+
+// LoadJWKSFile reads the signing keys from a JSON Web Key Set. RSA, Ed25519
+// (OKP) and symmetric (oct) keys are supported; encryption keys are skipped.
+func LoadJWKSFile(path string) ([]VerificationKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JWKS file: %w", err)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	var keys []VerificationKey
+	for i, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			return nil, fmt.Errorf("%s: key %d (%s): %w", path, i, k.Kid, err)
+		}
+		keys = append(keys, VerificationKey{ID: k.Kid, Key: key})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("%s: no signing keys", path)
+	}
+	return keys, nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("bad modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("bad exponent: %w", err)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "OKP":
+		if k.Crv != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("bad Ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	case "oct":
+		secret, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil || len(secret) == 0 {
+			return nil, fmt.Errorf("bad symmetric key")
+		}
+		return secret, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
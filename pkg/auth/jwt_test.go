@@ -0,0 +1,208 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var testNow = time.Unix(1700000000, 0)
+
+// claims returns a valid claim set for testNow; fields can be overridden or
+// deleted (nil) with edits.
+func claims(edits map[string]interface{}) map[string]interface{} {
+	c := map[string]interface{}{
+		"sub":   "svc-thumbnails",
+		"iss":   "https://auth.example.com",
+		"aud":   "watermark",
+		"exp":   testNow.Add(time.Hour).Unix(),
+		"scope": "read batch",
+	}
+	for k, v := range edits {
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+// makeToken builds a compact JWT, signing header.payload with sign.
+func makeToken(t *testing.T, header, payload map[string]interface{}, sign func(signed []byte) []byte) string {
+	t.Helper()
+	segment := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+	signed := segment(header) + "." + segment(payload)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func hs256(secret []byte) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+}
+
+func newTestVerifier(keys ...VerificationKey) *JWTVerifier {
+	v := NewJWTVerifier(keys, "https://auth.example.com", "watermark")
+	v.now = func() time.Time { return testNow }
+	return v
+}
+
+func TestJWTVerifyHS256(t *testing.T) {
+	secret := []byte("jwt-secret")
+	v := newTestVerifier(VerificationKey{Key: secret})
+
+	token := makeToken(t, map[string]interface{}{"alg": "HS256", "typ": "JWT"}, claims(nil), hs256(secret))
+	p, err := v.Verify(token)
+	if err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+	want := &Principal{Subject: "svc-thumbnails", Method: MethodJWT, Scopes: []string{"read", "batch"}}
+	if !reflect.DeepEqual(p, want) {
+		t.Errorf("Verify() = %+v, want %+v", p, want)
+	}
+
+	// Scopes may also come as an scp array, and aud as a list.
+	token = makeToken(t, map[string]interface{}{"alg": "HS256"},
+		claims(map[string]interface{}{"scope": nil, "scp": []string{"admin"}, "aud": []string{"other", "watermark"}}),
+		hs256(secret))
+	if p, err := v.Verify(token); err != nil || !p.HasScope(ScopeAdmin) {
+		t.Errorf("scp and aud lists: Verify() = %+v, %v", p, err)
+	}
+
+	if _, err := v.Verify(makeToken(t, map[string]interface{}{"alg": "HS256"}, claims(nil), hs256([]byte("wrong")))); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong secret: Verify() = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+// An RS256 verifier must not accept an HS256 token "signed" with its public
+// key, and nothing accepts alg none.
+func TestJWTRejectsAlgorithmConfusion(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pubPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	v := newTestVerifier(VerificationKey{Key: &rsaKey.PublicKey})
+
+	rs256 := func(signed []byte) []byte {
+		digest := sha256.Sum256(signed)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, rsaKey, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+	if _, err := v.Verify(makeToken(t, map[string]interface{}{"alg": "RS256"}, claims(nil), rs256)); err != nil {
+		t.Fatalf("genuine RS256 token: Verify() = %v", err)
+	}
+
+	forged := makeToken(t, map[string]interface{}{"alg": "HS256"}, claims(nil), hs256(pubPEM))
+	if _, err := v.Verify(forged); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("HS256 signed with the public key: Verify() = %v, want ErrInvalidCredentials", err)
+	}
+	unsigned := makeToken(t, map[string]interface{}{"alg": "none"}, claims(nil), func([]byte) []byte { return nil })
+	if _, err := v.Verify(unsigned); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("alg none: Verify() = %v, want ErrInvalidCredentials", err)
+	}
+	// The RS256 signature is fine, but the header claims another algorithm.
+	relabeled := makeToken(t, map[string]interface{}{"alg": "EdDSA"}, claims(nil), rs256)
+	if _, err := v.Verify(relabeled); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("relabeled algorithm: Verify() = %v, want ErrInvalidCredentials", err)
+	}
+}
+
+func TestJWTClaims(t *testing.T) {
+	secret := []byte("jwt-secret")
+	v := newTestVerifier(VerificationKey{Key: secret})
+
+	tests := []struct {
+		name  string
+		edits map[string]interface{}
+		ok    bool
+	}{
+		{name: "expired within clock skew", edits: map[string]interface{}{"exp": testNow.Add(-10 * time.Second).Unix()}, ok: true},
+		{name: "expired", edits: map[string]interface{}{"exp": testNow.Add(-time.Minute).Unix()}},
+		{name: "no expiry", edits: map[string]interface{}{"exp": nil}},
+		{name: "not valid yet", edits: map[string]interface{}{"nbf": testNow.Add(time.Minute).Unix()}},
+		{name: "nbf within clock skew", edits: map[string]interface{}{"nbf": testNow.Add(10 * time.Second).Unix()}, ok: true},
+		{name: "other issuer", edits: map[string]interface{}{"iss": "https://evil.example.com"}},
+		{name: "other audience", edits: map[string]interface{}{"aud": "billing"}},
+		{name: "no audience", edits: map[string]interface{}{"aud": nil}},
+		{name: "no subject", edits: map[string]interface{}{"sub": nil}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			token := makeToken(t, map[string]interface{}{"alg": "HS256"}, claims(tt.edits), hs256(secret))
+			_, err := v.Verify(token)
+			if tt.ok && err != nil {
+				t.Errorf("Verify() = %v, want success", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("Verify() = %v, want ErrInvalidCredentials", err)
+			}
+		})
+	}
+}
+
+func TestJWKSKeySelection(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("jwks-secret")
+	jwks, _ := json.Marshal(map[string]interface{}{"keys": []map[string]string{
+		{"kty": "OKP", "crv": "Ed25519", "kid": "ed", "x": base64.RawURLEncoding.EncodeToString(pub)},
+		{"kty": "oct", "kid": "hs", "k": base64.RawURLEncoding.EncodeToString(secret)},
+		{"kty": "RSA", "kid": "enc", "use": "enc"},
+	}})
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, jwks, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := LoadJWKSFile(path)
+	if err != nil {
+		t.Fatalf("LoadJWKSFile: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("loaded %d keys, want 2 (encryption keys skipped)", len(keys))
+	}
+	v := newTestVerifier(keys...)
+
+	eddsa := func(signed []byte) []byte { return ed25519.Sign(priv, signed) }
+	if _, err := v.Verify(makeToken(t, map[string]interface{}{"alg": "EdDSA", "kid": "ed"}, claims(nil), eddsa)); err != nil {
+		t.Errorf("EdDSA with its kid: Verify() = %v", err)
+	}
+	if _, err := v.Verify(makeToken(t, map[string]interface{}{"alg": "HS256", "kid": "hs"}, claims(nil), hs256(secret))); err != nil {
+		t.Errorf("HS256 with its kid: Verify() = %v", err)
+	}
+	if _, err := v.Verify(makeToken(t, map[string]interface{}{"alg": "HS256", "kid": "ed"}, claims(nil), hs256(secret))); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("kid naming another key: Verify() = %v, want ErrInvalidCredentials", err)
+	}
+}
@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"watermark/pkg/auth"
+)
+
+func TestAuthMiddleware(t *testing.T) {
+	authn, err := auth.New(auth.Options{
+		APIKeys:    "thumbs:" + auth.HashAPIKey("k-thumbs") + ":read",
+		AdminToken: "admin-token",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var seen *auth.Principal
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		seen = auth.PrincipalFromContext(r.Context())
+	})
+
+	tests := []struct {
+		name     string
+		required bool
+		key      string
+		want     int
+		wantWho  string
+	}{
+		{name: "anonymous allowed", want: http.StatusOK},
+		{name: "anonymous refused", required: true, want: http.StatusUnauthorized},
+		{name: "bad key refused even when optional", key: "k-unknown", want: http.StatusUnauthorized},
+		{name: "key with the scope", required: true, key: "k-thumbs", want: http.StatusOK, wantWho: "thumbs"},
+		{name: "key without the scope", required: true, key: "admin-token", want: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			seen = nil
+			r := httptest.NewRequest(http.MethodGet, "/image/a.jpg", nil)
+			if tt.key != "" {
+				r.Header.Set("X-API-Key", tt.key)
+			}
+			w := httptest.NewRecorder()
+			AuthMiddleware(authn, auth.ScopeRead, tt.required, testLogger())(next).ServeHTTP(w, r)
+
+			if w.Code != tt.want {
+				t.Fatalf("status %d, want %d", w.Code, tt.want)
+			}
+			if w.Code == http.StatusUnauthorized && w.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+			if tt.wantWho != "" && (seen == nil || seen.Subject != tt.wantWho) {
+				t.Errorf("handler saw principal %+v, want %s", seen, tt.wantWho)
+			}
+		})
+	}
+}
@@ -1,10 +1,14 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"runtime/debug"
 	"time"
+	"watermark/pkg/auth"
 	"watermark/pkg/logger"
+	"watermark/pkg/signing"
 )
 
 type responseWriter struct {
@@ -24,6 +28,19 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	return size, err
 }
 
+// Unwrap lets http.ResponseController reach the underlying connection, e.g. to
+// extend the write deadline of a streamed response.
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
+
+// requestFields collects values set by inner middleware for the request log line.
+type requestFields struct {
+	principal string
+}
+
+type requestFieldsKey struct{}
+
 func LoggingMiddleware(logger *logger.Logger) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -33,8 +50,9 @@ func LoggingMiddleware(logger *logger.Logger) func(http.Handler) http.Handler {
 				ResponseWriter: w,
 				statusCode:     http.StatusOK,
 			}
+			fields := &requestFields{}
 
-			next.ServeHTTP(rw, r)
+			next.ServeHTTP(rw, r.WithContext(context.WithValue(r.Context(), requestFieldsKey{}, fields)))
 
 			logger.Infow("Request completed",
 				"method", r.Method,
@@ -43,6 +61,7 @@ func LoggingMiddleware(logger *logger.Logger) func(http.Handler) http.Handler {
 				"duration", time.Since(start),
 				"size", rw.size,
 				"remote_addr", r.RemoteAddr,
+				"principal", fields.principal,
 			)
 		})
 	}
@@ -84,3 +103,63 @@ func CORSMiddleware() func(http.Handler) http.Handler {
 		})
 	}
 }
+
+// AuthMiddleware authenticates requests with authn and requires scope. The
+// principal is put on the request context. When required is false, requests
+// without credentials pass through anonymously, but bad credentials are still rejected.
+func AuthMiddleware(authn *auth.Authenticator, scope string, required bool, logger *logger.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			principal, err := authn.Authenticate(r)
+			if errors.Is(err, auth.ErrNoCredentials) && !required {
+				next.ServeHTTP(w, r)
+				return
+			}
+			if err != nil {
+				logger.Warnw("Rejected unauthenticated request",
+					"path", r.URL.Path,
+					"error", err,
+				)
+				w.Header().Set("WWW-Authenticate", `Bearer realm="watermark"`)
+				w.WriteHeader(http.StatusUnauthorized)
+				w.Write([]byte("Unauthorized"))
+				return
+			}
+			if !principal.HasScope(scope) {
+				logger.Warnw("Rejected request without required scope",
+					"path", r.URL.Path,
+					"principal", principal.Subject,
+					"scope", scope,
+				)
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("Forbidden"))
+				return
+			}
+
+			if fields, ok := r.Context().Value(requestFieldsKey{}).(*requestFields); ok {
+				fields.principal = principal.Subject
+			}
+			next.ServeHTTP(w, r.WithContext(auth.WithPrincipal(r.Context(), principal)))
+		})
+	}
+}
+
+// SignedURLMiddleware rejects requests whose URL doesn't carry a valid, unexpired
+// signature from signer, before any work is done for them.
+func SignedURLMiddleware(signer *signing.Signer, logger *logger.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if err := signer.Verify(r.URL.EscapedPath(), r.URL.Query()); err != nil {
+				logger.Warnw("Rejected unsigned or invalid URL",
+					"path", r.URL.Path,
+					"error", err,
+				)
+				w.WriteHeader(http.StatusForbidden)
+				w.Write([]byte("Forbidden"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
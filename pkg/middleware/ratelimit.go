@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"watermark/pkg/auth"
+	"watermark/pkg/logger"
+	"watermark/pkg/ratelimit"
+)
+
+// RateLimitOptions configures RateLimitMiddleware.
+type RateLimitOptions struct {
+	// Hits limits every request.
+	Hits ratelimit.Limit
+	// Renders additionally limits requests that have to render an image.
+	Renders ratelimit.Limit
+	// TrustedProxies are the peers whose X-Forwarded-For header is believed.
+	TrustedProxies []*net.IPNet
+}
+
+// RateLimitMiddleware limits requests per client, identified by API key, JWT
+// subject or client IP. Refused requests get 429 with Retry-After. Render
+// budgets are charged later, by the service, through ratelimit.ChargeRender.
+// If the limiter fails, requests are let through.
+func RateLimitMiddleware(limiter ratelimit.Limiter, opts RateLimitOptions, logger *logger.Logger) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			client := clientKey(r, opts.TrustedProxies)
+
+			if opts.Hits.Enabled() {
+				res, err := limiter.Allow(r.Context(), "hits:"+client, opts.Hits)
+				if err != nil {
+					logger.Warnw("Rate limiter failed, allowing request", "client", client, "error", err)
+				} else {
+					ratelimit.SetHeaders(w.Header(), res)
+					if !res.Allowed {
+						logger.Warnw("Rate limited request", "client", client, "path", r.URL.Path)
+						w.WriteHeader(http.StatusTooManyRequests)
+						w.Write([]byte("Too Many Requests"))
+						return
+					}
+				}
+			}
+
+			if opts.Renders.Enabled() {
+				r = r.WithContext(ratelimit.WithRenderBudget(r.Context(), func(ctx context.Context) error {
+					res, err := limiter.Allow(ctx, "renders:"+client, opts.Renders)
+					if err != nil {
+						logger.Warnw("Rate limiter failed, allowing render", "client", client, "error", err)
+						return nil
+					}
+					if !res.Allowed {
+						logger.Warnw("Rate limited render", "client", client)
+						return &ratelimit.LimitError{Result: res}
+					}
+					return nil
+				}))
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// clientKey identifies the client a request is charged to.
+func clientKey(r *http.Request, trusted []*net.IPNet) string {
+	if p := auth.PrincipalFromContext(r.Context()); p != nil {
+		return p.Method + ":" + p.Subject
+	}
+	return "ip:" + ClientIP(r, trusted)
+}
+
+// ClientIP returns the address of the client behind r. X-Forwarded-For is
+// only followed through peers in trusted: the rightmost address not belonging
+// to a trusted proxy is the client.
+func ClientIP(r *http.Request, trusted []*net.IPNet) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrusted(host, trusted) {
+		return host
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		if !isTrusted(hop, trusted) {
+			return hop
+		}
+		host = hop
+	}
+	return host
+}
+
+func isTrusted(addr string, trusted []*net.IPNet) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTrustedProxies parses a comma-separated list of CIDRs or single IPs.
+func ParseTrustedProxies(spec string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", entry, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"watermark/pkg/ratelimit"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	opts := RateLimitOptions{Hits: ratelimit.PerMinute(60, 2)}
+	handler := RateLimitMiddleware(ratelimit.NewMemoryLimiter(), opts, testLogger())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(remoteAddr string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/image/a.jpg", nil)
+		r.RemoteAddr = remoteAddr
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i := 0; i < 2; i++ {
+		if w := serve("192.0.2.1:1234"); w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "2" {
+			t.Fatalf("request %d: status %d, headers %v", i+1, w.Code, w.Header())
+		}
+	}
+	w := serve("192.0.2.1:5678")
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "1" {
+		t.Errorf("third request: status %d, Retry-After %q; want 429 after 1s", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w := serve("192.0.2.2:1234"); w.Code != http.StatusOK {
+		t.Errorf("another client: status %d, want 200", w.Code)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{name: "direct", remoteAddr: "198.51.100.7:1234", want: "198.51.100.7"},
+		{name: "spoofed header from an untrusted peer", remoteAddr: "198.51.100.7:1234", forwarded: "203.0.113.9", want: "198.51.100.7"},
+		{name: "through a trusted proxy", remoteAddr: "192.0.2.10:1234", forwarded: "203.0.113.9", want: "203.0.113.9"},
+		{name: "client-supplied hops are skipped", remoteAddr: "10.1.2.3:1234", forwarded: "1.1.1.1, 203.0.113.9, 10.0.0.5", want: "203.0.113.9"},
+		{name: "only proxies", remoteAddr: "10.1.2.3:1234", forwarded: "10.0.0.5", want: "10.0.0.5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			if tt.forwarded != "" {
+				r.Header.Set("X-Forwarded-For", tt.forwarded)
+			}
+			if got := ClientIP(r, trusted); got != tt.want {
+				t.Errorf("ClientIP() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if _, err := ParseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("ParseTrustedProxies(10.0.0.0/33): want an error")
+	}
+}
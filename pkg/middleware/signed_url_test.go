@@ -0,0 +1,85 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+
+	"watermark/pkg/logger"
+	"watermark/pkg/signing"
+)
+
+func testLogger() *logger.Logger {
+	return &logger.Logger{SugaredLogger: zap.NewNop().Sugar()}
+}
+
+// A URL signed with SignURL, as watermarkctl sign does, must pass the
+// middleware however the client re-encodes the parts the signature doesn't
+// depend on.
+func TestSignedURLMiddlewareAcceptsSignURL(t *testing.T) {
+	signer, err := signing.NewSigner("k1", map[string][]byte{"k1": []byte("secret")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler := SignedURLMiddleware(signer, testLogger())(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	serve := func(target string) int {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, target, nil))
+		return w.Code
+	}
+	sign := func(rawURL string) string {
+		t.Helper()
+		signed, err := signer.SignURL(rawURL, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	plain := sign("/image/123.jpg?weight=12.5&dimensions=30x20x15")
+	if code := serve(plain); code != http.StatusOK {
+		t.Errorf("signed URL: status %d, want 200", code)
+	}
+	if code := serve(sign("https://img.example.com/image/123.jpg?weight=1&dimensions=1x1x1")); code != http.StatusOK {
+		t.Errorf("signed absolute URL: status %d, want 200", code)
+	}
+
+	// Escaped and non-ASCII paths are signed in their escaped form, which is
+	// what arrives on the wire.
+	if code := serve(sign("/image/summer%20sale/café.jpg?weight=1&dimensions=1x1x1")); code != http.StatusOK {
+		t.Errorf("escaped path: status %d, want 200", code)
+	}
+
+	// Query encoding and order don't matter.
+	spaced := sign("/image/123.jpg?weight=1&dimensions=1x1x1&text=hello+world")
+	if code := serve(strings.Replace(spaced, "hello+world", "hello%20world", 1)); code != http.StatusOK {
+		t.Errorf("re-encoded query value: status %d, want 200", code)
+	}
+	path, query, _ := strings.Cut(plain, "?")
+	params := strings.Split(query, "&")
+	for i, j := 0, len(params)-1; i < j; i, j = i+1, j-1 {
+		params[i], params[j] = params[j], params[i]
+	}
+	if code := serve(path + "?" + strings.Join(params, "&")); code != http.StatusOK {
+		t.Errorf("reordered query: status %d, want 200", code)
+	}
+
+	// Anything that changes what gets rendered is refused.
+	for name, target := range map[string]string{
+		"unsigned":        "/image/123.jpg?weight=12.5&dimensions=30x20x15",
+		"other image":     strings.Replace(plain, "/123.jpg", "/124.jpg", 1),
+		"changed weight":  strings.Replace(plain, "weight=12.5", "weight=99", 1),
+		"added parameter": plain + "&weight=1",
+	} {
+		if code := serve(target); code != http.StatusForbidden {
+			t.Errorf("%s: status %d, want 403", name, code)
+		}
+	}
+}
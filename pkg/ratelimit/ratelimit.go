@@ -0,0 +1,113 @@
+// Package ratelimit implements token bucket rate limiting, in memory for a
+// single instance or in Redis for limits shared across a fleet.
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var ErrLimited = errors.New("rate limit exceeded")
+
+// Limit describes a token bucket: it holds up to Burst tokens and refills at Rate tokens per second.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// PerMinute returns a Limit refilling n tokens per minute with room for burst.
+func PerMinute(n, burst int) Limit {
+	return Limit{Rate: float64(n) / 60, Burst: burst}
+}
+
+// Enabled reports whether the limit restricts anything.
+func (l Limit) Enabled() bool {
+	return l.Rate > 0 && l.Burst > 0
+}
+
+// Result is the outcome of taking a token from a bucket.
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until a token is available when Allowed is false.
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again.
+	Reset time.Duration
+}
+
+// Limiter takes tokens from named buckets.
+type Limiter interface {
+	Allow(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// SetHeaders writes the RateLimit-* headers for res, and Retry-After if the request was refused.
+func SetHeaders(h http.Header, res Result) {
+	h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+	h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+	if !res.Allowed {
+		h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// LimitError is returned when a request is refused. It matches ErrLimited.
+type LimitError struct {
+	Result Result
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("%v, retry after %s", ErrLimited, e.Result.RetryAfter.Round(time.Second))
+}
+
+func (e *LimitError) Is(target error) bool {
+	return target == ErrLimited
+}
+
+type renderBudgetKey struct{}
+
+// WithRenderBudget returns a context whose renders are charged to charge, so
+// that renders can be limited separately from requests served from cache.
+func WithRenderBudget(ctx context.Context, charge func(context.Context) error) context.Context {
+	return context.WithValue(ctx, renderBudgetKey{}, charge)
+}
+
+// ChargeRender takes a token from ctx's render budget, if it has one. It
+// returns a *LimitError if the budget is exhausted.
+func ChargeRender(ctx context.Context) error {
+	if charge, ok := ctx.Value(renderBudgetKey{}).(func(context.Context) error); ok {
+		return charge(ctx)
+	}
+	return nil
+}
+
+// take refills a bucket holding tokens, last updated elapsed ago, and tries
+// to take one token from it. It returns the new token count and the result.
+func take(tokens float64, elapsed time.Duration, limit Limit) (float64, Result) {
+	burst := float64(limit.Burst)
+	tokens = math.Min(burst, tokens+elapsed.Seconds()*limit.Rate)
+
+	res := Result{Limit: limit.Burst}
+	if tokens >= 1 {
+		tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = secondsToDuration((1 - tokens) / limit.Rate)
+	}
+	res.Remaining = int(tokens)
+	res.Reset = secondsToDuration((burst - tokens) / limit.Rate)
+	return tokens, res
+}
+
+func secondsToDuration(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a MemoryLimiter whose clock only moves with advance.
+func newTestLimiter() (*MemoryLimiter, func(time.Duration)) {
+	l := NewMemoryLimiter()
+	now := time.Unix(1700000000, 0)
+	l.lastSweep = now
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+func TestMemoryLimiterRefill(t *testing.T) {
+	ctx := context.Background()
+	l, advance := newTestLimiter()
+	limit := Limit{Rate: 2, Burst: 3} // a token every 500ms
+
+	for i := 0; i < 3; i++ {
+		res, _ := l.Allow(ctx, "client", limit)
+		if !res.Allowed || res.Remaining != 2-i {
+			t.Fatalf("request %d within burst: %+v", i+1, res)
+		}
+	}
+
+	res, _ := l.Allow(ctx, "client", limit)
+	if res.Allowed || res.RetryAfter != 500*time.Millisecond || res.Reset != 1500*time.Millisecond {
+		t.Fatalf("request past burst: %+v, want refused with RetryAfter 500ms and Reset 1.5s", res)
+	}
+
+	// Half a token isn't enough, and the wait shrinks accordingly.
+	advance(250 * time.Millisecond)
+	if res, _ := l.Allow(ctx, "client", limit); res.Allowed || res.RetryAfter != 250*time.Millisecond {
+		t.Fatalf("after 250ms: %+v, want refused with RetryAfter 250ms", res)
+	}
+	advance(250 * time.Millisecond)
+	if res, _ := l.Allow(ctx, "client", limit); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("after 500ms: %+v, want one token", res)
+	}
+
+	// A long pause refills the bucket only up to the burst.
+	advance(time.Hour)
+	for i := 0; i < 3; i++ {
+		l.Allow(ctx, "client", limit)
+	}
+	if res, _ := l.Allow(ctx, "client", limit); res.Allowed {
+		t.Errorf("after an hour: more than Burst requests allowed")
+	}
+
+	if res, _ := l.Allow(ctx, "other", limit); !res.Allowed {
+		t.Errorf("another client shares the bucket: %+v", res)
+	}
+}
+
+func TestMemoryLimiterSweep(t *testing.T) {
+	ctx := context.Background()
+	l, advance := newTestLimiter()
+
+	l.Allow(ctx, "idle", Limit{Rate: 1, Burst: 5})
+	l.Allow(ctx, "busy", Limit{Rate: 0.001, Burst: 5})
+	advance(sweepInterval + time.Second)
+	l.Allow(ctx, "new", Limit{Rate: 1, Burst: 5})
+
+	if _, ok := l.buckets["idle"]; ok {
+		t.Error("a refilled bucket survived the sweep")
+	}
+	if _, ok := l.buckets["busy"]; !ok {
+		t.Error("a partly used bucket was swept")
+	}
+}
+
+func TestSetHeaders(t *testing.T) {
+	h := http.Header{}
+	SetHeaders(h, Result{Allowed: false, Limit: 10, Remaining: 0, RetryAfter: 1200 * time.Millisecond, Reset: 9 * time.Second})
+
+	want := map[string]string{
+		"RateLimit-Limit":     "10",
+		"RateLimit-Remaining": "0",
+		"RateLimit-Reset":     "9",
+		"Retry-After":         "2", // rounded up so clients don't come back too early
+	}
+	for name, value := range want {
+		if got := h.Get(name); got != value {
+			t.Errorf("%s = %q, want %q", name, got, value)
+		}
+	}
+
+	h = http.Header{}
+	SetHeaders(h, Result{Allowed: true, Limit: 10, Remaining: 9})
+	if h.Get("Retry-After") != "" {
+		t.Errorf("allowed request got Retry-After %q", h.Get("Retry-After"))
+	}
+}
+
+func TestChargeRender(t *testing.T) {
+	ctx := context.Background()
+	if err := ChargeRender(ctx); err != nil {
+		t.Errorf("without a budget: ChargeRender() = %v", err)
+	}
+
+	l, _ := newTestLimiter()
+	ctx = WithRenderBudget(ctx, func(ctx context.Context) error {
+		res, _ := l.Allow(ctx, "renders", Limit{Rate: 1, Burst: 1})
+		if !res.Allowed {
+			return &LimitError{Result: res}
+		}
+		return nil
+	})
+	if err := ChargeRender(ctx); err != nil {
+		t.Fatalf("first render: ChargeRender() = %v", err)
+	}
+	err := ChargeRender(ctx)
+	var limitErr *LimitError
+	if !errors.Is(err, ErrLimited) || !errors.As(err, &limitErr) || limitErr.Result.RetryAfter != time.Second {
+		t.Errorf("second render: ChargeRender() = %v, want a LimitError with RetryAfter 1s", err)
+	}
+}
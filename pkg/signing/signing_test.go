@@ -0,0 +1,180 @@
+package signing
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKeys = map[string][]byte{
+	"2023": []byte("old-secret"),
+	"2024": []byte("new-secret"),
+}
+
+// newTestSigner returns a signer using keyID whose clock is stopped at now.
+func newTestSigner(t *testing.T, keyID string, keys map[string][]byte, now time.Time) *Signer {
+	t.Helper()
+	s, err := NewSigner(keyID, keys)
+	if err != nil {
+		t.Fatalf("NewSigner: %v", err)
+	}
+	s.now = func() time.Time { return now }
+	return s
+}
+
+// mustSign signs rawURL and splits the result for Verify.
+func mustSign(t *testing.T, s *Signer, rawURL string, ttl time.Duration) (string, url.Values) {
+	t.Helper()
+	signed, err := s.SignURL(rawURL, ttl)
+	if err != nil {
+		t.Fatalf("SignURL(%s): %v", rawURL, err)
+	}
+	u, err := url.Parse(signed)
+	if err != nil {
+		t.Fatalf("SignURL(%s) = %s, which doesn't parse: %v", rawURL, signed, err)
+	}
+	return u.EscapedPath(), u.Query()
+}
+
+func TestSignURLVerifies(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := newTestSigner(t, "2024", testKeys, now)
+
+	for _, rawURL := range []string{
+		"/image/123.jpg?weight=12.5&dimensions=30x20x15",
+		"https://img.example.com/image/123.jpg?weight=1&dimensions=1x1x1",
+		"/image/summer%20sale/caf%C3%A9.jpg?weight=1&dimensions=1x1x1",
+		"/image/123.jpg?dimensions=1x1x1&weight=1&tag=b&tag=a",
+		"/image/123.jpg",
+	} {
+		path, query := mustSign(t, s, rawURL, time.Hour)
+		if err := s.Verify(path, query); err != nil {
+			t.Errorf("Verify(SignURL(%s)) = %v", rawURL, err)
+		}
+		if query.Get(ParamKeyID) != "2024" {
+			t.Errorf("SignURL(%s) signed with key %q, want 2024", rawURL, query.Get(ParamKeyID))
+		}
+	}
+}
+
+func TestSignURLReplacesExistingSignature(t *testing.T) {
+	s := newTestSigner(t, "2024", testKeys, time.Unix(1700000000, 0))
+
+	path, query := mustSign(t, s, "/image/123.jpg?weight=1&sig=bogus&kid=2023&exp=1", 0)
+	if err := s.Verify(path, query); err != nil {
+		t.Fatalf("Verify() = %v", err)
+	}
+	if len(query[ParamSignature]) != 1 || query.Has(ParamExpires) {
+		t.Errorf("re-signed query = %v, want one sig and no exp", query)
+	}
+}
+
+func TestVerifyRejectsTampering(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	s := newTestSigner(t, "2024", testKeys, now)
+	path, signed := mustSign(t, s, "/image/123.jpg?weight=12.5&dimensions=30x20x15", time.Hour)
+
+	tamper := func(edit func(q url.Values)) url.Values {
+		q := url.Values{}
+		for k, v := range signed {
+			q[k] = append([]string(nil), v...)
+		}
+		edit(q)
+		return q
+	}
+
+	if err := s.Verify("/image/124.jpg", signed); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("other path: Verify() = %v, want ErrInvalidSignature", err)
+	}
+	if err := s.Verify(path, tamper(func(q url.Values) { q.Set("weight", "99") })); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("changed parameter: Verify() = %v, want ErrInvalidSignature", err)
+	}
+	if err := s.Verify(path, tamper(func(q url.Values) { q.Add("dimensions", "1x1x1") })); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("repeated parameter: Verify() = %v, want ErrInvalidSignature", err)
+	}
+	if err := s.Verify(path, tamper(func(q url.Values) { q.Set(ParamExpires, "9999999999") })); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("extended expiry: Verify() = %v, want ErrInvalidSignature", err)
+	}
+	if err := s.Verify(path, tamper(func(q url.Values) { q.Del(ParamExpires) })); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("removed expiry: Verify() = %v, want ErrInvalidSignature", err)
+	}
+	if err := s.Verify(path, tamper(func(q url.Values) { q.Del(ParamSignature) })); !errors.Is(err, ErrMissingSignature) {
+		t.Errorf("no signature: Verify() = %v, want ErrMissingSignature", err)
+	}
+	if err := s.Verify(path, tamper(func(q url.Values) { q.Set(ParamKeyID, "2022") })); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("unknown key: Verify() = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestVerifyExpiry(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	path, query := mustSign(t, newTestSigner(t, "2024", testKeys, now), "/image/123.jpg?weight=1", time.Hour)
+
+	if err := newTestSigner(t, "2024", testKeys, now.Add(time.Hour)).Verify(path, query); err != nil {
+		t.Errorf("at expiry: Verify() = %v", err)
+	}
+	if err := newTestSigner(t, "2024", testKeys, now.Add(time.Hour+time.Second)).Verify(path, query); !errors.Is(err, ErrExpired) {
+		t.Errorf("after expiry: Verify() = %v, want ErrExpired", err)
+	}
+
+	path, query = mustSign(t, newTestSigner(t, "2024", testKeys, now), "/image/123.jpg?weight=1", 0)
+	if err := newTestSigner(t, "2024", testKeys, now.AddDate(10, 0, 0)).Verify(path, query); err != nil {
+		t.Errorf("no ttl, ten years later: Verify() = %v", err)
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	path, query := mustSign(t, newTestSigner(t, "2023", testKeys, now), "/image/123.jpg?weight=1", 0)
+
+	// After switching to the new key, URLs signed with the old one keep working
+	// until the old key is removed from the set.
+	if err := newTestSigner(t, "2024", testKeys, now).Verify(path, query); err != nil {
+		t.Errorf("old key still configured: Verify() = %v", err)
+	}
+	retired := map[string][]byte{"2024": testKeys["2024"]}
+	if err := newTestSigner(t, "2024", retired, now).Verify(path, query); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("old key removed: Verify() = %v, want ErrUnknownKey", err)
+	}
+}
+
+func TestNewSigner(t *testing.T) {
+	if _, err := NewSigner("", map[string][]byte{"only": []byte("s")}); err != nil {
+		t.Errorf("single key without an ID: %v", err)
+	}
+	if _, err := NewSigner("", testKeys); err == nil {
+		t.Error("several keys without an active ID: want an error")
+	}
+	if _, err := NewSigner("2025", testKeys); err == nil {
+		t.Error("unknown active key: want an error")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	keys, err := ParseKeys(" 2023:old-secret, 2024:new:secret ,")
+	if err != nil {
+		t.Fatalf("ParseKeys: %v", err)
+	}
+	if string(keys["2023"]) != "old-secret" || string(keys["2024"]) != "new:secret" || len(keys) != 2 {
+		t.Errorf("ParseKeys() = %q", keys)
+	}
+
+	for _, spec := range []string{"nosecret", ":secret", "id:", "a:1,a:2"} {
+		if _, err := ParseKeys(spec); err == nil {
+			t.Errorf("ParseKeys(%q): want an error", spec)
+		}
+	}
+}
+
+func TestCanonicalQueryIgnoresOrder(t *testing.T) {
+	a := url.Values{"b": {"2", "1"}, "a": {"x y"}, ParamSignature: {"ignored"}}
+	b := url.Values{"a": {"x y"}, "b": {"1", "2"}}
+	if canonicalQuery(a) != canonicalQuery(b) {
+		t.Errorf("canonicalQuery differs: %q vs %q", canonicalQuery(a), canonicalQuery(b))
+	}
+	if strings.Contains(canonicalQuery(a), ParamSignature) {
+		t.Errorf("canonicalQuery(%v) includes the signature", a)
+	}
+}